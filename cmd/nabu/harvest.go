@@ -7,6 +7,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/internetofwater/nabu/internal/config"
 	"github.com/internetofwater/nabu/internal/crawl/storage"
@@ -21,22 +22,70 @@ import (
 // Command to harvest sitemaps and store them in a specified storage destination (S3 or local disk).
 // This was previously known as "gleaner" and is now integrated into the nabu command line tool.
 type HarvestCmd struct {
-	Source                string `arg:"--source" help:"source to crawl from the sitemap"`               // source to crawl from the config
-	IgnoreRobots          bool   `arg:"--ignore-robots" help:"ignore robots.txt"`                       // ignore robots.txt
-	ToDisk                bool   `arg:"--to-disk" default:"false" help:"save to disk instead of minio"` // save to disk instead of minio
-	UseOtel               bool   `arg:"--use-otel"`
-	ConcurrentSitemaps    int    `arg:"--concurrent-sitemaps" default:"10"`
-	SitemapWorkers        int    `arg:"--sitemap-workers" default:"10"`
-	HeadlessChromeUrl     string `arg:"--headless-chrome-url" default:"0.0.0.0:9222" help:"port for interacting with the headless chrome devtools"`
-	ShaclEndpoint         string `arg:"--shacl-grpc-endpoint" default:"" help:"full shacl grpc endpoint with port to use for validation; if empty skip validation"`
-	ExitOnShaclFailure    bool   `arg:"--exit-on-shacl-failure" default:"false" help:"immediately exit if shacl validation fails"`
-	CleanupOutdatedJsonld bool   `arg:"--cleanup-outdated-jsonld" default:"false" help:"cleanup outdated jsonld files from the bucket"`
+	Source                []string                 `arg:"--source" help:"sitemap ids or glob patterns of the sources to crawl from the sitemap; i.e. 'usgs/*'"` // sources to crawl from the config
+	ExcludeSource         []string                 `arg:"--exclude-source" help:"sitemap ids or glob patterns of sources not to crawl, even if they match --source"`
+	IgnoreRobots          bool                     `arg:"--ignore-robots" help:"ignore robots.txt"`                       // ignore robots.txt
+	ToDisk                bool                     `arg:"--to-disk" default:"false" help:"save to disk instead of minio"` // save to disk instead of minio
+	UseOtel               bool                     `arg:"--use-otel"`
+	ConcurrentSitemaps    int                      `arg:"--concurrent-sitemaps" default:"10"`
+	SitemapWorkers        int                      `arg:"--sitemap-workers" default:"10"`
+	HeadlessChromeUrl     string                   `arg:"--headless-chrome-url" default:"0.0.0.0:9222" help:"address of the headless chrome devtools used to render sitemaps marked with geoconnex:render_js"`
+	ShaclEndpoint         string                   `arg:"--shacl-grpc-endpoint" default:"" help:"full shacl grpc endpoint with port to use for validation; if empty skip validation"`
+	ExitOnShaclFailure    bool                     `arg:"--exit-on-shacl-failure" default:"false" help:"immediately exit if shacl validation fails"`
+	CleanupOutdatedJsonld bool                     `arg:"--cleanup-outdated-jsonld" default:"false" help:"cleanup outdated jsonld files from the bucket"`
+	CheckpointInterval    int                      `arg:"--checkpoint-interval" default:"500" help:"number of harvested urls between each checkpoint written to storage; 0 disables checkpoints"`
+	Resume                bool                     `arg:"--resume" default:"false" help:"resume each sitemap from its last checkpoint, skipping urls that were already harvested"`
+	Incremental           bool                     `arg:"--incremental" default:"false" help:"skip urls and sitemaps whose lastmod has not advanced since their last successful harvest"`
+	HostCrawlDelays       map[string]time.Duration `arg:"--host-crawl-delay" help:"minimum delay between requests to a host, overriding its robots.txt Crawl-delay; i.e. geoconnex.us=500ms"`
+	NoProvenance          bool                     `arg:"--no-provenance" default:"false" help:"don't store a PROV-O record of when and where each document was fetched in prov/"`
+	RetryFailedUrls       int                      `arg:"--retry-failed-urls" default:"2" help:"number of times to retry urls that timed out or returned a 5xx once the rest of the sitemap is harvested; 0 disables retries"`
+	RetryBackoff          time.Duration            `arg:"--retry-backoff" default:"10s" help:"delay before the first round of retrying failed urls; it doubles each round so the default of 2 rounds waits 30s in total, but only if some urls failed"`
+	MaxDocumentSizeMB     int64                    `arg:"--max-document-size-mb" default:"32" help:"maximum size in MiB of a document fetched from a single url; larger documents are reported as crawl failures and 0 means no maximum"`
+	MaxBulkLineSizeMB     int64                    `arg:"--max-bulk-line-size-mb" default:"256" help:"maximum size in MiB of a single document output by a bulk container; larger documents are reported as crawl failures and 0 means no maximum"`
+	BulkMemoryMB          int64                    `arg:"--bulk-memory-mb" default:"0" help:"memory limit in MiB of each bulk container; 0 is unlimited"`
+	BulkCpus              float64                  `arg:"--bulk-cpus" default:"0" help:"number of cpus each bulk container may use, i.e. 1.5; 0 is unlimited"`
+	BulkPidsLimit         int64                    `arg:"--bulk-pids-limit" default:"0" help:"maximum number of processes in each bulk container; 0 is unlimited"`
+	BulkMaxRuntime        time.Duration            `arg:"--bulk-max-runtime" default:"0s" help:"kill a bulk container or executable, or stop reading a remote ndjson bulk file, that runs longer than this, i.e. 2h; 0 is unlimited"`
+	BulkStderrTailKB      int                      `arg:"--bulk-stderr-tail-kb" default:"64" help:"KiB from the end of the stderr of a failed bulk container or executable to keep in its crawl report"`
+	AllowLocalBulkSources bool                     `arg:"--allow-local-bulk-sources" default:"false" help:"allow bulk sitemaps to run local executables with exec and read local files with ndjson; these are refused by default"`
+	BulkTolerateInvalid   bool                     `arg:"--bulk-tolerate-invalid-lines" default:"false" help:"record lines of bulk output that aren't valid JSON or have no @id as crawl errors and continue instead of failing the sitemap"`
+	BulkMaxLineErrors     int                      `arg:"--bulk-max-line-errors-to-store" default:"100" help:"maximum number of invalid bulk lines stored in each crawl report; every one is still counted"`
+	BulkMaxErrorRate      float64                  `arg:"--bulk-max-error-rate" default:"0.1" help:"fraction of bulk lines that may be invalid before a tolerant harvest of the sitemap is aborted; 0 means no maximum"`
+	BulkCleanupFraction   float64                  `arg:"--bulk-max-cleanup-fraction" default:"0.5" help:"fraction of the documents stored for a bulk sitemap that --cleanup-outdated-jsonld may remove; a cleanup that would remove more is skipped and 0 means no maximum"`
+	DuplicateIds          string                   `arg:"--duplicate-ids" default:"off" help:"report top level @ids published by more than one sitemap to the metadata bucket; one of off, warn, or error"`
+	Sample                int                      `arg:"--sample" default:"0" help:"harvest only a sample of this many urls from each sitemap to smoke test it; a sample never cleans up outdated jsonld"`
+	SampleStrategy        string                   `arg:"--sample-strategy" default:"random" help:"how urls are sampled; random, or stratified to sample evenly across hosts and url paths"`
+	MaxUrls               int                      `arg:"--max-urls" default:"0" help:"harvest at most this many urls from each sitemap; 0 means no maximum"`
+	DatasetDownThreshold  int                      `arg:"--dataset-down-threshold" default:"20" help:"number of failed urls in a row before a sitemap is assumed to be down; a sitemap can override this with geoconnex:dataset_down_threshold"`
+	MaxShaclErrorsToStore int                      `arg:"--max-shacl-errors-to-store" default:"20" help:"maximum number of shacl errors stored in each crawl report; a sitemap can override this with geoconnex:max_shacl_errors_to_store"`
+	DryRun                bool                     `arg:"--dry-run" default:"false" help:"print the urls that would be fetched or skipped and the files that would be cleaned up as json without storing or removing anything"`
 }
 
 func Harvest(ctx context.Context, client *http.Client, minioConfig config.MinioConfig, args HarvestCmd, sitemapIndex string) ([]pkg.SitemapCrawlStats, error) {
 	if sitemapIndex == "" {
 		return nil, fmt.Errorf("sitemap index must be provided")
 	}
+	if args.MaxDocumentSizeMB < 0 || args.MaxBulkLineSizeMB < 0 {
+		return nil, fmt.Errorf("--max-document-size-mb and --max-bulk-line-size-mb must not be negative; use 0 for no maximum")
+	}
+	if args.BulkMaxErrorRate < 0 || args.BulkMaxErrorRate > 1 {
+		return nil, fmt.Errorf("--bulk-max-error-rate must be between 0 and 1; use 0 for no maximum")
+	}
+	if args.BulkCleanupFraction < 0 || args.BulkCleanupFraction > 1 {
+		return nil, fmt.Errorf("--bulk-max-cleanup-fraction must be between 0 and 1; use 0 for no maximum")
+	}
+	duplicateIdMode, err := crawl.ParseDuplicateIdMode(args.DuplicateIds)
+	if err != nil {
+		return nil, err
+	}
+	sampleStrategy, err := crawl.ParseSampleStrategy(args.SampleStrategy)
+	if err != nil {
+		return nil, err
+	}
+	sources, err := crawl.NewSourceSelection(args.Source, args.ExcludeSource)
+	if err != nil {
+		return nil, err
+	}
 	index, err := crawl.NewSitemapIndex(sitemapIndex, client)
 	if err != nil {
 		return nil, err
@@ -55,18 +104,58 @@ func Harvest(ctx context.Context, client *http.Client, minioConfig config.MinioC
 		if err != nil {
 			return nil, err
 		}
-		if err := minioS3.SetupBuckets(); err != nil {
-			return nil, err
+		// a dry run must not create anything, including the buckets
+		if !args.DryRun {
+			if err := minioS3.SetupBuckets(); err != nil {
+				return nil, err
+			}
 		}
 		storageDestination = minioS3
 	}
 
-	return index.
+	index = index.
 		WithStorageDestination(storageDestination).
 		WithConcurrencyConfig(args.ConcurrentSitemaps, args.SitemapWorkers).
-		WithSpecifiedSourceFilter(args.Source).
+		WithSourceSelection(sources).
 		WithHeadlessChromeUrl(args.HeadlessChromeUrl).
 		WithShaclValidationConfig(args.ShaclEndpoint, args.ExitOnShaclFailure).
 		WithOutdatedJsonldCleanup(args.CleanupOutdatedJsonld).
-		HarvestSitemaps(ctx, client)
+		WithCheckpointConfig(args.CheckpointInterval, args.Resume).
+		WithIncrementalHarvest(args.Incremental).
+		WithHostCrawlDelays(args.HostCrawlDelays).
+		WithIgnoreRobots(args.IgnoreRobots).
+		WithProvenance(!args.NoProvenance).
+		WithEndOfSitemapRetries(args.RetryFailedUrls, args.RetryBackoff).
+		WithMaxDocumentSize(args.MaxDocumentSizeMB<<20, args.MaxBulkLineSizeMB<<20).
+		WithBulkSourceLimits(crawl.BulkSourceLimits{
+			MemoryBytes:     args.BulkMemoryMB << 20,
+			Cpus:            args.BulkCpus,
+			Pids:            args.BulkPidsLimit,
+			MaxRuntime:      args.BulkMaxRuntime,
+			StderrTailBytes: args.BulkStderrTailKB << 10,
+		}).
+		WithLocalBulkSources(args.AllowLocalBulkSources).
+		WithBulkLineErrorTolerance(args.BulkTolerateInvalid, args.BulkMaxLineErrors, args.BulkMaxErrorRate).
+		WithMaxBulkCleanupFraction(args.BulkCleanupFraction).
+		WithDuplicateIdDetection(duplicateIdMode).
+		WithSampling(args.Sample, sampleStrategy, args.MaxUrls).
+		WithErrorThresholds(args.DatasetDownThreshold, args.MaxShaclErrorsToStore)
+
+	if args.DryRun {
+		log.Info("Running a dry run; nothing will be stored or removed")
+		plan, err := index.PlanSitemaps(ctx, client)
+		if err != nil {
+			return nil, err
+		}
+		asJson, err := plan.ToJson()
+		if err != nil {
+			return nil, err
+		}
+		// the json goes to stdout so it can be piped while the summary is logged
+		fmt.Println(asJson)
+		log.Info("Harvest plan:\n" + plan.Summary())
+		return nil, nil
+	}
+
+	return index.HarvestSitemaps(ctx, client)
 }
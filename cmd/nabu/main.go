@@ -37,8 +37,11 @@ type UploadCmd struct{}
 type SyncCmd struct{}
 type TestCmd struct{}
 type ReleaseCmd struct {
-	Compress             bool   `arg:"--compress" help:"compress the output graph with gzip to reduce size; the associated hash will be the hash of the gzip'd data" default:"false"`
-	MainstemMetadataFile string `arg:"--mainstem-metadata" help:"path to a mainstem file, either local or in s3/gcs, that will be used to add metadata to the release graph" default:""`
+	Compress             bool     `arg:"--compress" help:"compress the output graph with gzip to reduce size; the associated hash will be the hash of the gzip'd data" default:"false"`
+	MainstemMetadataFile string   `arg:"--mainstem-metadata" help:"path to a mainstem file, either local or in s3/gcs, that will be used to add metadata to the release graph" default:""`
+	Orgs                 bool     `arg:"--orgs" help:"release a graph describing every source in the sitemap index, its publisher, and its contact as graphs/latest/organizations.nq instead of releasing a prefix" default:"false"`
+	Source               []string `arg:"--source" help:"sitemap ids or glob patterns of the sources to release instead of releasing a prefix; i.e. 'usgs/*'"`
+	ExcludeSource        []string `arg:"--exclude-source" help:"sitemap ids or glob patterns of sources to leave out of the release"`
 }
 type ClearCmd struct{}
 type PullCmd struct {
@@ -53,7 +56,7 @@ type ShaclValidateCmd struct {
 type NabuArgs struct {
 	// Subcommands that can be run
 	Release *ReleaseCmd       `arg:"subcommand:release" help:"generate an nq release graph for all objects under a specific prefix"`
-	Sync    *SyncCmd          `arg:"subcommand:sync" help:"sync the triplestore with the s3 bucket"`
+	Sync    *SyncCmd          `arg:"subcommand:sync" help:"sync the triplestore with the release graphs in the s3 bucket using the SPARQL graph store protocol"`
 	Test    *TestCmd          `arg:"subcommand:test" help:"test the connection to the s3 bucket"`
 	Harvest *HarvestCmd       `arg:"subcommand:harvest" help:"harvest sitemaps and store them in the s3 bucket"`
 	Pull    *PullCmd          `arg:"subcommand:pull" help:"pull all objects under a specific prefix in the s3 bucket"`
@@ -62,6 +65,7 @@ type NabuArgs struct {
 	// Flags that can be set for config particular services / operations
 	config.MinioConfig
 	config.ContextConfig
+	config.SparqlConfig
 
 	// Flags that can be set which affect all operations
 	LogLevel          string            `arg:"--log-level" default:"INFO"`
@@ -81,6 +85,7 @@ func (n NabuArgs) ToStructuredConfig() config.NabuConfig {
 	return config.NabuConfig{
 		Minio:             n.MinioConfig,
 		Context:           n.ContextConfig,
+		Sparql:            n.SparqlConfig,
 		PrefixToFileCache: n.PrefixToFileCache,
 		Prefix:            n.Prefix,
 	}
@@ -189,8 +194,24 @@ func (n NabuRunner) Run(ctx context.Context, client *http.Client) (harvestReport
 		if err != nil {
 			return nil, err
 		}
+		// the organizations graph is built from the sitemap index itself and not from a prefix
+		if n.args.Release.Orgs {
+			return nil, synchronizerClient.GenerateOrgsRelease(ctx, sitemap_index, n.args.Release.Compress)
+		}
+		// release every selected source instead of a single prefix
+		if len(n.args.Release.Source) > 0 || len(n.args.Release.ExcludeSource) > 0 {
+			return nil, ReleaseSources(ctx, synchronizerClient, client, sitemap_index, *n.args.Release)
+		}
+		// the provenance recorded during the harvest is released as its own graph
+		if provenancePrefix, isProvenance := strings.CutPrefix(n.args.Prefix, "prov/"); isProvenance {
+			corresponding_metadata, err := sitemapMetadataForId(sitemap_index, client, provenancePrefix)
+			if err != nil {
+				return nil, err
+			}
+			return nil, synchronizerClient.GenerateProvRelease(ctx, corresponding_metadata, n.args.Release.Compress)
+		}
 		prefix_without_s3_path := strings.TrimPrefix(n.args.Prefix, "summoned/")
-		corresponding_metadata, err := sitemap_index.GetMetadataForSitemapId(prefix_without_s3_path)
+		corresponding_metadata, err := sitemapMetadataForId(sitemap_index, client, prefix_without_s3_path)
 		if err != nil {
 			return nil, err
 		}
@@ -200,6 +221,12 @@ func (n NabuRunner) Run(ctx context.Context, client *http.Client) (harvestReport
 			n.args.Release.Compress,
 			n.args.Release.MainstemMetadataFile,
 		)
+	case n.args.Sync != nil:
+		sitemap_index, err := crawl.NewSitemapIndex(n.args.SitemapIndex, client)
+		if err != nil {
+			return nil, err
+		}
+		return nil, synchronizerClient.SyncGraphs(ctx, sitemap_index)
 	case n.args.Test != nil:
 		return nil, Test(ctx, synchronizerClient)
 	case n.args.Harvest != nil:
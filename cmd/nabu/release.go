@@ -0,0 +1,53 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	crawl "github.com/internetofwater/nabu/internal/crawl"
+	"github.com/internetofwater/nabu/internal/synchronizer"
+	log "github.com/sirupsen/logrus"
+)
+
+// Find the metadata of a sitemap in the index. Nested sitemap indexes are only resolved if the id isn't
+// an entry of the index itself, and then only the entry that may hold the sitemap is fetched
+func sitemapMetadataForId(index crawl.SitemapIndex, httpClient *http.Client, sitemapId string) (crawl.SitemapMetadata, error) {
+	metadata, err := index.GetMetadataForSitemapId(sitemapId)
+	if err == nil {
+		return metadata, nil
+	}
+	index, resolveErr := index.WithSourceSelection(crawl.SourceSelection{Include: []string{sitemapId}}).ResolveNestedIndexes(httpClient)
+	if resolveErr != nil {
+		return crawl.SitemapMetadata{}, resolveErr
+	}
+	return index.GetMetadataForSitemapId(sitemapId)
+}
+
+// Release the graph of every sitemap in the index that is selected by --source and --exclude-source;
+// each is released the same way as passing its summoned prefix with --prefix
+func ReleaseSources(ctx context.Context, client *synchronizer.SynchronizerClient, httpClient *http.Client, index crawl.SitemapIndex, args ReleaseCmd) error {
+	selection, err := crawl.NewSourceSelection(args.Source, args.ExcludeSource)
+	if err != nil {
+		return err
+	}
+	// the sitemaps within nested indexes are released on their own; only the selected entries are fetched
+	index, err = index.WithSourceSelection(selection).ResolveNestedIndexes(httpClient)
+	if err != nil {
+		return err
+	}
+	selected, err := index.SelectedSitemaps()
+	if err != nil {
+		return err
+	}
+	log.Infof("Releasing %d sitemaps", len(selected))
+	for _, sitemap := range selected {
+		if err := client.GenerateNqRelease(ctx, sitemap, args.Compress, args.MainstemMetadataFile); err != nil {
+			return fmt.Errorf("failed to release %s: %w", sitemap.SitemapID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The longest a host is paused for after repeated 429s if no maximum pause was set
+const maxHostThrottlePause = time.Hour
+
+// The rate limiting state of a single host
+type throttleState struct {
+	// the number of 429 responses in a row from the host
+	consecutive429s int
+	// requests to the host should not be sent before this time
+	pausedUntil time.Time
+}
+
+// A signal shared across requests so that when a host keeps
+// returning 429 every request to it slows down, rather than
+// each request backing off on its own. A nil HostThrottle is valid
+// and never slows down requests
+type HostThrottle struct {
+	mu    sync.Mutex
+	hosts map[string]*throttleState
+}
+
+func NewHostThrottle() *HostThrottle {
+	return &HostThrottle{hosts: make(map[string]*throttleState)}
+}
+
+// Record a 429 from the host and pause all requests to it. The pause is
+// the larger of the requested delay and an exponential backoff in the
+// number of consecutive 429s. Returns how long the host is paused for
+func (h *HostThrottle) penalize(host string, requested time.Duration, base time.Duration, maxPause time.Duration) time.Duration {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	state, ok := h.hosts[host]
+	if !ok {
+		state = &throttleState{}
+		h.hosts[host] = state
+	}
+	state.consecutive429s++
+
+	// the first 429 only pauses for the requested delay
+	pause := requested
+	if state.consecutive429s > 1 {
+		limit := maxPause
+		if limit <= 0 {
+			limit = maxHostThrottlePause
+		}
+		// double the base one step at a time so a long run of 429s can't overflow it
+		exponential := base
+		for range state.consecutive429s - 1 {
+			if exponential >= limit {
+				break
+			}
+			exponential *= 2
+		}
+		pause = min(max(pause, exponential), limit)
+	}
+
+	now := time.Now()
+	if until := now.Add(pause); until.After(state.pausedUntil) {
+		if state.consecutive429s > 1 {
+			log.Warnf("%s has returned %d responses with status 429 in a row; pausing all requests to it for %s", host, state.consecutive429s, pause)
+		}
+		state.pausedUntil = until
+	}
+	return state.pausedUntil.Sub(now)
+}
+
+// Clear the consecutive 429 count after a host responds without rate limiting
+func (h *HostThrottle) reset(host string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if state, ok := h.hosts[host]; ok {
+		state.consecutive429s = 0
+	}
+}
+
+// Get how long until requests to the host may be sent again
+func (h *HostThrottle) remainingPause(host string) time.Duration {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	state, ok := h.hosts[host]
+	if !ok {
+		return 0
+	}
+	return max(time.Until(state.pausedUntil), 0)
+}
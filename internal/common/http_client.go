@@ -8,10 +8,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -99,7 +101,17 @@ func NewMockedClient(strictMode bool, urlToMock map[string]MockResponse) *http.C
 type RetryTransport struct {
 	Base    http.RoundTripper
 	Retries int
+	// The base delay before the first retry; it doubles on each later attempt
 	Backoff time.Duration
+	// The maximum delay before a single retry, including delays
+	// from a Retry-After header; 0 means no maximum
+	MaxBackoff time.Duration
+	// The total amount of time a single request may spend waiting
+	// between retries before giving up; 0 means no budget
+	RetryBudget time.Duration
+	// Shared by every request so that a host which keeps returning 429
+	// slows down all requests to it; nil disables host-wide slowdowns
+	Throttle *HostThrottle
 }
 
 // An error returned when the maximum number of retries is exceeded.
@@ -111,25 +123,106 @@ func (e *MaxRetryError) Error() string {
 	return e.Err.Error()
 }
 
+// Parse the Retry-After header which may be either a number of seconds
+// or an HTTP-date; the boolean is false if the header is missing or invalid
+func parseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(header)
+	if err != nil {
+		return 0, false
+	}
+	// a date in the past means we can retry immediately
+	return max(date.Sub(now), 0), true
+}
+
+// Get the delay before retrying the given attempt. This is exponential in the
+// number of attempts with jitter so that concurrent requests don't retry in lockstep
+func (t *RetryTransport) backoff(attempt int) time.Duration {
+	delay := t.Backoff << attempt
+	if t.MaxBackoff > 0 && (delay > t.MaxBackoff || delay <= 0) {
+		delay = t.MaxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+	// keep at least half the delay so retries are always spaced out
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+// Get the delay before retrying a response, preferring the Retry-After header if it was sent
+func (t *RetryTransport) delayForResponse(resp *http.Response, attempt int) time.Duration {
+	delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		return t.backoff(attempt)
+	}
+	if t.MaxBackoff > 0 && delay > t.MaxBackoff {
+		delay = t.MaxBackoff
+	}
+	return delay
+}
+
+// Sleep before the next attempt unless it would exceed the retry budget;
+// waited is the total time spent waiting so far for the request
+func (t *RetryTransport) sleep(req *http.Request, delay time.Duration, waited *time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	if t.RetryBudget > 0 && *waited+delay > t.RetryBudget {
+		return fmt.Errorf("waiting %s more would exceed the retry budget of %s", delay, t.RetryBudget)
+	}
+	*waited += delay
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
 func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	var lastErr error
+	var waited time.Duration
 
 	for i := 0; i < t.Retries; i++ {
+		// wait if other requests have found that the host is rate limiting us
+		if pause := t.Throttle.remainingPause(req.URL.Host); pause > 0 {
+			log.Debugf("waiting %s before requesting %s since the host is rate limiting", pause, req.URL.String())
+			if err := t.sleep(req, pause, &waited); err != nil {
+				return nil, t.giveUp(req, err, lastErr)
+			}
+		}
+
 		resp, err := t.Base.RoundTrip(req)
 
 		if err != nil {
 			var netErr net.Error
 			if errors.As(err, &netErr) && netErr.Timeout() {
 				log.Warnf("retrying after timeout on %s (attempt %d)", req.URL.String(), i+1)
-				time.Sleep(time.Duration(i+1) * t.Backoff)
 				lastErr = err
+				if err := t.sleep(req, t.backoff(i), &waited); err != nil {
+					return nil, t.giveUp(req, err, lastErr)
+				}
 				continue
 			}
 
 			if ue, ok := err.(*url.Error); ok && ue.Timeout() {
 				log.Warnf("retrying after client timeout on %s (attempt %d)", req.URL.String(), i+1)
 				lastErr = err
-				time.Sleep(time.Duration(i+1) * t.Backoff)
+				if err := t.sleep(req, t.backoff(i), &waited); err != nil {
+					return nil, t.giveUp(req, err, lastErr)
+				}
 				continue
 			}
 			return nil, err
@@ -140,23 +233,48 @@ func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 			log.Errorf("got a 404 from %s", req.URL.String())
 			return resp, nil
 		} else if resp.StatusCode == http.StatusTooManyRequests {
-			// if we get a 429, don't retry since the server is rate limiting us
-			// and our retry logic is not sufficient and must be tweaked in some way
-			log.Warnf("got a 429 from %s, not retrying since the server appears to be rate limiting", req.URL.String())
-			return resp, nil
+			delay := t.delayForResponse(resp, i)
+			// slow down the entire host, not just this request
+			delay = max(delay, t.Throttle.penalize(req.URL.Host, delay, t.Backoff, t.MaxBackoff))
+			log.Warnf("got a 429 from %s, retrying in %s (attempt %d)", req.URL.String(), delay, i+1)
+			_ = resp.Body.Close()
+			lastErr = fmt.Errorf("got status %s", resp.Status)
+			if i == t.Retries-1 {
+				break
+			}
+			if err := t.sleep(req, delay, &waited); err != nil {
+				return nil, t.giveUp(req, err, lastErr)
+			}
+			continue
 		} else if resp.StatusCode >= 500 {
 			log.Warnf("got a %d from %s, retrying (attempt %d)", resp.StatusCode, req.URL.String(), i)
 			_ = resp.Body.Close()
-			time.Sleep(time.Duration(i+1) * t.Backoff)
+			lastErr = fmt.Errorf("got status %s", resp.Status)
+			if i == t.Retries-1 {
+				break
+			}
+			if err := t.sleep(req, t.delayForResponse(resp, i), &waited); err != nil {
+				return nil, t.giveUp(req, err, lastErr)
+			}
 			continue
 		}
 
+		t.Throttle.reset(req.URL.Host)
 		return resp, nil
 	}
-	message := fmt.Errorf("failed to get a successful response from %s after %d retries: %v", req.URL.String(), t.Retries, lastErr)
+	return nil, t.giveUp(req, fmt.Errorf("exhausted %d retries", t.Retries), lastErr)
+}
+
+// Create the error returned when a request can't be retried any further
+func (t *RetryTransport) giveUp(req *http.Request, reason error, lastErr error) error {
+	// a cancelled request should be reported as such, not as a retry failure
+	if ctxErr := req.Context().Err(); ctxErr != nil {
+		return ctxErr
+	}
+	message := fmt.Errorf("failed to get a successful response from %s: %v; last error: %v", req.URL.String(), reason, lastErr)
 	// log this early so that we can see it during the run if needed
 	log.Error(message.Error())
-	return nil, &MaxRetryError{Err: message}
+	return &MaxRetryError{Err: message}
 }
 
 // An http transport optimized for long-lived connections
@@ -204,6 +322,11 @@ func NewCrawlerClient() *http.Client {
 		Base:    newLongLivedHttpTransport,
 		Retries: 3,
 		Backoff: 2 * time.Second,
+		// keep this under the client timeout so that
+		// we give up cleanly instead of timing out
+		MaxBackoff:  30 * time.Second,
+		RetryBudget: 60 * time.Second,
+		Throttle:    NewHostThrottle(),
 	}
 
 	return newClientFromRoundTrip(crawlerTransport)
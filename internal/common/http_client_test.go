@@ -69,24 +69,133 @@ func TestNoRetryOn404(t *testing.T) {
 	require.Equal(t, int32(1), callCount, "404 should not be retried")
 }
 
-func TestNoRetryOnTooManyRequests(t *testing.T) {
+func TestRetryOnTooManyRequestsHonorsRetryAfter(t *testing.T) {
 	var callCount int32 = 0
 
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		atomic.AddInt32(&callCount, 1)
-		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		if atomic.AddInt32(&callCount, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
 	}))
 	defer server.Close()
 
-	client := NewCrawlerClient()
+	client := newClientFromRoundTrip(&RetryTransport{
+		Base:     http.DefaultTransport,
+		Retries:  3,
+		Backoff:  time.Millisecond,
+		Throttle: NewHostThrottle(),
+	})
 
-	req, err := http.NewRequest("GET", server.URL, nil)
+	start := time.Now()
+	resp, err := client.Get(server.URL)
 	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, int32(2), callCount, "429 should be retried")
+	require.GreaterOrEqual(t, time.Since(start), time.Second, "should have waited for the Retry-After")
+}
 
-	resp, err := client.Do(req)
-	require.NoError(t, err)
-	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
-	require.Equal(t, int32(1), callCount, "429 should not be retried")
+func TestRetryBudgetIsNotExceeded(t *testing.T) {
+	var callCount int32 = 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&callCount, 1)
+		w.Header().Set("Retry-After", "120")
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := newClientFromRoundTrip(&RetryTransport{
+		Base:        http.DefaultTransport,
+		Retries:     5,
+		Backoff:     time.Millisecond,
+		RetryBudget: time.Second,
+	})
+
+	start := time.Now()
+	_, err := client.Get(server.URL)
+	var maxErr *MaxRetryError
+	require.ErrorAs(t, err, &maxErr)
+	require.Contains(t, err.Error(), "retry budget")
+	require.Equal(t, int32(1), callCount, "should give up instead of waiting past the budget")
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	delay, ok := parseRetryAfter("30", now)
+	require.True(t, ok)
+	require.Equal(t, 30*time.Second, delay)
+
+	delay, ok = parseRetryAfter(now.Add(time.Minute).Format(http.TimeFormat), now)
+	require.True(t, ok)
+	require.Equal(t, time.Minute, delay)
+
+	delay, ok = parseRetryAfter(now.Add(-time.Minute).Format(http.TimeFormat), now)
+	require.True(t, ok)
+	require.Zero(t, delay, "a date in the past means retry immediately")
+
+	for _, invalid := range []string{"", "-1", "soon"} {
+		_, ok = parseRetryAfter(invalid, now)
+		require.False(t, ok, invalid)
+	}
+}
+
+func TestBackoffIsExponentialWithJitter(t *testing.T) {
+	transport := &RetryTransport{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for range 20 {
+		first := transport.backoff(0)
+		require.GreaterOrEqual(t, first, 50*time.Millisecond)
+		require.LessOrEqual(t, first, 100*time.Millisecond)
+
+		third := transport.backoff(2)
+		require.GreaterOrEqual(t, third, 200*time.Millisecond)
+		require.LessOrEqual(t, third, 400*time.Millisecond)
+
+		capped := transport.backoff(10)
+		require.LessOrEqual(t, capped, time.Second)
+	}
+}
+
+func TestRepeated429sSlowDownTheWholeHost(t *testing.T) {
+	throttle := NewHostThrottle()
+
+	// the first 429 only pauses for the requested delay
+	pause := throttle.penalize("example.com", 10*time.Millisecond, time.Second, time.Minute)
+	require.LessOrEqual(t, pause, 10*time.Millisecond)
+
+	// repeated 429s back off exponentially for every request to the host
+	pause = throttle.penalize("example.com", 10*time.Millisecond, time.Second, time.Minute)
+	require.Greater(t, pause, time.Second)
+	require.Greater(t, throttle.remainingPause("example.com"), time.Second)
+	require.Zero(t, throttle.remainingPause("other.example.com"))
+
+	// the pause is capped
+	for range 10 {
+		pause = throttle.penalize("example.com", 0, time.Second, time.Minute)
+	}
+	require.LessOrEqual(t, pause, time.Minute)
+
+	// a successful response resets the backoff but not the current pause
+	throttle.reset("example.com")
+	require.Greater(t, throttle.remainingPause("example.com"), time.Second)
+	pause = throttle.penalize("example.com", 0, time.Second, time.Minute)
+	require.LessOrEqual(t, pause, time.Minute)
+
+	// a long run of 429s without a maximum pause can't overflow the backoff
+	for range 100 {
+		pause = throttle.penalize("unlimited.example.com", 0, time.Second, 0)
+	}
+	require.Greater(t, pause, time.Minute)
+	require.LessOrEqual(t, pause, maxHostThrottlePause)
+
+	// a nil throttle never slows anything down
+	var nilThrottle *HostThrottle
+	require.Zero(t, nilThrottle.penalize("example.com", time.Second, time.Second, 0))
+	require.Zero(t, nilThrottle.remainingPause("example.com"))
 }
 
 func TestMockWithString(t *testing.T) {
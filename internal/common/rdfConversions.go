@@ -4,15 +4,214 @@
 package common
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 
+	"github.com/piprate/json-gold/ld"
 	log "github.com/sirupsen/logrus"
 	rdf "github.com/tggo/goRDFlib"
 	nq "github.com/tggo/goRDFlib/nq"
 	nt "github.com/tggo/goRDFlib/nt"
+	rdfxml "github.com/tggo/goRDFlib/rdfxml"
+	turtle "github.com/tggo/goRDFlib/turtle"
 )
 
+// The media types of the RDF serializations that can be converted to JSON-LD
+const (
+	TurtleMediaType   = "text/turtle"
+	NTriplesMediaType = "application/n-triples"
+	RdfXmlMediaType   = "application/rdf+xml"
+)
+
+// The context used when compacting converted RDF. It is inline so that
+// conversion never has to fetch a remote context, and uses the same prefixes
+// as the geoconnex JSON-LD so that mainstems can be added to it on release
+var rdfConversionContext = map[string]any{
+	"schema": "https://schema.org/",
+	"hyf":    "https://www.opengis.net/def/schema/hy_features/hyf/",
+	"gsp":    "http://www.opengis.net/ont/geosparql#",
+	"xsd":    "http://www.w3.org/2001/XMLSchema#",
+	"rdfs":   "http://www.w3.org/2000/01/rdf-schema#",
+	"dc":     "http://purl.org/dc/terms/",
+	"skos":   "http://www.w3.org/2004/02/skos/core#",
+}
+
+// Return the RDF media type of a Content-Type header
+// or an empty string if it isn't one that can be converted to JSON-LD
+func RdfMediaType(contentType string) string {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	switch mediaType {
+	case TurtleMediaType, NTriplesMediaType, RdfXmlMediaType:
+		return mediaType
+	// some servers still use the media types from before N-Triples was registered
+	case "text/plain+ntriples", "text/n-triples":
+		return NTriplesMediaType
+	}
+	return ""
+}
+
+// Parse an RDF document and serialize it as N-Triples;
+// relative IRIs in the document are resolved against the base IRI
+func rdfToNt(data string, mediaType string, baseIRI string) (string, error) {
+	if mediaType == NTriplesMediaType {
+		return data, nil
+	}
+	graph := rdf.NewGraph()
+	var err error
+	switch mediaType {
+	case TurtleMediaType:
+		err = turtle.Parse(graph, strings.NewReader(data), turtle.WithBase(baseIRI))
+	case RdfXmlMediaType:
+		err = rdfxml.Parse(graph, strings.NewReader(data), rdfxml.WithBase(baseIRI))
+	default:
+		return "", fmt.Errorf("unsupported RDF media type %s", mediaType)
+	}
+	if err != nil {
+		return "", fmt.Errorf("parsing %s: %w", mediaType, err)
+	}
+	var buf strings.Builder
+	if err := nt.Serialize(graph, &buf, nt.WithUnboundedLines()); err != nil {
+		return "", fmt.Errorf("error serializing N-Triples: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// Converting RDF gives each blank node its own top level node; nest each blank node
+// that is only referenced once inside the node that references it instead, so that
+// i.e. a geometry ends up within its feature as it would be in hand written JSON-LD
+func embedBlankNodes(nodes []any) []any {
+	blankNodes := make(map[string]map[string]any)
+	references := make(map[string]int)
+	var countReferences func(value any)
+	countReferences = func(value any) {
+		switch v := value.(type) {
+		case []any:
+			for _, item := range v {
+				countReferences(item)
+			}
+		case map[string]any:
+			if id, ok := v["@id"].(string); ok && len(v) == 1 {
+				references[id]++
+				return
+			}
+			for key, item := range v {
+				if key != "@id" {
+					countReferences(item)
+				}
+			}
+		}
+	}
+	for _, node := range nodes {
+		asMap, ok := node.(map[string]any)
+		if !ok {
+			continue
+		}
+		if id, ok := asMap["@id"].(string); ok && strings.HasPrefix(id, "_:") {
+			blankNodes[id] = asMap
+		}
+		countReferences(asMap)
+	}
+
+	embedded := make(map[string]bool)
+	var embed func(value any, ancestors map[string]bool) any
+	embed = func(value any, ancestors map[string]bool) any {
+		switch v := value.(type) {
+		case []any:
+			for i, item := range v {
+				v[i] = embed(item, ancestors)
+			}
+			return v
+		case map[string]any:
+			id, _ := v["@id"].(string)
+			if node, ok := blankNodes[id]; ok && len(v) == 1 && references[id] == 1 && !ancestors[id] {
+				embedded[id] = true
+				delete(node, "@id")
+				return embed(node, withAncestor(ancestors, id))
+			}
+			for key, item := range v {
+				if key != "@id" {
+					v[key] = embed(item, ancestors)
+				}
+			}
+			return v
+		}
+		return value
+	}
+	ids := make([]string, len(nodes))
+	for i, node := range nodes {
+		if asMap, ok := node.(map[string]any); ok {
+			ids[i], _ = asMap["@id"].(string)
+		}
+	}
+	// start from the nodes that can't be embedded, then from any blank nodes
+	// that are left over since they only reference each other in a cycle
+	for _, onlyLeftovers := range []bool{false, true} {
+		for i, node := range nodes {
+			asMap, ok := node.(map[string]any)
+			if !ok || embedded[ids[i]] {
+				continue
+			}
+			canBeEmbedded := blankNodes[ids[i]] != nil && references[ids[i]] == 1
+			if canBeEmbedded != onlyLeftovers {
+				continue
+			}
+			embed(asMap, withAncestor(nil, ids[i]))
+		}
+	}
+
+	topLevel := []any{}
+	for i, node := range nodes {
+		if !embedded[ids[i]] {
+			topLevel = append(topLevel, node)
+		}
+	}
+	return topLevel
+}
+
+// Copy the set of ancestors with another id added so sibling branches don't share it
+func withAncestor(ancestors map[string]bool, id string) map[string]bool {
+	copied := make(map[string]bool, len(ancestors)+1)
+	for ancestor := range ancestors {
+		copied[ancestor] = true
+	}
+	copied[id] = true
+	return copied
+}
+
+// Convert a Turtle, N-Triples, or RDF/XML document to compacted JSON-LD so that
+// it can be validated and released the same way as documents that were JSON-LD to begin with
+func RdfToJsonld(data string, mediaType string, baseIRI string) (string, error) {
+	triples, err := rdfToNt(data, mediaType, baseIRI)
+	if err != nil {
+		return "", err
+	}
+
+	processor := ld.NewJsonLdProcessor()
+	options := ld.NewJsonLdOptions("")
+	options.Format = "application/n-quads"
+	expanded, err := processor.FromRDF(triples, options)
+	if err != nil {
+		return "", fmt.Errorf("converting %s to JSON-LD: %w", mediaType, err)
+	}
+	nodes, ok := expanded.([]any)
+	if !ok || len(nodes) == 0 {
+		return "", fmt.Errorf("the %s document did not contain any triples", mediaType)
+	}
+
+	// the IRIs are already absolute and compacting against a base would make them relative again
+	compacted, err := processor.Compact(embedBlankNodes(nodes), map[string]any{"@context": rdfConversionContext}, ld.NewJsonLdOptions(""))
+	if err != nil {
+		return "", fmt.Errorf("compacting JSON-LD converted from %s: %w", mediaType, err)
+	}
+	asJson, err := json.Marshal(compacted)
+	if err != nil {
+		return "", err
+	}
+	return string(asJson), nil
+}
+
 // Convert a string of N-Triples to N-Quads
 func NtToNq(ntData, graphURN string) (string, error) {
 	// Create a graph with the desired named graph identifier.
@@ -41,3 +240,9 @@ func NtToNq(ntData, graphURN string) (string, error) {
 	}
 	return buf.String(), nil
 }
+
+// Escape a string for use as an N-Triples literal
+func NTriplesLiteral(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+	return `"` + replacer.Replace(value) + `"`
+}
@@ -0,0 +1,95 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRdfMediaType(t *testing.T) {
+	require.Equal(t, TurtleMediaType, RdfMediaType("text/turtle; charset=utf-8"))
+	require.Equal(t, RdfXmlMediaType, RdfMediaType("Application/RDF+XML"))
+	require.Equal(t, NTriplesMediaType, RdfMediaType("application/n-triples"))
+	require.Equal(t, NTriplesMediaType, RdfMediaType("text/plain+ntriples"))
+	require.Empty(t, RdfMediaType("application/ld+json"))
+	require.Empty(t, RdfMediaType("text/html"))
+}
+
+func TestRdfToJsonld(t *testing.T) {
+	t.Run("n-triples", func(t *testing.T) {
+		triples := `<https://geoconnex.us/ref/gages/1> <https://schema.org/name> "Gage 1" .
+<https://geoconnex.us/ref/gages/1> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <https://www.opengis.net/def/schema/hy_features/hyf/HY_HydrometricFeature> .
+<https://geoconnex.us/ref/gages/1> <http://www.opengis.net/ont/geosparql#hasGeometry> _:geom .
+_:geom <http://www.opengis.net/ont/geosparql#asWKT> "POINT (-105 40)"^^<http://www.opengis.net/ont/geosparql#wktLiteral> .
+`
+		jsonld, err := RdfToJsonld(triples, NTriplesMediaType, "https://geoconnex.us/ref/gages/1")
+		require.NoError(t, err)
+
+		var asMap map[string]any
+		require.NoError(t, json.Unmarshal([]byte(jsonld), &asMap))
+		require.Contains(t, asMap, "@context")
+		require.Equal(t, "https://geoconnex.us/ref/gages/1", asMap["@id"])
+		require.Equal(t, "hyf:HY_HydrometricFeature", asMap["@type"])
+		require.Equal(t, "Gage 1", asMap["schema:name"])
+
+		// the geometry uses the same prefixes as geoconnex jsonld so mainstems can be added on release
+		wkt, hasGeometry := GetWktFromJsonld(asMap)
+		require.True(t, hasGeometry)
+		require.Equal(t, "POINT (-105 40)", wkt)
+
+		// and it can be converted back to the same triples
+		processor, options, err := NewJsonldProcessor(false, nil)
+		require.NoError(t, err)
+		roundTripped, err := JsonldToTriples(jsonld, processor, options)
+		require.NoError(t, err)
+		require.Contains(t, roundTripped, `<https://geoconnex.us/ref/gages/1> <https://schema.org/name> "Gage 1" .`)
+	})
+
+	t.Run("turtle", func(t *testing.T) {
+		document := `@prefix schema: <https://schema.org/> .
+<1> schema:name "Gage 1" .
+`
+		jsonld, err := RdfToJsonld(document, TurtleMediaType, "https://geoconnex.us/ref/gages/")
+		require.NoError(t, err)
+		require.JSONEq(t, `{"@context": `+mustMarshal(t, rdfConversionContext)+`, "@id": "https://geoconnex.us/ref/gages/1", "schema:name": "Gage 1"}`, jsonld)
+	})
+
+	t.Run("blank nodes that only reference each other are kept", func(t *testing.T) {
+		triples := `_:a <https://schema.org/knows> _:b .
+_:b <https://schema.org/knows> _:a .
+`
+		jsonld, err := RdfToJsonld(triples, NTriplesMediaType, "https://geoconnex.us/ref/gages/1")
+		require.NoError(t, err)
+		processor, options, err := NewJsonldProcessor(false, nil)
+		require.NoError(t, err)
+		roundTripped, err := JsonldToTriples(jsonld, processor, options)
+		require.NoError(t, err)
+		require.Len(t, strings.Split(strings.TrimSpace(roundTripped), "\n"), 2)
+	})
+
+	t.Run("empty document", func(t *testing.T) {
+		_, err := RdfToJsonld("", NTriplesMediaType, "https://geoconnex.us/ref/gages/1")
+		require.ErrorContains(t, err, "did not contain any triples")
+	})
+
+	t.Run("invalid n-triples", func(t *testing.T) {
+		_, err := RdfToJsonld("<https://geoconnex.us/ref/gages/1> not valid", NTriplesMediaType, "https://geoconnex.us/ref/gages/1")
+		require.Error(t, err)
+	})
+
+	t.Run("unsupported media type", func(t *testing.T) {
+		_, err := RdfToJsonld("{}", "application/json", "https://geoconnex.us/ref/gages/1")
+		require.ErrorContains(t, err, "unsupported RDF media type")
+	})
+}
+
+func mustMarshal(t *testing.T, v any) string {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return string(data)
+}
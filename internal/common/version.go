@@ -0,0 +1,31 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import "runtime/debug"
+
+// The version of nabu; this is set when building a release with
+// -ldflags "-X github.com/internetofwater/nabu/internal/common.Version=v1.2.3"
+var Version = ""
+
+// Get the version of nabu. If it wasn't set at build time, the module version
+// is used when installed with go install, otherwise the vcs revision it was built from
+func NabuVersion() string {
+	if Version != "" {
+		return Version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return "(devel)"
+}
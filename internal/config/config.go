@@ -7,6 +7,7 @@ package config
 type NabuConfig struct {
 	Minio             MinioConfig
 	Context           ContextConfig
+	Sparql            SparqlConfig
 	PrefixToFileCache map[string]string
 	Prefix            string
 	Trace             bool
@@ -24,6 +25,14 @@ type MinioConfig struct {
 	SSL            bool   `arg:"--ssl" help:"Use SSL when connecting to s3"`
 }
 
+// The config for operations against a triplestore
+type SparqlConfig struct {
+	// The SPARQL 1.1 Graph Store HTTP Protocol endpoint; i.e. http://localhost:7200/repositories/iow/rdf-graphs/service for GraphDB
+	Endpoint string `arg:"--sparql-endpoint" help:"The SPARQL 1.1 Graph Store HTTP Protocol endpoint of the triplestore to sync against"`
+	Username string `arg:"--sparql-username,env:SPARQL_USERNAME" help:"username for basic auth against the triplestore; leave blank if not needed"`
+	Password string `arg:"--sparql-password,env:SPARQL_PASSWORD" help:"password for basic auth against the triplestore; leave blank if not needed"`
+}
+
 // THe config for jsonld context operations
 type ContextConfig struct {
 	// whether or not to cache the context when
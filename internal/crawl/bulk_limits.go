@@ -0,0 +1,81 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// The default number of bytes kept from the end of the stderr of a bulk source
+const defaultBulkStderrTailBytes = 64 << 10
+
+// Limits on the containers and executables that produce the documents of bulk sitemaps
+// so that a runaway or hung producer can't take down the host or block the harvest
+type BulkSourceLimits struct {
+	// the memory limit of a container in bytes; 0 is unlimited
+	MemoryBytes int64
+	// the number of cpus a container may use, i.e. 1.5; 0 is unlimited
+	Cpus float64
+	// the maximum number of processes in a container; 0 is unlimited
+	Pids int64
+	// how long a container or executable may run before it is killed, or a remote
+	// ndjson file may be read before the download is stopped; 0 is unlimited
+	MaxRuntime time.Duration
+	// how many bytes from the end of stderr are kept to report why a source failed;
+	// 0 keeps the default
+	StderrTailBytes int
+}
+
+// The resources of a container with the limits applied; the docker daemon treats zero values as unlimited
+func (l BulkSourceLimits) containerResources() container.Resources {
+	resources := container.Resources{
+		Memory:   l.MemoryBytes,
+		NanoCPUs: int64(l.Cpus * 1e9),
+	}
+	if l.Pids > 0 {
+		resources.PidsLimit = &l.Pids
+	}
+	return resources
+}
+
+// Keeps the last bytes written to it so that the end of
+// the stderr of a bulk source can be reported without holding all of it
+type tailBuffer struct {
+	mu   sync.Mutex
+	size int
+	data []byte
+}
+
+func newTailBuffer(size int) *tailBuffer {
+	if size <= 0 {
+		size = defaultBulkStderrTailBytes
+	}
+	return &tailBuffer{size: size}
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	written := len(p)
+	if len(p) >= b.size {
+		b.data = append(b.data[:0], p[len(p)-b.size:]...)
+		return written, nil
+	}
+	if overflow := len(b.data) + len(p) - b.size; overflow > 0 {
+		b.data = append(b.data[:0], b.data[overflow:]...)
+	}
+	b.data = append(b.data, p...)
+	return written, nil
+}
+
+// Return the kept bytes; a multi-byte character cut off at the start is dropped
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.ToValidUTF8(string(b.data), "")
+}
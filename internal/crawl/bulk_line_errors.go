@@ -0,0 +1,88 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/internetofwater/nabu/pkg"
+)
+
+const (
+	// The default number of lines that could not be harvested from a bulk source that are stored in the crawl report
+	defaultMaxBulkLineErrorsToStore = 100
+	// The default fraction of lines that may fail before a tolerant bulk harvest is aborted
+	defaultMaxBulkLineErrorRate = 0.1
+	// The error rate is only checked once this many lines were read so
+	// that a few bad lines at the start of the output don't abort the harvest
+	bulkErrorRateMinimumLines = 1000
+	// The number of bytes of an invalid line that are kept in its crawl error
+	bulkLineSnippetBytes = 200
+)
+
+// How lines in the output of a bulk source that aren't valid jsonld documents are handled
+type bulkLineErrorPolicy struct {
+	// record invalid lines as crawl errors and continue instead of failing the harvest
+	tolerate bool
+	// the maximum number of invalid lines stored in the crawl report; every one is still counted
+	maxErrorsToStore int
+	// the fraction of lines that may be invalid before the harvest is aborted since
+	// the source is clearly broken; 0 means there is no maximum
+	maxErrorRate float64
+}
+
+// The lines of a bulk sitemap that could not be harvested
+type bulkLineErrors struct {
+	policy bulkLineErrorPolicy
+	mu     sync.Mutex
+	// the number of lines that could not be harvested, including ones that weren't stored
+	total  int
+	stored []pkg.UrlCrawlError
+}
+
+func newBulkLineErrors(policy bulkLineErrorPolicy) *bulkLineErrors {
+	if policy.maxErrorsToStore <= 0 {
+		policy.maxErrorsToStore = defaultMaxBulkLineErrorsToStore
+	}
+	return &bulkLineErrors{policy: policy, stored: []pkg.UrlCrawlError{}}
+}
+
+// Return the start of a line to identify it in a crawl error
+func bulkLineSnippet(line []byte) string {
+	snippet := strings.TrimSpace(string(line))
+	if len(snippet) > bulkLineSnippetBytes {
+		snippet = strings.ToValidUTF8(snippet[:bulkLineSnippetBytes], "") + "..."
+	}
+	return snippet
+}
+
+// Record a line that could not be harvested; it is stored in the crawl report if there is room for it
+func (e *bulkLineErrors) record(crawlError pkg.UrlCrawlError) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.total++
+	if len(e.stored) < e.policy.maxErrorsToStore {
+		e.stored = append(e.stored, crawlError)
+	}
+}
+
+func (e *bulkLineErrors) count() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.total
+}
+
+// Return an error if too many of the lines read so far could not be harvested
+func (e *bulkLineErrors) checkErrorRate(linesRead int) error {
+	if e.policy.maxErrorRate <= 0 || linesRead < bulkErrorRateMinimumLines {
+		return nil
+	}
+	failed := e.count()
+	if rate := float64(failed) / float64(linesRead); rate > e.policy.maxErrorRate {
+		return fmt.Errorf("%d of the first %d lines of the bulk output could not be harvested which is more than the maximum error rate of %g", failed, linesRead, e.policy.maxErrorRate)
+	}
+	return nil
+}
@@ -0,0 +1,505 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/internetofwater/nabu/internal/common"
+	"github.com/internetofwater/nabu/pkg"
+	"github.com/moby/moby/pkg/stdcopy"
+	log "github.com/sirupsen/logrus"
+)
+
+// A source of newline delimited jsonld documents for a bulk sitemap; each url in a bulk
+// sitemap is opened with a source and every line of its output is harvested as a document
+type BulkSource interface {
+	// Start producing the documents for the url in a bulk sitemap. Closing the output releases
+	// everything the source started; once the output was read to EOF, Close returns a
+	// pkg.BulkSourceFailure if the container or process exited with a non zero status or was killed
+	Open(ctx context.Context, loc string) (io.ReadCloser, error)
+}
+
+// The kind of source that produces the documents for a bulk sitemap
+type BulkSourceKind string
+
+const (
+	// each url is a docker image whose container writes the documents to stdout
+	BulkSourceDocker BulkSourceKind = "docker"
+	// each url is a local executable that writes the documents to stdout
+	BulkSourceExec BulkSourceKind = "exec"
+	// each url is a local path or an http(s) url of a file of documents, optionally gzip compressed
+	BulkSourceNdjson BulkSourceKind = "ndjson"
+)
+
+// The url schemes that select a bulk source when the sitemap index doesn't set one
+const (
+	dockerScheme = "docker://"
+	execScheme   = "exec://"
+	fileScheme   = "file://"
+)
+
+// Return the kind of bulk source for a url in a bulk sitemap along with the location to
+// open with it. The kind set in the sitemap index takes precedence; otherwise it is chosen
+// by the scheme of the url. A url without a scheme is a docker image for backwards compatibility
+func resolveBulkSource(kind string, loc string) (BulkSourceKind, string, error) {
+	switch BulkSourceKind(kind) {
+	case "":
+	case BulkSourceDocker:
+		return BulkSourceDocker, strings.TrimPrefix(loc, dockerScheme), nil
+	case BulkSourceExec:
+		return BulkSourceExec, strings.TrimPrefix(loc, execScheme), nil
+	case BulkSourceNdjson:
+		return BulkSourceNdjson, strings.TrimPrefix(loc, fileScheme), nil
+	default:
+		return "", "", fmt.Errorf("unknown bulk source %q; must be one of %s, %s, or %s", kind, BulkSourceDocker, BulkSourceExec, BulkSourceNdjson)
+	}
+
+	switch {
+	case strings.HasPrefix(loc, execScheme):
+		return BulkSourceExec, strings.TrimPrefix(loc, execScheme), nil
+	case strings.HasPrefix(loc, fileScheme):
+		return BulkSourceNdjson, strings.TrimPrefix(loc, fileScheme), nil
+	case strings.HasPrefix(loc, "http://"), strings.HasPrefix(loc, "https://"):
+		return BulkSourceNdjson, loc, nil
+	}
+	return BulkSourceDocker, strings.TrimPrefix(loc, dockerScheme), nil
+}
+
+// Return an error if the bulk source runs an executable or reads a file on the harvesting host
+// and local bulk sources weren't allowed. Anyone who can edit the sitemap index could otherwise
+// run commands or read files on the host, so these must be enabled by whoever runs the harvest
+func checkLocalBulkSourceAllowed(kind BulkSourceKind, loc string, allowLocal bool) error {
+	if allowLocal {
+		return nil
+	}
+	switch {
+	case kind == BulkSourceExec:
+		return fmt.Errorf("bulk source %s would run a local executable but local bulk sources are not allowed; pass --allow-local-bulk-sources to allow them", loc)
+	case kind == BulkSourceNdjson && !isHttpBulkFile(loc):
+		return fmt.Errorf("bulk source %s would read a local file but local bulk sources are not allowed; pass --allow-local-bulk-sources to allow them", loc)
+	}
+	return nil
+}
+
+// Return true if an ndjson bulk file is fetched over http(s) instead of read from the local disk
+func isHttpBulkFile(loc string) bool {
+	return strings.HasPrefix(loc, "http://") || strings.HasPrefix(loc, "https://")
+}
+
+// Make the bulk source of the given kind; http requests for ndjson files are sent with a streaming
+// copy of the client and the limits apply to containers, executables, and remote ndjson files
+func newBulkSource(kind BulkSourceKind, httpClient *http.Client, limits BulkSourceLimits) BulkSource {
+	switch kind {
+	case BulkSourceExec:
+		return execBulkSource{limits: limits}
+	case BulkSourceNdjson:
+		return ndjsonBulkSource{httpClient: streamingBulkClient(httpClient), limits: limits}
+	}
+	return dockerBulkSource{limits: limits}
+}
+
+// Runs a docker image through the docker daemon and reads the stdout of its container
+type dockerBulkSource struct {
+	limits BulkSourceLimits
+}
+
+func (s dockerBulkSource) Open(ctx context.Context, dockerImageName string) (io.ReadCloser, error) {
+	dockerClient, err := client.NewClientWithOpts(client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	output, err := startContainer(ctx, dockerClient, dockerImageName, s.limits)
+	if err != nil {
+		_ = dockerClient.Close()
+		return nil, err
+	}
+	return output, nil
+}
+
+// Pull the image if it is in a registry, then create and start a container for it with
+// the limits and return its stdout; the container is removed when the output is closed
+func startContainer(ctx context.Context, dockerClient *client.Client, dockerImageName string, limits BulkSourceLimits) (*dockerBulkOutput, error) {
+	if strings.Contains(dockerImageName, "/") {
+
+		log.Infof("Pulling docker image %s", dockerImageName)
+		reader, err := dockerClient.ImagePull(ctx, dockerImageName, image.PullOptions{})
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = reader.Close() }()
+
+		// read the output to completion to ensure the image is pulled
+		_, err = io.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+	creationResp, err := dockerClient.ContainerCreate(
+		ctx,
+		&container.Config{Image: dockerImageName},
+		&container.HostConfig{
+			LogConfig: container.LogConfig{
+				// disable Docker disk logging
+				// this makes it so the docker daemon
+				// does not log to disk and requires
+				// something to attach to it to read the logs;
+				// this is more efficient for bulk data which would otherwise
+				// overwhelm the daemon or add overhead to log to disk
+				Type: "none",
+			},
+			Resources: limits.containerResources(),
+		},
+		nil,
+		nil,
+		// no container name is specified in order to
+		// ensure the container name is unique
+		"",
+	)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Created container %s for image %s", creationResp.ID, dockerImageName)
+	removeContainer := func() {
+		if err := dockerClient.ContainerRemove(context.Background(), creationResp.ID, container.RemoveOptions{Force: true}); err != nil {
+			log.Errorf("failed to remove container %s: %v", creationResp.ID, err)
+		}
+	}
+
+	// attach BEFORE starting; this avoids the race where the container
+	// exits and flushes stdout before we connect
+	attachResp, err := dockerClient.ContainerAttach(ctx, creationResp.ID, container.AttachOptions{
+		Stream: true,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		removeContainer()
+		return nil, err
+	}
+
+	log.Infof("Starting container %s for image %s", creationResp.ID, dockerImageName)
+	if err = dockerClient.ContainerStart(ctx, creationResp.ID, container.StartOptions{}); err != nil {
+		attachResp.Close()
+		removeContainer()
+		return nil, err
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	stderr := newTailBuffer(limits.StderrTailBytes)
+
+	waitResponseChan, errChan := dockerClient.ContainerWait(ctx, creationResp.ID, container.WaitConditionNotRunning)
+
+	// demux the multiplexed stdout and stderr streams; stdout is only closed once both
+	// streams ended so the stderr tail is complete once stdout was read to EOF
+	go func() {
+		_, err := stdcopy.StdCopy(pipeWriter, stderr, attachResp.Reader)
+		if err != nil {
+			log.Errorf("error demuxing container attach stream: %v", err)
+		}
+		_ = pipeWriter.Close()
+	}()
+
+	output := &dockerBulkOutput{
+		image:            dockerImageName,
+		stdout:           pipeReader,
+		stderr:           stderr,
+		attachResp:       attachResp,
+		dockerClient:     dockerClient,
+		containerId:      creationResp.ID,
+		waitResponseChan: waitResponseChan,
+		errChan:          errChan,
+		removeContainer:  removeContainer,
+	}
+	if limits.MaxRuntime > 0 {
+		output.runtimeLimit = time.AfterFunc(limits.MaxRuntime, func() {
+			log.Errorf("Killing container %s for image %s since it ran longer than %s", creationResp.ID, dockerImageName, limits.MaxRuntime)
+			output.timedOut.Store(true)
+			if err := dockerClient.ContainerKill(context.Background(), creationResp.ID, "SIGKILL"); err != nil {
+				log.Errorf("failed to kill container %s: %v", creationResp.ID, err)
+			}
+		})
+	}
+	return output, nil
+}
+
+// The stdout of a running bulk container
+type dockerBulkOutput struct {
+	image            string
+	stdout           *io.PipeReader
+	stderr           *tailBuffer
+	attachResp       types.HijackedResponse
+	dockerClient     *client.Client
+	containerId      string
+	waitResponseChan <-chan container.WaitResponse
+	errChan          <-chan error
+	removeContainer  func()
+	// kills the container once it ran longer than the maximum runtime; nil if there is no maximum
+	runtimeLimit *time.Timer
+	timedOut     atomic.Bool
+	// true once stdout was read to EOF and thus the container is exiting on its own
+	reachedEOF bool
+	closeOnce  sync.Once
+	closeErr   error
+}
+
+func (o *dockerBulkOutput) Read(p []byte) (int, error) {
+	n, err := o.stdout.Read(p)
+	if err == io.EOF {
+		o.reachedEOF = true
+	}
+	return n, err
+}
+
+// Wait for the container to exit if its output was read to the end and then remove it;
+// a container whose output wasn't read to the end is killed since nothing will read the rest
+func (o *dockerBulkOutput) Close() error {
+	o.closeOnce.Do(func() {
+		_ = o.stdout.Close()
+		if o.runtimeLimit != nil {
+			o.runtimeLimit.Stop()
+		}
+		defer func() { _ = o.dockerClient.Close() }()
+		defer o.attachResp.Close()
+		defer o.removeContainer()
+
+		if !o.reachedEOF {
+			return
+		}
+		log.Infof("finished reading logs for container %s", o.containerId)
+		select {
+		case err := <-o.errChan:
+			if err != nil && err != io.EOF {
+				o.closeErr = err
+			}
+		case exitResp := <-o.waitResponseChan:
+			if exitResp.StatusCode != 0 || o.timedOut.Load() {
+				o.closeErr = pkg.BulkSourceFailure{
+					Url:        o.image,
+					ExitCode:   int(exitResp.StatusCode),
+					TimedOut:   o.timedOut.Load(),
+					StderrTail: o.stderr.String(),
+				}
+			}
+		}
+	})
+	return o.closeErr
+}
+
+// How long to wait for the output of an executable to close once it exited
+const execBulkWaitDelay = time.Second
+
+// Runs a local executable and reads its stdout; this is for machines without a docker daemon.
+// Only the maximum runtime applies to executables since the other limits need a container
+type execBulkSource struct {
+	limits BulkSourceLimits
+}
+
+func (s execBulkSource) Open(ctx context.Context, executable string) (io.ReadCloser, error) {
+	// the process is killed through Close rather than the context so
+	// that an early exit can be told apart from a failed process
+	cmd := exec.Command(executable)
+	stderr := newTailBuffer(s.limits.StderrTailBytes)
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+	// stderr is copied through a pipe which a child of the process may keep open after the
+	// process itself was killed, so don't wait for it to close for longer than this
+	cmd.WaitDelay = execBulkWaitDelay
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Starting bulk executable %s", executable)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start bulk executable %s: %w", executable, err)
+	}
+	output := &execBulkOutput{executable: executable, stdout: stdout, stderr: stderr, cmd: cmd}
+	// stop the process if the harvest is cancelled while it is still being read
+	output.stopOnCancel = context.AfterFunc(ctx, func() { _ = cmd.Process.Kill() })
+	if s.limits.MaxRuntime > 0 {
+		output.runtimeLimit = time.AfterFunc(s.limits.MaxRuntime, func() {
+			log.Errorf("Killing bulk executable %s since it ran longer than %s", executable, s.limits.MaxRuntime)
+			output.timedOut.Store(true)
+			_ = cmd.Process.Kill()
+		})
+	}
+	return output, nil
+}
+
+// The stdout of a running bulk executable
+type execBulkOutput struct {
+	executable   string
+	stdout       io.ReadCloser
+	stderr       *tailBuffer
+	cmd          *exec.Cmd
+	stopOnCancel func() bool
+	// kills the process once it ran longer than the maximum runtime; nil if there is no maximum
+	runtimeLimit *time.Timer
+	timedOut     atomic.Bool
+	// true once stdout was read to EOF and thus the process is exiting on its own
+	reachedEOF bool
+	closeOnce  sync.Once
+	closeErr   error
+}
+
+func (o *execBulkOutput) Read(p []byte) (int, error) {
+	n, err := o.stdout.Read(p)
+	if err == io.EOF {
+		o.reachedEOF = true
+	}
+	return n, err
+}
+
+// Wait for the process to exit if its output was read to the end; a
+// process whose output wasn't read to the end is killed
+func (o *execBulkOutput) Close() error {
+	o.closeOnce.Do(func() {
+		o.stopOnCancel()
+		if !o.reachedEOF {
+			_ = o.cmd.Process.Kill()
+			_ = o.cmd.Wait()
+			if o.runtimeLimit != nil {
+				o.runtimeLimit.Stop()
+			}
+			return
+		}
+		err := o.cmd.Wait()
+		if o.runtimeLimit != nil {
+			o.runtimeLimit.Stop()
+		}
+		var exitErr *exec.ExitError
+		switch {
+		case err == nil:
+		case errors.Is(err, exec.ErrWaitDelay):
+			// the process exited successfully but left a child running that holds its stderr
+			log.Warnf("bulk executable %s exited but its stderr was still open after %s", o.executable, execBulkWaitDelay)
+		case errors.As(err, &exitErr):
+			o.closeErr = pkg.BulkSourceFailure{
+				Url:        o.executable,
+				ExitCode:   exitErr.ExitCode(),
+				TimedOut:   o.timedOut.Load(),
+				StderrTail: o.stderr.String(),
+			}
+		default:
+			o.closeErr = fmt.Errorf("bulk executable %s failed: %w", o.executable, err)
+		}
+	})
+	return o.closeErr
+}
+
+// Make a client for streaming bulk files from the client used for crawling. The timeout of an
+// http.Client covers reading the body, and a bulk file is read line by line while its documents
+// are validated and uploaded, so the stream is only bounded by its context and the maximum
+// runtime. The retrying transport is skipped since a retry can't resume a partially read stream
+func streamingBulkClient(httpClient *http.Client) *http.Client {
+	if httpClient == nil {
+		return &http.Client{}
+	}
+	streaming := *httpClient
+	streaming.Timeout = 0
+	if retrying, ok := httpClient.Transport.(*common.RetryTransport); ok {
+		streaming.Transport = retrying.Base
+	}
+	return &streaming
+}
+
+// Cancels the context of a streamed bulk file once it is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// Reads a local or remote file of newline delimited documents
+type ndjsonBulkSource struct {
+	// a client without an overall timeout since the file is streamed
+	httpClient *http.Client
+	limits     BulkSourceLimits
+}
+
+func (s ndjsonBulkSource) Open(ctx context.Context, loc string) (io.ReadCloser, error) {
+	var body io.ReadCloser
+	if isHttpBulkFile(loc) {
+		cancel := context.CancelFunc(func() {})
+		if s.limits.MaxRuntime > 0 {
+			ctx, cancel = context.WithTimeout(ctx, s.limits.MaxRuntime)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, loc, nil)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		resp, err := s.httpClient.Do(req)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			_ = resp.Body.Close()
+			cancel()
+			return nil, fmt.Errorf("failed to get bulk file %s: status %d", loc, resp.StatusCode)
+		}
+		body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	} else {
+		file, err := os.Open(loc)
+		if err != nil {
+			return nil, err
+		}
+		body = file
+	}
+	log.Infof("Reading bulk file %s", loc)
+	return maybeGunzip(body)
+}
+
+// The size of the buffer used to check whether a file is gzip compressed
+const gzipPeekBufferSize = 4096
+
+// Decompress the body if it starts with the gzip magic number so that
+// compressed files work regardless of their name or content type
+func maybeGunzip(body io.ReadCloser) (io.ReadCloser, error) {
+	buffered := bufio.NewReaderSize(body, gzipPeekBufferSize)
+	magic, err := buffered.Peek(2)
+	if err != nil && err != io.EOF {
+		_ = body.Close()
+		return nil, err
+	}
+	if len(magic) < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		return readCloser{Reader: buffered, closer: body}, nil
+	}
+	gzipReader, err := gzip.NewReader(buffered)
+	if err != nil {
+		_ = body.Close()
+		return nil, err
+	}
+	return readCloser{Reader: gzipReader, closer: body}, nil
+}
+
+// Reads from a wrapper of a body but closes the body itself
+type readCloser struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (r readCloser) Close() error {
+	return r.closer.Close()
+}
@@ -0,0 +1,337 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/internetofwater/nabu/internal/common"
+	"github.com/internetofwater/nabu/internal/crawl/storage"
+	"github.com/internetofwater/nabu/internal/crawl/url_info"
+	"github.com/internetofwater/nabu/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveBulkSource(t *testing.T) {
+	for _, testCase := range []struct {
+		kind         string
+		loc          string
+		expectedKind BulkSourceKind
+		expectedLoc  string
+	}{
+		{"", "internetofwater/gnis_bulk_container:latest", BulkSourceDocker, "internetofwater/gnis_bulk_container:latest"},
+		{"", "docker://busybox", BulkSourceDocker, "busybox"},
+		{"", "exec:///usr/local/bin/generate", BulkSourceExec, "/usr/local/bin/generate"},
+		{"", "file:///data/features.ndjson.gz", BulkSourceNdjson, "/data/features.ndjson.gz"},
+		{"", "https://example.com/features.ndjson", BulkSourceNdjson, "https://example.com/features.ndjson"},
+		{"ndjson", "/data/features.ndjson", BulkSourceNdjson, "/data/features.ndjson"},
+		{"exec", "./generate.sh", BulkSourceExec, "./generate.sh"},
+		{"docker", "https-proxy/image", BulkSourceDocker, "https-proxy/image"},
+	} {
+		kind, loc, err := resolveBulkSource(testCase.kind, testCase.loc)
+		require.NoError(t, err)
+		require.Equal(t, testCase.expectedKind, kind, testCase.loc)
+		require.Equal(t, testCase.expectedLoc, loc, testCase.loc)
+	}
+
+	_, _, err := resolveBulkSource("ftp", "ftp://example.com/features.ndjson")
+	require.ErrorContains(t, err, "unknown bulk source")
+}
+
+func TestMaybeGunzip(t *testing.T) {
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	_, err := gzipWriter.Write([]byte("{}\n{}\n"))
+	require.NoError(t, err)
+	require.NoError(t, gzipWriter.Close())
+
+	for name, body := range map[string][]byte{"compressed": compressed.Bytes(), "uncompressed": []byte("{}\n{}\n")} {
+		reader, err := maybeGunzip(io.NopCloser(bytes.NewReader(body)))
+		require.NoError(t, err, name)
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err, name)
+		require.Equal(t, "{}\n{}\n", string(data), name)
+		require.NoError(t, reader.Close())
+	}
+
+	reader, err := maybeGunzip(io.NopCloser(strings.NewReader("")))
+	require.NoError(t, err, "an empty file has no documents but isn't an error")
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Empty(t, data)
+}
+
+// Harvest a bulk sitemap with a single url without a shacl validator
+func harvestBulkSitemapFrom(t *testing.T, metadata SitemapMetadata, loc string, httpClient *http.Client) (*storage.LocalTempFSCrawlStorage, error) {
+	crawlStorage, _, err := harvestBulkSitemapWithLimits(t, metadata, loc, httpClient, BulkSourceLimits{})
+	return crawlStorage, err
+}
+
+func harvestBulkSitemapWithLimits(t *testing.T, metadata SitemapMetadata, loc string, httpClient *http.Client, limits BulkSourceLimits) (*storage.LocalTempFSCrawlStorage, pkg.SitemapCrawlStats, error) {
+	crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+	require.NoError(t, err)
+	metadata.SitemapID = "test_sitemap"
+	sitemap := &Sitemap{
+		URL:                []url_info.URL{{Loc: loc}},
+		metadata:           metadata,
+		storageDestination: crawlStorage,
+		workers:            1,
+	}
+	stats, _, err := sitemap.Harvest(context.Background(), &SitemapHarvestConfig{
+		workers:               1,
+		httpClient:            httpClient,
+		storageDestination:    crawlStorage,
+		maxBulkLineBytes:      defaultMaxBulkLineBytes,
+		maxShaclErrorsToStore: 20,
+		bulkLimits:            limits,
+		allowLocalBulkSources: true,
+	})
+	return crawlStorage, stats, err
+}
+
+func requireBulkDocumentsStored(t *testing.T, crawlStorage *storage.LocalTempFSCrawlStorage) {
+	stored, err := crawlStorage.ListDir("summoned/test_sitemap/")
+	require.NoError(t, err)
+	require.Len(t, stored, 3, "every line of the bulk test data should be stored")
+}
+
+func TestNdjsonBulkSource(t *testing.T) {
+	data, err := os.ReadFile("testdata/bulk_sitemap/data.txt")
+	require.NoError(t, err)
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	_, err = gzipWriter.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, gzipWriter.Close())
+
+	t.Run("gzip compressed local file chosen by the file scheme", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "features.ndjson.gz")
+		require.NoError(t, os.WriteFile(path, compressed.Bytes(), 0o600))
+		crawlStorage, err := harvestBulkSitemapFrom(t, SitemapMetadata{BulkContainerImage: "unused"}, "file://"+path, nil)
+		require.NoError(t, err)
+		requireBulkDocumentsStored(t, crawlStorage)
+	})
+
+	t.Run("remote file chosen by the https scheme", func(t *testing.T) {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/features.ndjson" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(data)
+		}))
+		defer server.Close()
+
+		crawlStorage, err := harvestBulkSitemapFrom(t, SitemapMetadata{BulkSource: "ndjson"}, server.URL+"/features.ndjson", server.Client())
+		require.NoError(t, err)
+		requireBulkDocumentsStored(t, crawlStorage)
+
+		_, err = harvestBulkSitemapFrom(t, SitemapMetadata{BulkSource: "ndjson"}, server.URL+"/missing.ndjson", server.Client())
+		require.ErrorContains(t, err, "status 404")
+	})
+}
+
+func TestRemoteNdjsonBulkSourceIsStreamedWithoutTheClientTimeout(t *testing.T) {
+	const lines = 5
+	const interval = 100 * time.Millisecond
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := range lines {
+			_, _ = fmt.Fprintf(w, `{"@id": "https://example.com/%d"}`+"\n", i)
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(interval):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	defer server.Close()
+	// the stream takes longer than the timeout of the crawler client
+	crawlerClient := &http.Client{
+		Timeout:   2 * interval,
+		Transport: &common.RetryTransport{Base: server.Client().Transport, Retries: 3},
+	}
+
+	t.Run("the whole file is read", func(t *testing.T) {
+		output, err := newBulkSource(BulkSourceNdjson, crawlerClient, BulkSourceLimits{}).Open(context.Background(), server.URL+"/features.ndjson")
+		require.NoError(t, err)
+		defer func() { _ = output.Close() }()
+		data, err := io.ReadAll(output)
+		require.NoError(t, err)
+		require.Equal(t, lines, strings.Count(string(data), "\n"))
+	})
+
+	t.Run("the maximum runtime stops the stream", func(t *testing.T) {
+		output, err := newBulkSource(BulkSourceNdjson, crawlerClient, BulkSourceLimits{MaxRuntime: 2 * interval}).Open(context.Background(), server.URL+"/features.ndjson")
+		require.NoError(t, err)
+		defer func() { _ = output.Close() }()
+		_, err = io.ReadAll(output)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestExecBulkSource(t *testing.T) {
+	data, err := filepath.Abs("testdata/bulk_sitemap/data.txt")
+	require.NoError(t, err)
+	writeScript := func(t *testing.T, body string) string {
+		path := filepath.Join(t.TempDir(), "generate.sh")
+		require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o700))
+		return path
+	}
+
+	t.Run("stdout of the executable is harvested", func(t *testing.T) {
+		script := writeScript(t, "cat "+data)
+		crawlStorage, err := harvestBulkSitemapFrom(t, SitemapMetadata{BulkSource: "exec"}, script, nil)
+		require.NoError(t, err)
+		requireBulkDocumentsStored(t, crawlStorage)
+	})
+
+	t.Run("a non zero exit fails the harvest after its output is stored", func(t *testing.T) {
+		script := writeScript(t, "cat "+data+"\nexit 3")
+		crawlStorage, err := harvestBulkSitemapFrom(t, SitemapMetadata{BulkContainerImage: "unused"}, "exec://"+script, nil)
+		require.ErrorContains(t, err, "exit status 3")
+		requireBulkDocumentsStored(t, crawlStorage)
+	})
+
+	t.Run("executable that doesn't exist", func(t *testing.T) {
+		_, err := harvestBulkSitemapFrom(t, SitemapMetadata{BulkSource: "exec"}, filepath.Join(t.TempDir(), "missing"), nil)
+		require.ErrorContains(t, err, "failed to start bulk executable")
+	})
+
+	t.Run("invalid output stops the executable", func(t *testing.T) {
+		script := writeScript(t, "echo 'not json'\nsleep 60")
+		_, err := harvestBulkSitemapFrom(t, SitemapMetadata{BulkSource: "exec"}, script, nil)
+		require.ErrorContains(t, err, "error unmarshaling line as JSON-LD")
+	})
+}
+
+func TestBulkSourceFailures(t *testing.T) {
+	writeScript := func(t *testing.T, body string) string {
+		path := filepath.Join(t.TempDir(), "generate.sh")
+		require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o700))
+		return path
+	}
+
+	t.Run("the end of stderr is kept in the stored crawl report", func(t *testing.T) {
+		script := writeScript(t, `echo '{"@id": "https://example.com/1"}'; echo 'connecting to upstream' >&2; echo 'upstream returned 503' >&2; exit 3`)
+		crawlStorage, stats, err := harvestBulkSitemapWithLimits(t, SitemapMetadata{BulkSource: "exec"}, script, nil, BulkSourceLimits{StderrTailBytes: 22})
+		var failure pkg.BulkSourceFailure
+		require.ErrorAs(t, err, &failure)
+		require.NotNil(t, stats.BulkSourceFailure)
+		require.Equal(t, 3, stats.BulkSourceFailure.ExitCode)
+		require.False(t, stats.BulkSourceFailure.TimedOut)
+		require.Equal(t, "upstream returned 503\n", stats.BulkSourceFailure.StderrTail, "only the end of stderr should be kept")
+
+		reader, err := crawlStorage.Get("metadata/sitemaps/test_sitemap.json")
+		require.NoError(t, err, "the report of a failed bulk source should be stored")
+		defer func() { _ = reader.Close() }()
+		var report pkg.SitemapCrawlStats
+		require.NoError(t, json.NewDecoder(reader).Decode(&report))
+		require.Equal(t, stats.BulkSourceFailure, report.BulkSourceFailure)
+	})
+
+	t.Run("a source that runs longer than the maximum runtime is killed", func(t *testing.T) {
+		script := writeScript(t, `echo '{"@id": "https://example.com/1"}'; echo 'still working' >&2; exec sleep 60`)
+		start := time.Now()
+		crawlStorage, stats, err := harvestBulkSitemapWithLimits(t, SitemapMetadata{BulkSource: "exec"}, script, nil, BulkSourceLimits{MaxRuntime: 200 * time.Millisecond})
+		require.Less(t, time.Since(start), 30*time.Second)
+		require.ErrorContains(t, err, "maximum runtime")
+		require.NotNil(t, stats.BulkSourceFailure)
+		require.True(t, stats.BulkSourceFailure.TimedOut)
+		require.Contains(t, stats.BulkSourceFailure.StderrTail, "still working")
+		stored, err := crawlStorage.ListDir("summoned/test_sitemap/")
+		require.NoError(t, err)
+		require.Len(t, stored, 1, "documents output before the source was killed are still stored")
+	})
+
+	t.Run("a source that succeeds has no failure", func(t *testing.T) {
+		script := writeScript(t, `echo '{"@id": "https://example.com/1"}'; echo 'done' >&2`)
+		_, stats, err := harvestBulkSitemapWithLimits(t, SitemapMetadata{BulkSource: "exec"}, script, nil, BulkSourceLimits{MaxRuntime: time.Minute})
+		require.NoError(t, err)
+		require.Nil(t, stats.BulkSourceFailure)
+	})
+}
+
+func TestLocalBulkSourcesMustBeAllowed(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "generate.sh")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\necho '{\"@id\": \"https://example.com/1\"}'\n"), 0o700))
+	ndjson := filepath.Join(t.TempDir(), "features.ndjson")
+	require.NoError(t, os.WriteFile(ndjson, []byte(`{"@id": "https://example.com/1"}`+"\n"), 0o600))
+
+	for name, testCase := range map[string]struct {
+		metadata SitemapMetadata
+		loc      string
+	}{
+		"exec from the scheme":        {SitemapMetadata{BulkContainerImage: "unused"}, "exec://" + script},
+		"exec from the sitemap index": {SitemapMetadata{BulkSource: "exec"}, script},
+		"file from the scheme":        {SitemapMetadata{BulkContainerImage: "unused"}, "file://" + ndjson},
+		"local path with ndjson":      {SitemapMetadata{BulkSource: "ndjson"}, ndjson},
+	} {
+		t.Run(name, func(t *testing.T) {
+			crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+			require.NoError(t, err)
+			testCase.metadata.SitemapID = "test_sitemap"
+			sitemap := &Sitemap{
+				URL:                []url_info.URL{{Loc: testCase.loc}},
+				metadata:           testCase.metadata,
+				storageDestination: crawlStorage,
+				workers:            1,
+			}
+			_, _, err = sitemap.Harvest(context.Background(), &SitemapHarvestConfig{
+				workers:            1,
+				storageDestination: crawlStorage,
+				maxBulkLineBytes:   defaultMaxBulkLineBytes,
+			})
+			require.ErrorContains(t, err, "local bulk sources are not allowed")
+			empty, err := crawlStorage.IsEmptyDir("summoned/test_sitemap")
+			require.NoError(t, err)
+			require.True(t, empty, "nothing should have been run or read")
+		})
+	}
+
+	require.NoError(t, checkLocalBulkSourceAllowed(BulkSourceNdjson, "https://example.com/features.ndjson", false), "files over http are not local")
+	require.NoError(t, checkLocalBulkSourceAllowed(BulkSourceExec, script, true))
+}
+
+func TestTailBuffer(t *testing.T) {
+	tail := newTailBuffer(8)
+	for _, write := range []string{"abc", "defgh", "ij", "klmnopqrstuvwxyz", "0"} {
+		n, err := tail.Write([]byte(write))
+		require.NoError(t, err)
+		require.Equal(t, len(write), n)
+	}
+	require.Equal(t, "tuvwxyz0", tail.String())
+
+	tail = newTailBuffer(4)
+	_, err := tail.Write([]byte("aé€"))
+	require.NoError(t, err)
+	require.Equal(t, "€", tail.String(), "a character cut off at the start should be dropped")
+
+	require.Equal(t, defaultBulkStderrTailBytes, newTailBuffer(0).size)
+}
+
+func TestBulkContainerResources(t *testing.T) {
+	resources := BulkSourceLimits{MemoryBytes: 512 << 20, Cpus: 1.5, Pids: 100}.containerResources()
+	require.Equal(t, int64(512<<20), resources.Memory)
+	require.Equal(t, int64(1_500_000_000), resources.NanoCPUs)
+	require.NotNil(t, resources.PidsLimit)
+	require.Equal(t, int64(100), *resources.PidsLimit)
+
+	unlimited := BulkSourceLimits{}.containerResources()
+	require.Zero(t, unlimited.Memory)
+	require.Zero(t, unlimited.NanoCPUs)
+	require.Nil(t, unlimited.PidsLimit)
+}
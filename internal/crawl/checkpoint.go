@@ -0,0 +1,141 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/internetofwater/nabu/internal/crawl/storage"
+	"github.com/internetofwater/nabu/pkg"
+	log "github.com/sirupsen/logrus"
+)
+
+// Info about a url that was successfully harvested before a checkpoint was written
+type checkpointEntry struct {
+	// the path in storage where the jsonld for the url was stored
+	PathInStorage string
+	// whether or not the url failed shacl validation
+	ShaclInvalid bool
+}
+
+// A snapshot of the progress of a sitemap harvest. This is stored
+// alongside the crawled data so that a harvest that exits early
+// can be resumed without recrawling every url in the sitemap
+type harvestCheckpoint struct {
+	SitemapID string
+	// map of every url that was successfully harvested to its info
+	// urls that failed are not included so they are retried when resuming
+	CompletedUrls map[string]checkpointEntry
+	// the shacl warnings for the completed urls
+	ShaclWarnings []pkg.ShaclInfo
+	// the cumulative number of seconds spent harvesting the sitemap
+	// across the original run and any previously resumed runs
+	SecondsSoFar float64
+}
+
+// The path in storage where the checkpoint for a sitemap is stored;
+// this is outside of summoned/ so it is never confused with harvested data
+func checkpointPath(sitemapId string) string {
+	return fmt.Sprintf("checkpoints/%s.json", sitemapId)
+}
+
+// Read the checkpoint for a sitemap from storage; the boolean is false if no checkpoint exists
+func loadCheckpoint(storageDestination storage.CrawlStorage, sitemapId string) (harvestCheckpoint, bool, error) {
+	path := checkpointPath(sitemapId)
+	var checkpoint harvestCheckpoint
+	found, err := readJsonFromStorage(storageDestination, path, &checkpoint)
+	if err != nil || !found {
+		return harvestCheckpoint{}, false, err
+	}
+	if checkpoint.SitemapID != sitemapId {
+		return harvestCheckpoint{}, false, fmt.Errorf("checkpoint at %s is for sitemap %s, not %s", path, checkpoint.SitemapID, sitemapId)
+	}
+	if checkpoint.CompletedUrls == nil {
+		checkpoint.CompletedUrls = make(map[string]checkpointEntry)
+	}
+	return checkpoint, true, nil
+}
+
+// Remove the checkpoint for a sitemap if it exists
+func removeCheckpoint(storageDestination storage.CrawlStorage, sitemapId string) error {
+	path := checkpointPath(sitemapId)
+	exists, err := storageDestination.Exists(path)
+	if err != nil || !exists {
+		return err
+	}
+	return storageDestination.Remove(path)
+}
+
+// Tracks the progress of a sitemap harvest and periodically
+// writes it to storage; safe to use across goroutines
+type checkpointTracker struct {
+	mu         sync.Mutex
+	checkpoint harvestCheckpoint
+	// the number of urls completed since the last checkpoint was written
+	sinceLastWrite int
+	// the number of completed urls between each checkpoint; 0 disables periodic checkpoints
+	interval           int
+	storageDestination storage.CrawlStorage
+}
+
+func newCheckpointTracker(sitemapId string, interval int, storageDestination storage.CrawlStorage) *checkpointTracker {
+	return &checkpointTracker{
+		checkpoint: harvestCheckpoint{
+			SitemapID:     sitemapId,
+			CompletedUrls: make(map[string]checkpointEntry),
+		},
+		interval:           interval,
+		storageDestination: storageDestination,
+	}
+}
+
+// Restore a url that was completed in a previous run
+// without counting it towards the next checkpoint
+func (c *checkpointTracker) restore(url string, entry checkpointEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checkpoint.CompletedUrls[url] = entry
+}
+
+// Mark a url as successfully harvested and return true
+// if enough urls were completed that a checkpoint should be written
+func (c *checkpointTracker) markCompleted(url string, entry checkpointEntry) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checkpoint.CompletedUrls[url] = entry
+	c.sinceLastWrite++
+	if c.interval > 0 && c.sinceLastWrite >= c.interval {
+		c.sinceLastWrite = 0
+		return true
+	}
+	return false
+}
+
+// Write the current progress to storage
+// warnings are the shacl warnings gathered so far in the harvest
+// and secondsSoFar is the total time spent harvesting the sitemap
+func (c *checkpointTracker) write(warnings []pkg.ShaclInfo, secondsSoFar float64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// only keep warnings for completed urls since the rest will be harvested again
+	completedWarnings := []pkg.ShaclInfo{}
+	for _, warning := range warnings {
+		if _, ok := c.checkpoint.CompletedUrls[warning.Url]; ok {
+			completedWarnings = append(completedWarnings, warning)
+		}
+	}
+	c.checkpoint.ShaclWarnings = completedWarnings
+	c.checkpoint.SecondsSoFar = secondsSoFar
+
+	data, err := json.Marshal(c.checkpoint)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Writing checkpoint for %s with %d completed urls", c.checkpoint.SitemapID, len(c.checkpoint.CompletedUrls))
+	return c.storageDestination.StoreWithoutServersideHash(checkpointPath(c.checkpoint.SitemapID), bytes.NewReader(data))
+}
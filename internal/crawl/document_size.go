@@ -0,0 +1,69 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"bufio"
+	"io"
+)
+
+const (
+	// The default maximum size of a document fetched from a single url. Every worker
+	// may hold a document of this size in memory so this bounds the memory of a harvest
+	defaultMaxDocumentBytes int64 = 32 << 20
+	// The default maximum size of a single jsonld document output by a bulk container;
+	// this is larger since bulk documents are read one at a time
+	defaultMaxBulkLineBytes int64 = 256 << 20
+)
+
+// Read the entire body without holding more than maxBytes of it in memory. If the body is
+// larger than maxBytes, tooLarge is true and no data is returned. A content length that
+// is already larger than the maximum fails before anything is read; -1 means it is unknown.
+// A maxBytes of 0 means there is no maximum
+func readBoundedBody(body io.Reader, contentLength int64, maxBytes int64) (data []byte, tooLarge bool, err error) {
+	if maxBytes <= 0 {
+		data, err = io.ReadAll(body)
+		return data, false, err
+	}
+	if contentLength > maxBytes {
+		return nil, true, nil
+	}
+	// read one byte past the maximum to tell a body exactly
+	// the size of the maximum apart from one that is larger
+	data, err = io.ReadAll(io.LimitReader(body, maxBytes+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, true, nil
+	}
+	return data, false, nil
+}
+
+// Read the next newline terminated line without holding more than maxBytes of it in memory.
+// If the line, excluding its newline, is larger than maxBytes the rest of it is discarded and
+// tooLarge is true. As with bufio.Reader.ReadBytes, io.EOF is returned with the final line.
+// The line is copied out of the reader's buffer so the caller has unique ownership of it.
+// A maxBytes of 0 means there is no maximum
+func readBoundedLine(reader *bufio.Reader, maxBytes int64) (line []byte, tooLarge bool, err error) {
+	var size int64
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		size += int64(len(chunk))
+		if len(chunk) > 0 && chunk[len(chunk)-1] == '\n' {
+			size--
+		}
+		if !tooLarge && maxBytes > 0 && size > maxBytes {
+			tooLarge = true
+			line = nil
+		}
+		if !tooLarge {
+			line = append(line, chunk...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		return line, tooLarge, err
+	}
+}
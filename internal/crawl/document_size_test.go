@@ -0,0 +1,124 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/internetofwater/nabu/internal/common"
+	"github.com/internetofwater/nabu/internal/crawl/storage"
+	"github.com/internetofwater/nabu/internal/crawl/url_info"
+	"github.com/internetofwater/nabu/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadBoundedBody(t *testing.T) {
+	t.Run("body within the maximum is read", func(t *testing.T) {
+		data, tooLarge, err := readBoundedBody(strings.NewReader("12345"), -1, 5)
+		require.NoError(t, err)
+		require.False(t, tooLarge)
+		require.Equal(t, "12345", string(data))
+	})
+
+	t.Run("body past the maximum is too large", func(t *testing.T) {
+		data, tooLarge, err := readBoundedBody(strings.NewReader("123456"), -1, 5)
+		require.NoError(t, err)
+		require.True(t, tooLarge)
+		require.Nil(t, data)
+	})
+
+	t.Run("content length past the maximum fails before reading", func(t *testing.T) {
+		reader := strings.NewReader("123")
+		_, tooLarge, err := readBoundedBody(reader, 1<<30, 5)
+		require.NoError(t, err)
+		require.True(t, tooLarge)
+		require.Equal(t, 3, reader.Len(), "nothing should have been read")
+	})
+
+	t.Run("no maximum", func(t *testing.T) {
+		data, tooLarge, err := readBoundedBody(strings.NewReader("123456"), -1, 0)
+		require.NoError(t, err)
+		require.False(t, tooLarge)
+		require.Equal(t, "123456", string(data))
+	})
+}
+
+func TestReadBoundedLine(t *testing.T) {
+	longLine := strings.Repeat("a", 100)
+	// use the smallest buffer bufio allows so lines span many reads
+	reader := bufio.NewReaderSize(strings.NewReader("short\n"+longLine+"\n12345\nlast"), 16)
+
+	line, tooLarge, err := readBoundedLine(reader, 5)
+	require.NoError(t, err)
+	require.False(t, tooLarge)
+	require.Equal(t, "short\n", string(line), "the newline doesn't count towards the maximum")
+
+	line, tooLarge, err = readBoundedLine(reader, 5)
+	require.NoError(t, err)
+	require.True(t, tooLarge)
+	require.Nil(t, line)
+
+	line, tooLarge, err = readBoundedLine(reader, 5)
+	require.NoError(t, err)
+	require.False(t, tooLarge, "the rest of the long line should have been discarded")
+	require.Equal(t, "12345\n", string(line))
+
+	line, tooLarge, err = readBoundedLine(reader, 5)
+	require.ErrorIs(t, err, io.EOF)
+	require.False(t, tooLarge)
+	require.Equal(t, "last", string(line))
+}
+
+func TestHarvestDocumentTooLarge(t *testing.T) {
+	const dummy_domain = "https://example.com/large"
+
+	mockedClient := common.NewMockedClient(true, map[string]common.MockResponse{
+		dummy_domain: {
+			Body:        `{"@id": "https://example.com/large", "name": "` + strings.Repeat("a", 100) + `"}`,
+			ContentType: "application/ld+json",
+			StatusCode:  200,
+		},
+	})
+
+	url := url_info.NewUrlFromString(dummy_domain)
+	check := atomic.Bool{}
+	report, err := harvestOnePID(context.Background(), "DUMMY_SITEMAP", url, &SitemapHarvestConfig{
+		httpClient:                mockedClient,
+		storageDestination:        &storage.DiscardCrawlStorage{},
+		checkExistenceBeforeCrawl: &check,
+		maxDocumentBytes:          64,
+	})
+	require.NoError(t, err, "an oversized document should not be fatal")
+	require.Equal(t, pkg.DocumentTooLarge, report.nonFatalError.Category)
+	require.Equal(t, dummy_domain, report.nonFatalError.Url)
+	require.Empty(t, report.pathInStorage)
+	require.False(t, report.retryable)
+}
+
+func TestMaxDocumentSizeFromSitemapIndex(t *testing.T) {
+	sitemap := &Sitemap{
+		URL:      []url_info.URL{url_info.NewUrlFromString("https://example.com/1")},
+		metadata: SitemapMetadata{SitemapID: "test"},
+		workers:  1,
+	}
+	mockedClient := common.NewMockedClient(true, map[string]common.MockResponse{
+		"https://example.com/robots.txt": {StatusCode: 404, Body: "not found"},
+	})
+	robots := NewRobotsCache(mockedClient, defaultRobotsTTL, nil)
+
+	config, err := SitemapIndex{}.newSitemapHarvestConfig(mockedClient, sitemap, nil, robots)
+	require.NoError(t, err)
+	require.Equal(t, defaultMaxDocumentBytes, config.maxDocumentBytes, "the defaults should be kept if no size was set")
+	require.Equal(t, defaultMaxBulkLineBytes, config.maxBulkLineBytes)
+
+	config, err = SitemapIndex{}.WithMaxDocumentSize(0, 0).newSitemapHarvestConfig(mockedClient, sitemap, nil, robots)
+	require.NoError(t, err)
+	require.Zero(t, config.maxDocumentBytes, "0 should mean there is no maximum")
+	require.Zero(t, config.maxBulkLineBytes)
+}
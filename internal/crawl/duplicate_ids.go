@@ -0,0 +1,251 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/internetofwater/nabu/internal/crawl/storage"
+	"github.com/internetofwater/nabu/internal/crawl/url_info"
+	"github.com/internetofwater/nabu/pkg"
+)
+
+// Where the report of @ids published by more than one sitemap is stored
+const duplicateIdentifiersReportPath = "metadata/duplicate_ids.json"
+
+// The path in storage where the top level @ids of every document in a sitemap are stored so that
+// documents a later harvest skips as unchanged, and thus doesn't read, are still indexed
+func sitemapIdentifiersPath(sitemapId string) string {
+	return fmt.Sprintf("metadata/ids/%s.json", sitemapId)
+}
+
+// The top level @ids of the documents in a sitemap as of its last harvest
+type sitemapIdentifiers struct {
+	SitemapID string
+	// map of each url in the sitemap to the top level @ids of its document
+	Ids map[string][]string
+}
+
+// How top level @ids that are published by more than one sitemap are handled
+type DuplicateIdMode string
+
+const (
+	// @ids are not indexed
+	DuplicateIdsOff DuplicateIdMode = "off"
+	// duplicates are reported but the harvest still succeeds
+	DuplicateIdsWarn DuplicateIdMode = "warn"
+	// duplicates are reported and fail the harvest
+	DuplicateIdsError DuplicateIdMode = "error"
+)
+
+// Parse the mode for handling duplicate @ids from its name; an empty name is off
+func ParseDuplicateIdMode(mode string) (DuplicateIdMode, error) {
+	switch parsed := DuplicateIdMode(mode); parsed {
+	case "":
+		return DuplicateIdsOff, nil
+	case DuplicateIdsOff, DuplicateIdsWarn, DuplicateIdsError:
+		return parsed, nil
+	}
+	return "", fmt.Errorf("unknown duplicate id mode %q; must be one of %s, %s, or %s", mode, DuplicateIdsOff, DuplicateIdsWarn, DuplicateIdsError)
+}
+
+// A document that had a particular @id
+type identifierSource struct {
+	sitemapId string
+	url       string
+}
+
+// Indexes the top level @id of every document harvested across all
+// sitemaps in an index so that @ids published twice can be reported
+type identifierIndex struct {
+	mu sync.Mutex
+	// the first document seen with each @id
+	firstSeen map[string]identifierSource
+	// every later document with an @id that was already seen
+	duplicates map[string][]identifierSource
+	// the @ids indexed for each url of each sitemap; these are stored once a sitemap is harvested
+	bySitemap map[string]map[string][]string
+}
+
+func newIdentifierIndex() *identifierIndex {
+	return &identifierIndex{
+		firstSeen:  make(map[string]identifierSource),
+		duplicates: make(map[string][]identifierSource),
+		bySitemap:  make(map[string]map[string][]string),
+	}
+}
+
+// Return the top level @ids in a jsonld document; this is the @id of the document
+// itself or of each node in its @graph. Blank nodes are skipped since they are local to the document
+func topLevelIds(jsonld []byte) []string {
+	var document any
+	if err := json.Unmarshal(jsonld, &document); err != nil {
+		return nil
+	}
+	var nodes []any
+	switch typed := document.(type) {
+	case []any:
+		nodes = typed
+	case map[string]any:
+		if graph, ok := typed["@graph"].([]any); ok {
+			nodes = graph
+		}
+		nodes = append(nodes, typed)
+	}
+
+	ids := []string{}
+	for _, node := range nodes {
+		asMap, ok := node.(map[string]any)
+		if !ok {
+			continue
+		}
+		if id, ok := asMap["@id"].(string); ok && id != "" && !strings.HasPrefix(id, "_:") && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Index the top level @ids of a harvested document; this is a no-op if the index is nil
+func (i *identifierIndex) recordDocument(sitemapId string, url string, jsonld []byte) {
+	if i == nil {
+		return
+	}
+	ids := topLevelIds(jsonld)
+	if len(ids) == 0 {
+		// the url is still marked as indexed so that the @ids from its last harvest aren't restored
+		i.mu.Lock()
+		i.idsOfUrl(sitemapId, url)
+		i.mu.Unlock()
+	}
+	for _, id := range ids {
+		i.recordId(sitemapId, url, id)
+	}
+}
+
+// Return the @ids indexed for a url, adding the url if it wasn't indexed yet; the caller must hold the lock
+func (i *identifierIndex) idsOfUrl(sitemapId string, url string) []string {
+	urls, ok := i.bySitemap[sitemapId]
+	if !ok {
+		urls = make(map[string][]string)
+		i.bySitemap[sitemapId] = urls
+	}
+	ids, ok := urls[url]
+	if !ok {
+		ids = []string{}
+		urls[url] = ids
+	}
+	return ids
+}
+
+// Index a single top level @id; this is a no-op if the index is nil
+func (i *identifierIndex) recordId(sitemapId string, url string, id string) {
+	if i == nil {
+		return
+	}
+	source := identifierSource{sitemapId: sitemapId, url: url}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if ids := i.idsOfUrl(sitemapId, url); !slices.Contains(ids, id) {
+		i.bySitemap[sitemapId][url] = append(ids, id)
+	}
+	if _, seen := i.firstSeen[id]; !seen {
+		i.firstSeen[id] = source
+		return
+	}
+	i.duplicates[id] = append(i.duplicates[id], source)
+}
+
+// Index the @ids stored by the last harvest of a sitemap for the urls that weren't indexed during this one,
+// i.e. since their documents were skipped as unchanged. Only the given urls that are still in the sitemap are
+// restored, or every stored url if urls is nil. This is a no-op if the index is nil
+func (i *identifierIndex) restoreSkipped(storageDestination storage.CrawlStorage, sitemapId string, urls []url_info.URL) error {
+	if i == nil {
+		return nil
+	}
+	stored := sitemapIdentifiers{}
+	found, err := readJsonFromStorage(storageDestination, sitemapIdentifiersPath(sitemapId), &stored)
+	if err != nil || !found {
+		return err
+	}
+	if stored.SitemapID != sitemapId {
+		return fmt.Errorf("@ids at %s are for sitemap %s, not %s", sitemapIdentifiersPath(sitemapId), stored.SitemapID, sitemapId)
+	}
+
+	toRestore := stored.Ids
+	if urls != nil {
+		toRestore = make(map[string][]string)
+		for _, url := range urls {
+			if ids, ok := stored.Ids[url.Loc]; ok {
+				toRestore[url.Loc] = ids
+			}
+		}
+	}
+	i.mu.Lock()
+	indexed := i.bySitemap[sitemapId]
+	for url := range indexed {
+		delete(toRestore, url)
+	}
+	i.mu.Unlock()
+
+	for url, ids := range toRestore {
+		for _, id := range ids {
+			i.recordId(sitemapId, url, id)
+		}
+	}
+	return nil
+}
+
+// Store the @ids indexed for every url of a sitemap so that the next harvest
+// can restore the ones it skips; this is a no-op if the index is nil
+func (i *identifierIndex) store(storageDestination storage.CrawlStorage, sitemapId string) error {
+	if i == nil {
+		return nil
+	}
+	i.mu.Lock()
+	data, err := json.Marshal(sitemapIdentifiers{SitemapID: sitemapId, Ids: i.bySitemap[sitemapId]})
+	i.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return storageDestination.StoreWithoutServersideHash(sitemapIdentifiersPath(sitemapId), bytes.NewReader(data))
+}
+
+// Return a report of every @id that was published by more than one sitemap. An @id
+// that was only published more than once within a single sitemap isn't included
+func (i *identifierIndex) report() pkg.DuplicateIdentifierReport {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	report := pkg.DuplicateIdentifierReport{
+		IdentifiersIndexed: len(i.firstSeen),
+		Duplicates:         []pkg.DuplicateIdentifier{},
+	}
+	for id, later := range i.duplicates {
+		duplicate := pkg.DuplicateIdentifier{Id: id}
+		for _, source := range append([]identifierSource{i.firstSeen[id]}, later...) {
+			if !slices.Contains(duplicate.Sitemaps, source.sitemapId) {
+				duplicate.Sitemaps = append(duplicate.Sitemaps, source.sitemapId)
+			}
+			if !slices.Contains(duplicate.Urls, source.url) {
+				duplicate.Urls = append(duplicate.Urls, source.url)
+			}
+		}
+		if len(duplicate.Sitemaps) < 2 {
+			continue
+		}
+		slices.Sort(duplicate.Sitemaps)
+		slices.Sort(duplicate.Urls)
+		report.Duplicates = append(report.Duplicates, duplicate)
+	}
+	slices.SortFunc(report.Duplicates, func(a, b pkg.DuplicateIdentifier) int {
+		return strings.Compare(a.Id, b.Id)
+	})
+	return report
+}
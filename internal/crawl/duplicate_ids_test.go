@@ -0,0 +1,181 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/internetofwater/nabu/internal/crawl/storage"
+	"github.com/internetofwater/nabu/internal/crawl/url_info"
+	"github.com/internetofwater/nabu/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopLevelIds(t *testing.T) {
+	require.Equal(t, []string{"https://example.com/1"}, topLevelIds([]byte(`{"@id": "https://example.com/1", "geo": {"@id": "https://example.com/geo"}}`)))
+	require.Equal(t, []string{"https://example.com/1", "https://example.com/2"}, topLevelIds([]byte(`{"@graph": [{"@id": "https://example.com/1"}, {"@id": "https://example.com/2"}, {"@id": "_:b0"}]}`)))
+	require.Equal(t, []string{"https://example.com/1"}, topLevelIds([]byte(`[{"@id": "https://example.com/1"}, {"@id": "https://example.com/1"}]`)))
+	require.Empty(t, topLevelIds([]byte(`{"@id": "_:b0"}`)), "blank nodes are local to the document")
+	require.Empty(t, topLevelIds([]byte(`not json`)))
+}
+
+func TestIdentifierIndexReport(t *testing.T) {
+	index := newIdentifierIndex()
+	index.recordDocument("a", "https://a.example.com/1", []byte(`{"@id": "https://example.com/shared"}`))
+	index.recordDocument("b", "https://b.example.com/1", []byte(`{"@id": "https://example.com/shared"}`))
+	index.recordDocument("a", "https://a.example.com/2", []byte(`{"@id": "https://example.com/only_in_a"}`))
+	index.recordDocument("a", "https://a.example.com/3", []byte(`{"@id": "https://example.com/only_in_a"}`))
+	index.recordId("c", "bulk_image", "https://example.com/shared")
+
+	report := index.report()
+	require.Equal(t, 2, report.IdentifiersIndexed)
+	require.Equal(t, []pkg.DuplicateIdentifier{{
+		Id:       "https://example.com/shared",
+		Sitemaps: []string{"a", "b", "c"},
+		Urls:     []string{"bulk_image", "https://a.example.com/1", "https://b.example.com/1"},
+	}}, report.Duplicates, "duplicates within a single sitemap should not be reported")
+
+	var nilIndex *identifierIndex
+	require.NotPanics(t, func() { nilIndex.recordDocument("a", "https://a.example.com/1", []byte(`{}`)) })
+}
+
+func TestIdentifierIndexRestoresSkippedDocuments(t *testing.T) {
+	crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+	require.NoError(t, err)
+
+	previous := newIdentifierIndex()
+	previous.recordDocument("a", "https://a.example.com/1", []byte(`{"@id": "https://example.com/1"}`))
+	previous.recordDocument("a", "https://a.example.com/2", []byte(`{"@id": "https://example.com/2"}`))
+	previous.recordDocument("a", "https://a.example.com/removed", []byte(`{"@id": "https://example.com/removed"}`))
+	require.NoError(t, previous.store(crawlStorage, "a"))
+
+	index := newIdentifierIndex()
+	index.recordDocument("a", "https://a.example.com/2", []byte(`{"@id": "https://example.com/2_changed"}`))
+	urls := []url_info.URL{{Loc: "https://a.example.com/1"}, {Loc: "https://a.example.com/2"}}
+	require.NoError(t, index.restoreSkipped(crawlStorage, "a", urls))
+	require.Equal(t, map[string][]string{
+		"https://a.example.com/1": {"https://example.com/1"},
+		"https://a.example.com/2": {"https://example.com/2_changed"},
+	}, index.bySitemap["a"], "only the skipped url that is still in the sitemap should be restored")
+
+	unharvested := newIdentifierIndex()
+	require.NoError(t, unharvested.restoreSkipped(crawlStorage, "a", nil))
+	require.Equal(t, 3, unharvested.report().IdentifiersIndexed, "every stored url is restored for a sitemap that was skipped entirely")
+
+	require.NoError(t, index.restoreSkipped(crawlStorage, "never_harvested", urls))
+}
+
+func TestParseDuplicateIdMode(t *testing.T) {
+	mode, err := ParseDuplicateIdMode("error")
+	require.NoError(t, err)
+	require.Equal(t, DuplicateIdsError, mode)
+
+	mode, err = ParseDuplicateIdMode("")
+	require.NoError(t, err)
+	require.Equal(t, DuplicateIdsOff, mode)
+
+	_, err = ParseDuplicateIdMode("fail")
+	require.Error(t, err)
+}
+
+// Serve a sitemap index with two sitemaps that each publish a document with the same @id
+func newDuplicateIdServer(t *testing.T) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			_, _ = w.Write([]byte("User-agent: *\nAllow: /\n"))
+		case "/sitemap.xml":
+			_, _ = fmt.Fprintf(w, `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:geoconnex="https://geoconnex.us">
+				<sitemap><loc>%s/first.xml</loc><geoconnex:sitemap_id>first</geoconnex:sitemap_id></sitemap>
+				<sitemap><loc>%s/second.xml</loc><geoconnex:sitemap_id>second</geoconnex:sitemap_id></sitemap>
+			</sitemapindex>`, server.URL, server.URL)
+		case "/first.xml", "/second.xml":
+			_, _ = fmt.Fprintf(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"><url><loc>%s%s/feature</loc><lastmod>2026-01-01</lastmod></url></urlset>`, server.URL, r.URL.Path[:len(r.URL.Path)-len(".xml")])
+		case "/first/feature", "/second/feature":
+			w.Header().Set("Content-Type", "application/ld+json")
+			_, _ = w.Write([]byte(`{"@id": "https://example.com/shared_feature"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHarvestReportsDuplicateIds(t *testing.T) {
+	harvestInto := func(t *testing.T, server *httptest.Server, crawlStorage storage.CrawlStorage, mode DuplicateIdMode) (pkg.SitemapIndexCrawlStats, error) {
+		index, err := NewSitemapIndex(server.URL+"/sitemap.xml", server.Client())
+		require.NoError(t, err)
+		return index.
+			WithStorageDestination(crawlStorage).
+			WithConcurrencyConfig(2, 1).
+			WithIncrementalHarvest(true).
+			WithDuplicateIdDetection(mode).
+			HarvestSitemaps(context.Background(), server.Client())
+	}
+	harvest := func(t *testing.T, mode DuplicateIdMode) (*storage.LocalTempFSCrawlStorage, error) {
+		crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+		require.NoError(t, err)
+		_, err = harvestInto(t, newDuplicateIdServer(t), crawlStorage, mode)
+		return crawlStorage, err
+	}
+
+	t.Run("duplicates are a warning", func(t *testing.T) {
+		crawlStorage, err := harvest(t, DuplicateIdsWarn)
+		require.NoError(t, err)
+
+		reader, err := crawlStorage.Get(duplicateIdentifiersReportPath)
+		require.NoError(t, err)
+		defer func() { _ = reader.Close() }()
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+
+		var report pkg.DuplicateIdentifierReport
+		require.NoError(t, json.Unmarshal(data, &report))
+		require.Equal(t, 1, report.IdentifiersIndexed)
+		require.Len(t, report.Duplicates, 1)
+		require.Equal(t, "https://example.com/shared_feature", report.Duplicates[0].Id)
+		require.Equal(t, []string{"first", "second"}, report.Duplicates[0].Sitemaps)
+		require.Len(t, report.Duplicates[0].Urls, 2)
+	})
+
+	t.Run("duplicates are an error", func(t *testing.T) {
+		crawlStorage, err := harvest(t, DuplicateIdsError)
+		require.ErrorContains(t, err, "found 1 @ids published by more than one sitemap")
+
+		exists, err := crawlStorage.Exists(duplicateIdentifiersReportPath)
+		require.NoError(t, err)
+		require.True(t, exists, "the report should be stored even if duplicates fail the harvest")
+	})
+
+	t.Run("documents skipped as unchanged are still indexed", func(t *testing.T) {
+		server := newDuplicateIdServer(t)
+		crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+		require.NoError(t, err)
+		_, err = harvestInto(t, server, crawlStorage, DuplicateIdsWarn)
+		require.NoError(t, err)
+
+		stats, err := harvestInto(t, server, crawlStorage, DuplicateIdsError)
+		require.ErrorContains(t, err, "found 1 @ids published by more than one sitemap")
+		for _, sitemapStats := range stats {
+			require.Equal(t, 1, sitemapStats.SitesWithUnchangedLastMod, "the document should not be read again")
+		}
+	})
+
+	t.Run("detection is off", func(t *testing.T) {
+		crawlStorage, err := harvest(t, DuplicateIdsOff)
+		require.NoError(t, err)
+
+		exists, err := crawlStorage.Exists(duplicateIdentifiersReportPath)
+		require.NoError(t, err)
+		require.False(t, exists)
+	})
+}
@@ -0,0 +1,104 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package hashchecks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/internetofwater/nabu/internal/crawl/url_info"
+)
+
+// The cache validators a server returned alongside a jsonld document.
+// These are sent back on the next harvest so that ordinary web servers and CDNs
+// which don't support Content-Digest can tell us the document hasn't changed
+type CacheValidators struct {
+	// the ETag response header
+	ETag string
+	// the Last-Modified response header
+	LastModified string
+}
+
+// Return true if the server didn't provide any validators
+func (v CacheValidators) IsEmpty() bool {
+	return v.ETag == "" && v.LastModified == ""
+}
+
+// Add the conditional headers to a request so that the server
+// can respond with 304 Not Modified if the document is unchanged
+func (v CacheValidators) SetConditionalHeaders(req *http.Request) {
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
+	}
+}
+
+// Get the cache validators from the headers of a response
+func CacheValidatorsFromResponse(resp *http.Response) CacheValidators {
+	return CacheValidators{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+}
+
+// The path in storage where the cache validators for a url are stored;
+// this is kept outside of summoned/ so it is never treated as jsonld
+func CacheValidatorsPath(sitemapId string, url url_info.URL) string {
+	return fmt.Sprintf("cache_validators/%s/%s.json", sitemapId, url.Base64Loc)
+}
+
+// Get the cache validators that were stored the last time the url was harvested.
+// The boolean is false if there are no validators or if the jsonld they describe
+// no longer exists in storage, since a 304 would then leave us with no data
+func (hc *hashChecker) GetCacheValidators(url url_info.URL, sitemapId string) (CacheValidators, bool, error) {
+	path := CacheValidatorsPath(sitemapId, url)
+	exists, err := hc.storage.Exists(path)
+	if err != nil || !exists {
+		return CacheValidators{}, false, err
+	}
+
+	summonedPath, err := url_info.SummonedPath(sitemapId, url)
+	if err != nil {
+		return CacheValidators{}, false, err
+	}
+	jsonldExists, err := hc.storage.Exists(summonedPath)
+	if err != nil || !jsonldExists {
+		return CacheValidators{}, false, err
+	}
+
+	reader, err := hc.storage.Get(path)
+	if err != nil {
+		return CacheValidators{}, false, err
+	}
+	defer func() { _ = reader.Close() }()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return CacheValidators{}, false, err
+	}
+	var validators CacheValidators
+	if err := json.Unmarshal(data, &validators); err != nil {
+		return CacheValidators{}, false, fmt.Errorf("failed to parse cache validators at %s: %w", path, err)
+	}
+	return validators, !validators.IsEmpty(), nil
+}
+
+// Store the cache validators from a response so they can be used on the next harvest
+func (hc *hashChecker) StoreCacheValidators(url url_info.URL, sitemapId string, validators CacheValidators) error {
+	data, err := json.Marshal(validators)
+	if err != nil {
+		return err
+	}
+	return hc.storage.StoreWithoutServersideHash(CacheValidatorsPath(sitemapId, url), bytes.NewReader(data))
+}
+
+// Remove the cache validators for a url; this is used when a server
+// stops providing them so we don't send outdated conditional requests
+func (hc *hashChecker) RemoveCacheValidators(url url_info.URL, sitemapId string) error {
+	return hc.storage.Remove(CacheValidatorsPath(sitemapId, url))
+}
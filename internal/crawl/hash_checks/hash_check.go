@@ -5,8 +5,12 @@ package hashchecks
 
 import (
 	"context"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
 
@@ -29,39 +33,113 @@ func NewHashChecker(httpClient *http.Client, storage storage.CrawlStorage) *hash
 	}
 }
 
-// Get the hash of the remote jsonld by using the Content-Digest header
+// The digest algorithms we ask servers for in order of preference, using the
+// RFC 9530 preference syntax. md5 is deprecated but is still what many servers offer
+const wantContentDigest = "sha-256=10, sha-512=5, md5=1"
+
+// A digest of the remote jsonld and the algorithm used to generate it
+type remoteDigest struct {
+	// the algorithm name as registered for RFC 9530; i.e. sha-256
+	algorithm string
+	// the hex encoded digest
+	hash string
+}
+
+// Get the digest of the remote jsonld by using the Content-Digest header
 // This gets us metadata about the file without needing to download it fully
-func (hc *hashChecker) getJsonldHashFromAPI(url url_info.URL) (storage.Md5Hash, error) {
+func (hc *hashChecker) getJsonldHashFromAPI(url url_info.URL) (remoteDigest, error) {
 	req, err := http.NewRequestWithContext(context.Background(), http.MethodHead, url.Loc, nil)
 	if err != nil {
-		return "", err
+		return remoteDigest{}, err
 	}
 
 	req.Header.Set("User-Agent", common.HarvestAgent)
-	// We request md5 since it is the same hash
-	// as what is provided by the ETag in minio
-	req.Header.Set("Want-Content-Digest", "md5")
+	req.Header.Set("Want-Content-Digest", wantContentDigest)
 	req.Header.Set("Accept", "application/ld+json")
 
 	resp, err := hc.httpClient.Do(req)
 	if err != nil {
-		return "", err
+		return remoteDigest{}, err
 	}
 
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode >= 400 {
-		return "", nil
+		return remoteDigest{}, nil
+	}
+
+	digests := parseContentDigest(resp.Header.Get("content-digest"))
+	// use the strongest digest the server offered
+	for _, algorithm := range []string{"sha-256", "sha-512", "md5"} {
+		if hash, ok := digests[algorithm]; ok {
+			return remoteDigest{algorithm: algorithm, hash: hash}, nil
+		}
 	}
-	hash := resp.Header.Get("content-digest")
+	return remoteDigest{}, nil
+}
+
+// Parse a Content-Digest header into a map of algorithm to hex encoded digest.
+// RFC 9530 digests are a structured field dictionary of byte sequences; i.e.
+// sha-256=:base64:, but md5 digests are often sent as bare hex; i.e. md5=hex or md5-hex
+func parseContentDigest(header string) map[string]string {
+	digests := make(map[string]string)
+	for member := range strings.SplitSeq(header, ",") {
+		member = strings.TrimSpace(member)
+		algorithm, value, found := strings.Cut(member, "=")
+		if !found {
+			// the legacy md5-<hex> form
+			algorithm, value, found = strings.Cut(member, "-")
+			if !found || strings.ToLower(algorithm) != "md5" {
+				continue
+			}
+		}
+		algorithm = strings.ToLower(strings.TrimSpace(algorithm))
+		// drop any parameters on the dictionary member
+		value, _, _ = strings.Cut(strings.TrimSpace(value), ";")
+
+		if strings.HasPrefix(value, ":") && strings.HasSuffix(value, ":") && len(value) > 1 {
+			decoded, err := base64.StdEncoding.DecodeString(strings.Trim(value, ":"))
+			if err != nil {
+				log.Debugf("Skipping invalid %s digest %s: %v", algorithm, value, err)
+				continue
+			}
+			digests[algorithm] = hex.EncodeToString(decoded)
+		} else if algorithm == "md5" && value != "" {
+			digests[algorithm] = strings.ToLower(value)
+		}
+	}
+	return digests
+}
 
-	// make sure we get just the hash value
-	// not the metadata about the hash itself
-	trimmed := strings.TrimPrefix(hash, "md5=")
-	trimmed = strings.TrimPrefix(trimmed, "md5-")
-	trimmed = strings.TrimSpace(trimmed)
+// Compute the sha-512 of a file in storage; this is only used when a server
+// offers sha-512 but not sha-256 since storage only records sha-256
+func (hc *hashChecker) sha512FromStorage(path storage.ObjectPath) (string, bool, error) {
+	exists, err := hc.storage.Exists(path)
+	if err != nil || !exists {
+		return "", false, err
+	}
+	reader, err := hc.storage.Get(path)
+	if err != nil {
+		return "", true, err
+	}
+	defer func() { _ = reader.Close() }()
+	hasher := sha512.New()
+	if _, err := io.Copy(hasher, reader); err != nil {
+		return "", true, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), true, nil
+}
 
-	return trimmed, nil
+// Get the hash of the file in storage using the same algorithm as the remote digest
+func (hc *hashChecker) hashFromStorage(path storage.ObjectPath, algorithm string) (string, bool, error) {
+	switch algorithm {
+	case "sha-256":
+		return hc.storage.GetSha256(path)
+	case "sha-512":
+		return hc.sha512FromStorage(path)
+	default:
+		return hc.storage.GetHash(path)
+	}
 }
 
 type HashCheckResult struct {
@@ -76,16 +154,17 @@ type HashCheckResult struct {
 // Check to determine if the file with the hash already exists in storage
 func (hc *hashChecker) CheckIfAlreadyExists(url url_info.URL, sitemapId string) (HashCheckResult, error) {
 
-	remoteHash, err := hc.getJsonldHashFromAPI(url)
+	remote, err := hc.getJsonldHashFromAPI(url)
 	var maxErr *common.MaxRetryError
 	if errors.As(err, &maxErr) {
-		return HashCheckResult{}, pkg.UrlCrawlError{Url: url.Loc, Message: err.Error()}
+		// the cause is kept so the caller can decide whether the url is worth retrying
+		return HashCheckResult{}, errors.Join(pkg.UrlCrawlError{Url: url.Loc, Message: err.Error()}, err)
 	}
 	if err != nil {
 		return HashCheckResult{}, fmt.Errorf("failed to get hash for %s: %w", url.Loc, err)
 	}
 	var expectedLocationInStorage string
-	if remoteHash == "" {
+	if remote.hash == "" {
 		log.Tracef("%s did not provide a hash to compare for caching", url.Loc)
 		return HashCheckResult{
 			ServerProvidedHash: false,
@@ -97,8 +176,11 @@ func (hc *hashChecker) CheckIfAlreadyExists(url url_info.URL, sitemapId string)
 	}
 
 	// the location in storage is the base64 encoded URL with .jsonld extension
-	expectedLocationInStorage = "summoned/" + sitemapId + "/" + url.Base64Loc + ".jsonld"
-	storageHash, file_exists, err := hc.storage.GetHash(expectedLocationInStorage)
+	expectedLocationInStorage, err = url_info.SummonedPath(sitemapId, url)
+	if err != nil {
+		return hashCheckMetadata, err
+	}
+	storageHash, file_exists, err := hc.hashFromStorage(expectedLocationInStorage, remote.algorithm)
 	if err != nil {
 		return hashCheckMetadata, err
 	}
@@ -110,7 +192,7 @@ func (hc *hashChecker) CheckIfAlreadyExists(url url_info.URL, sitemapId string)
 		}, nil
 	}
 
-	if storageHash == remoteHash {
+	if storageHash != "" && storageHash == remote.hash {
 		log.Tracef("skipping %s because it already exists in %s", url.Loc, expectedLocationInStorage)
 		hashCheckMetadata.PathInStorage = expectedLocationInStorage
 		hashCheckMetadata.FileAlreadyExists = true
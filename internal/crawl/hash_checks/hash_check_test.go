@@ -0,0 +1,105 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package hashchecks
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/internetofwater/nabu/internal/crawl/storage"
+	"github.com/internetofwater/nabu/internal/crawl/url_info"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseContentDigest(t *testing.T) {
+	data := []byte("test data")
+	sha256Sum := sha256.Sum256(data)
+	sha512Sum := sha512.Sum512(data)
+	md5Sum := md5.Sum(data)
+
+	testCases := []struct {
+		header   string
+		expected map[string]string
+	}{
+		{
+			header:   "sha-256=:" + base64.StdEncoding.EncodeToString(sha256Sum[:]) + ":",
+			expected: map[string]string{"sha-256": hex.EncodeToString(sha256Sum[:])},
+		},
+		{
+			header: "sha-512=:" + base64.StdEncoding.EncodeToString(sha512Sum[:]) + ":, sha-256=:" + base64.StdEncoding.EncodeToString(sha256Sum[:]) + ":",
+			expected: map[string]string{
+				"sha-256": hex.EncodeToString(sha256Sum[:]),
+				"sha-512": hex.EncodeToString(sha512Sum[:]),
+			},
+		},
+		{
+			header:   "md5=" + hex.EncodeToString(md5Sum[:]),
+			expected: map[string]string{"md5": hex.EncodeToString(md5Sum[:])},
+		},
+		{
+			header:   "md5-" + hex.EncodeToString(md5Sum[:]),
+			expected: map[string]string{"md5": hex.EncodeToString(md5Sum[:])},
+		},
+		{
+			header:   "md5=:" + base64.StdEncoding.EncodeToString(md5Sum[:]) + ":",
+			expected: map[string]string{"md5": hex.EncodeToString(md5Sum[:])},
+		},
+		{
+			header:   "sha-256=:not base64!:",
+			expected: map[string]string{},
+		},
+		{
+			header:   "",
+			expected: map[string]string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t, tc.expected, parseContentDigest(tc.header), tc.header)
+	}
+}
+
+func TestCheckIfAlreadyExistsWithSha256(t *testing.T) {
+	data := []byte(`{"@id": "https://example.com/1"}`)
+	sha256Sum := sha256.Sum256(data)
+	sha512Sum := sha512.Sum512(data)
+
+	var wantDigest string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wantDigest = r.Header.Get("Want-Content-Digest")
+		switch r.URL.Path {
+		case "/sha256":
+			w.Header().Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sha256Sum[:])+":")
+		case "/sha512":
+			w.Header().Set("Content-Digest", "sha-512=:"+base64.StdEncoding.EncodeToString(sha512Sum[:])+":")
+		case "/changed":
+			changed := sha256.Sum256([]byte("changed"))
+			w.Header().Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(changed[:])+":")
+		}
+	}))
+	defer server.Close()
+
+	crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+	require.NoError(t, err)
+	checker := NewHashChecker(server.Client(), crawlStorage)
+
+	for _, path := range []string{"/sha256", "/sha512", "/changed"} {
+		url := url_info.NewUrlFromString(server.URL + path)
+		err := crawlStorage.StoreWithHash("summoned/test/"+url.Base64Loc+".jsonld", bytes.NewReader(data), len(data))
+		require.NoError(t, err)
+
+		result, err := checker.CheckIfAlreadyExists(url, "test")
+		require.NoError(t, err)
+		require.True(t, result.ServerProvidedHash)
+		require.Equal(t, path != "/changed", result.FileAlreadyExists, path)
+	}
+	require.Equal(t, wantContentDigest, wantDigest)
+}
@@ -0,0 +1,254 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package headless
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+// The javascript used to check whether the page has rendered its jsonld yet
+const jsonldPresentExpression = `document.querySelector('script[type*="application/ld+json"]') !== null`
+
+// The javascript used to get the rendered DOM
+const outerHTMLExpression = `document.documentElement.outerHTML`
+
+// An error about rendering a particular page, such as the page failing to
+// load or never producing jsonld, as opposed to an issue with the browser itself
+type PageError struct {
+	Url     string
+	Message string
+}
+
+func (e PageError) Error() string {
+	return fmt.Sprintf("failed to render %s: %s", e.Url, e.Message)
+}
+
+// Renders pages in a headless Chrome instance using the Chrome DevTools Protocol.
+// This is used for pages whose jsonld is injected client side with javascript
+type ChromeRenderer struct {
+	// the http address of the devtools endpoint; i.e. http://0.0.0.0:9222
+	devtoolsUrl string
+	httpClient  *http.Client
+	// the maximum time to wait for a page to render its jsonld
+	timeout time.Duration
+	// the time between each check for jsonld while waiting for the page to render
+	pollInterval time.Duration
+}
+
+// Create a renderer for the Chrome instance at the devtools address; i.e. 0.0.0.0:9222
+// Chrome rejects devtools websockets from unknown origins, so it must be started with
+// --remote-allow-origins set to the devtools url or *
+func NewChromeRenderer(devtoolsAddress string, httpClient *http.Client) *ChromeRenderer {
+	devtoolsUrl := devtoolsAddress
+	if !strings.Contains(devtoolsUrl, "://") {
+		devtoolsUrl = "http://" + devtoolsUrl
+	}
+	return &ChromeRenderer{
+		devtoolsUrl:  strings.TrimSuffix(devtoolsUrl, "/"),
+		httpClient:   httpClient,
+		timeout:      30 * time.Second,
+		pollInterval: 250 * time.Millisecond,
+	}
+}
+
+// Set the maximum time to wait for a page to render its jsonld
+func (r *ChromeRenderer) WithTimeout(timeout time.Duration) *ChromeRenderer {
+	r.timeout = timeout
+	return r
+}
+
+// Get the websocket url used to control the browser
+func (r *ChromeRenderer) browserWebsocketUrl(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.devtoolsUrl+"/json/version", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to headless chrome at %s: %w", r.devtoolsUrl, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("got status %s from headless chrome at %s", resp.Status, r.devtoolsUrl)
+	}
+	var version struct {
+		WebSocketDebuggerUrl string `json:"webSocketDebuggerUrl"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&version); err != nil {
+		return "", fmt.Errorf("failed to decode the version info from headless chrome: %w", err)
+	}
+	if version.WebSocketDebuggerUrl == "" {
+		return "", fmt.Errorf("headless chrome at %s did not provide a websocket debugger url", r.devtoolsUrl)
+	}
+	return version.WebSocketDebuggerUrl, nil
+}
+
+// A single command or response in the devtools protocol
+type cdpMessage struct {
+	ID        int64           `json:"id,omitempty"`
+	SessionID string          `json:"sessionId,omitempty"`
+	Method    string          `json:"method,omitempty"`
+	Params    any             `json:"params,omitempty"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// A connection to the browser; commands are sent one at a time
+type cdpConnection struct {
+	conn   *websocket.Conn
+	lastID int64
+}
+
+// Send a command and wait for its response, skipping any events sent in the meantime.
+// If sessionId is set the command is sent to the page attached to that session
+func (c *cdpConnection) call(ctx context.Context, sessionId string, method string, params any, result any) error {
+	c.lastID++
+	id := c.lastID
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := c.conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+	if err := websocket.JSON.Send(c.conn, cdpMessage{ID: id, SessionID: sessionId, Method: method, Params: params}); err != nil {
+		return fmt.Errorf("failed to send %s to headless chrome: %w", method, err)
+	}
+	for {
+		var response cdpMessage
+		if err := websocket.JSON.Receive(c.conn, &response); err != nil {
+			return fmt.Errorf("failed to receive the response to %s from headless chrome: %w", method, err)
+		}
+		if response.ID != id {
+			continue
+		}
+		if response.Error != nil {
+			return fmt.Errorf("headless chrome returned an error for %s: %s", method, response.Error.Message)
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(response.Result, result)
+	}
+}
+
+// Evaluate javascript in the page and decode the value it returns
+func (c *cdpConnection) evaluate(ctx context.Context, sessionId string, expression string, value any) error {
+	var evaluation struct {
+		Result struct {
+			Value json.RawMessage `json:"value"`
+		} `json:"result"`
+		ExceptionDetails *struct {
+			Text string `json:"text"`
+		} `json:"exceptionDetails"`
+	}
+	params := map[string]any{"expression": expression, "returnByValue": true}
+	if err := c.call(ctx, sessionId, "Runtime.evaluate", params, &evaluation); err != nil {
+		return err
+	}
+	if evaluation.ExceptionDetails != nil {
+		return fmt.Errorf("javascript exception when evaluating '%s': %s", expression, evaluation.ExceptionDetails.Text)
+	}
+	return json.Unmarshal(evaluation.Result.Value, value)
+}
+
+// Load the page in a new tab, wait for its jsonld to appear, and return the rendered html.
+// Issues with the page itself are returned as a PageError
+func (r *ChromeRenderer) Render(parentCtx context.Context, pageUrl string) (string, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, r.timeout)
+	defer cancel()
+
+	browserUrl, err := r.browserWebsocketUrl(ctx)
+	if err != nil {
+		return "", err
+	}
+	config, err := websocket.NewConfig(browserUrl, r.devtoolsUrl)
+	if err != nil {
+		return "", err
+	}
+	conn, err := config.DialContext(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to open a websocket to headless chrome at %s: %w", browserUrl, err)
+	}
+	defer func() { _ = conn.Close() }()
+	cdp := &cdpConnection{conn: conn}
+
+	var target struct {
+		TargetID string `json:"targetId"`
+	}
+	if err := cdp.call(ctx, "", "Target.createTarget", map[string]any{"url": "about:blank"}, &target); err != nil {
+		return "", err
+	}
+	defer func() {
+		// use a fresh context so the tab is closed even if the page timed out
+		closeCtx, closeCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer closeCancel()
+		if err := cdp.call(closeCtx, "", "Target.closeTarget", map[string]any{"targetId": target.TargetID}, nil); err != nil {
+			log.Warnf("failed to close headless chrome tab for %s: %v", pageUrl, err)
+		}
+	}()
+
+	var session struct {
+		SessionID string `json:"sessionId"`
+	}
+	if err := cdp.call(ctx, "", "Target.attachToTarget", map[string]any{"targetId": target.TargetID, "flatten": true}, &session); err != nil {
+		return "", err
+	}
+
+	var navigation struct {
+		ErrorText string `json:"errorText"`
+	}
+	if err := cdp.call(ctx, session.SessionID, "Page.navigate", map[string]any{"url": pageUrl}, &navigation); err != nil {
+		return "", err
+	}
+	if navigation.ErrorText != "" {
+		return "", PageError{Url: pageUrl, Message: navigation.ErrorText}
+	}
+
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+	for {
+		var jsonldPresent bool
+		if err := cdp.evaluate(ctx, session.SessionID, jsonldPresentExpression, &jsonldPresent); err != nil {
+			// the websocket deadline can pass just before the context is marked done
+			if ctx.Err() != nil || errors.Is(err, os.ErrDeadlineExceeded) {
+				return "", r.timeoutError(parentCtx, pageUrl)
+			}
+			return "", err
+		}
+		if jsonldPresent {
+			var renderedHtml string
+			if err := cdp.evaluate(ctx, session.SessionID, outerHTMLExpression, &renderedHtml); err != nil {
+				return "", err
+			}
+			return renderedHtml, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", r.timeoutError(parentCtx, pageUrl)
+		case <-ticker.C:
+		}
+	}
+}
+
+// The error returned when the context ended while waiting for a page to render;
+// it is only a PageError if the render timed out rather than the harvest being cancelled
+func (r *ChromeRenderer) timeoutError(parentCtx context.Context, pageUrl string) error {
+	if err := parentCtx.Err(); err != nil {
+		return err
+	}
+	return PageError{Url: pageUrl, Message: fmt.Sprintf("no JSON-LD appeared within %s", r.timeout)}
+}
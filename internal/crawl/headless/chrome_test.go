@@ -0,0 +1,59 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package headless
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const renderedPage = `<html><head><script type="application/ld+json">{"@id": "https://example.com/1"}</script></head></html>`
+
+func TestRenderWaitsForJsonld(t *testing.T) {
+	fake := NewFakeChrome(map[string]string{"https://example.com/1": renderedPage})
+	defer fake.Close()
+
+	renderer := NewChromeRenderer(strings.TrimPrefix(fake.URL, "http://"), http.DefaultClient)
+	renderer.pollInterval = time.Millisecond
+
+	html, err := renderer.Render(context.Background(), "https://example.com/1")
+	require.NoError(t, err)
+	require.Equal(t, renderedPage, html)
+	require.Equal(t, []string{"https://example.com/1"}, fake.Navigated)
+}
+
+func TestRenderWithoutJsonld(t *testing.T) {
+	fake := NewFakeChrome(map[string]string{"https://example.com/no_jsonld": "<html></html>"})
+	defer fake.Close()
+
+	renderer := NewChromeRenderer(fake.URL, http.DefaultClient).WithTimeout(100 * time.Millisecond)
+	renderer.pollInterval = time.Millisecond
+
+	_, err := renderer.Render(context.Background(), "https://example.com/no_jsonld")
+	var pageErr PageError
+	require.ErrorAs(t, err, &pageErr)
+	require.Contains(t, pageErr.Message, "no JSON-LD appeared")
+}
+
+func TestRenderPageThatFailsToLoad(t *testing.T) {
+	fake := NewFakeChrome(map[string]string{})
+	defer fake.Close()
+
+	_, err := NewChromeRenderer(fake.URL, http.DefaultClient).Render(context.Background(), "https://does-not-exist.example.com")
+	var pageErr PageError
+	require.ErrorAs(t, err, &pageErr)
+	require.Equal(t, "net::ERR_NAME_NOT_RESOLVED", pageErr.Message)
+}
+
+func TestRenderWithoutChrome(t *testing.T) {
+	_, err := NewChromeRenderer("127.0.0.1:1", http.DefaultClient).Render(context.Background(), "https://example.com/1")
+	require.ErrorContains(t, err, "failed to connect to headless chrome")
+	var pageErr PageError
+	require.NotErrorAs(t, err, &pageErr, "an unreachable browser is not an issue with the page")
+}
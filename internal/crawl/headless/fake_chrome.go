@@ -0,0 +1,111 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package headless
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// A fake Chrome devtools server for testing. It serves the given html for each
+// page url and, like a page that injects jsonld client side, only reports
+// the jsonld as present after the page has been polled once
+type FakeChrome struct {
+	*httptest.Server
+	pages map[string]string
+
+	mu sync.Mutex
+	// the urls that were navigated to
+	Navigated []string
+}
+
+// Start a fake Chrome devtools server that serves html for each url in pages
+func NewFakeChrome(pages map[string]string) *FakeChrome {
+	fake := &FakeChrome{pages: pages}
+	mux := http.NewServeMux()
+	fake.Server = httptest.NewServer(mux)
+
+	mux.HandleFunc("/json/version", func(w http.ResponseWriter, r *http.Request) {
+		wsUrl := "ws" + strings.TrimPrefix(fake.URL, "http") + "/devtools/browser/fake"
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"Browser":              "HeadlessChrome/fake",
+			"webSocketDebuggerUrl": wsUrl,
+		})
+	})
+	mux.Handle("/devtools/browser/fake", websocket.Handler(fake.handleConnection))
+	return fake
+}
+
+// The arguments of the devtools commands that the fake supports
+type fakeParams struct {
+	Url        string `json:"url"`
+	TargetID   string `json:"targetId"`
+	Expression string `json:"expression"`
+}
+
+// Respond to devtools commands for a single websocket connection
+func (f *FakeChrome) handleConnection(conn *websocket.Conn) {
+	sessions := map[string]string{}
+	polls := map[string]int{}
+	targets := 0
+
+	for {
+		var request struct {
+			ID        int64      `json:"id"`
+			SessionID string     `json:"sessionId"`
+			Method    string     `json:"method"`
+			Params    fakeParams `json:"params"`
+		}
+		if err := websocket.JSON.Receive(conn, &request); err != nil {
+			return
+		}
+
+		// send an event first to make sure clients skip them
+		_ = websocket.JSON.Send(conn, map[string]any{"method": "Target.targetCreated", "params": map[string]any{}})
+
+		var result any
+		switch request.Method {
+		case "Target.createTarget":
+			targets++
+			result = map[string]string{"targetId": fmt.Sprintf("target-%d", targets)}
+		case "Target.attachToTarget":
+			sessionId := "session-" + request.Params.TargetID
+			sessions[sessionId] = ""
+			result = map[string]string{"sessionId": sessionId}
+		case "Target.closeTarget":
+			result = map[string]bool{"success": true}
+		case "Page.navigate":
+			if _, ok := f.pages[request.Params.Url]; !ok {
+				result = map[string]string{"frameId": "frame", "errorText": "net::ERR_NAME_NOT_RESOLVED"}
+				break
+			}
+			f.mu.Lock()
+			f.Navigated = append(f.Navigated, request.Params.Url)
+			f.mu.Unlock()
+			sessions[request.SessionID] = request.Params.Url
+			result = map[string]string{"frameId": "frame"}
+		case "Runtime.evaluate":
+			page := f.pages[sessions[request.SessionID]]
+			var value any
+			switch request.Params.Expression {
+			case jsonldPresentExpression:
+				polls[request.SessionID]++
+				value = polls[request.SessionID] > 1 && strings.Contains(page, "application/ld+json")
+			case outerHTMLExpression:
+				value = page
+			}
+			result = map[string]any{"result": map[string]any{"value": value}}
+		default:
+			_ = websocket.JSON.Send(conn, map[string]any{"id": request.ID, "error": map[string]any{"code": -32601, "message": "method not found"}})
+			continue
+		}
+		_ = websocket.JSON.Send(conn, map[string]any{"id": request.ID, "result": result})
+	}
+}
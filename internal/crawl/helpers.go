@@ -5,7 +5,9 @@ package crawl
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strings"
@@ -13,10 +15,31 @@ import (
 
 	"golang.org/x/net/html"
 
-	common "github.com/internetofwater/nabu/internal/common"
+	"github.com/internetofwater/nabu/internal/crawl/storage"
 	"github.com/temoto/robotstxt"
 )
 
+// Read a json object from storage into v; the boolean is false if the object does not exist
+func readJsonFromStorage(storageDestination storage.CrawlStorage, path string, v any) (bool, error) {
+	exists, err := storageDestination.Exists(path)
+	if err != nil || !exists {
+		return false, err
+	}
+	reader, err := storageDestination.Get(path)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = reader.Close() }()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("failed to parse json at %s: %w", path, err)
+	}
+	return true, nil
+}
+
 // Given a url, strip off the end and just return the hostname with the
 // proper protocol
 func getHostname(urlToCheck string) (string, error) {
@@ -29,7 +52,7 @@ func getHostname(urlToCheck string) (string, error) {
 
 // Create a new robots.txt object from a remote url
 // this can be used to check if we are allowed to crawl
-func newRobots(httpClient *http.Client, urlToCheck string) (*robotstxt.Group, error) {
+func newRobots(httpClient *http.Client, urlToCheck string) (*robotstxt.RobotsData, error) {
 
 	basename, err := getHostname(urlToCheck)
 	if err != nil {
@@ -42,45 +65,128 @@ func newRobots(httpClient *http.Client, urlToCheck string) (*robotstxt.Group, er
 	if err != nil {
 		return nil, err
 	}
+	defer func() { _ = resp.Body.Close() }()
 
-	robots, err := robotstxt.FromResponse(resp)
-	if err != nil {
-		return nil, err
-	}
-	return robots.FindGroup(common.HarvestAgent), nil
+	return robotstxt.FromResponse(resp)
 }
 
+// Get the JSON-LD from every <script type="application/ld+json"> in an html document,
+// including those in the <body>. A single block is returned as is; multiple blocks are
+// merged into one document with an @graph so none of them are lost
 func GetJsonLDFromHTML(data []byte) (string, error) {
 	document, err := html.Parse(bytes.NewReader(data))
 	if err != nil {
 		return "", err
 	}
-	head := findHead(document)
 
-	scripts := getScriptTags(head)
-	for _, s := range scripts {
-		for _, attr := range s.Attr {
-			// We get the first one, since there should only be one
-			if attr.Key == "type" && strings.Contains(attr.Val, "application/ld+json") {
-				return s.FirstChild.Data, nil
-			}
+	blocks := []string{}
+	for _, s := range getScriptTags(document) {
+		if isJsonLdScript(s) {
+			blocks = append(blocks, scriptText(s))
+		}
+	}
+	if len(blocks) == 0 {
+		return "", fmt.Errorf("no JSON-LD found in document")
+	}
+
+	parsedBlocks := make([]any, 0, len(blocks))
+	for i, block := range blocks {
+		trimmed := strings.TrimSpace(block)
+		if trimmed == "" {
+			return "", fmt.Errorf("JSON-LD block %d of %d in the document is empty", i+1, len(blocks))
+		}
+		var parsed any
+		if err := json.Unmarshal([]byte(trimmed), &parsed); err != nil {
+			return "", fmt.Errorf("JSON-LD block %d of %d in the document is not valid JSON: %w", i+1, len(blocks), err)
+		}
+		if isEmptyJsonLd(parsed) {
+			return "", fmt.Errorf("JSON-LD block %d of %d in the document has no content", i+1, len(blocks))
 		}
+		parsedBlocks = append(parsedBlocks, parsed)
+	}
+
+	if len(blocks) == 1 {
+		return blocks[0], nil
 	}
 
-	return "", fmt.Errorf("no JSON-LD found in document")
+	merged, err := json.Marshal(map[string]any{"@graph": mergeJsonLdBlocks(parsedBlocks)})
+	if err != nil {
+		return "", err
+	}
+	return string(merged), nil
 }
 
-// Recursively search for the <head> element
-func findHead(n *html.Node) *html.Node {
-	if n.Type == html.ElementNode && n.Data == "head" {
-		return n
+// Return true if the script tag is labeled as JSON-LD
+func isJsonLdScript(s *html.Node) bool {
+	for _, attr := range s.Attr {
+		if attr.Key == "type" && strings.Contains(attr.Val, "application/ld+json") {
+			return true
+		}
 	}
-	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		if result := findHead(c); result != nil {
-			return result
+	return false
+}
+
+// Get the text within a script tag; a script with no content has no children
+func scriptText(s *html.Node) string {
+	var text strings.Builder
+	for c := s.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			text.WriteString(c.Data)
 		}
 	}
-	return nil
+	return text.String()
+}
+
+// Return true if a parsed JSON-LD block is an empty object or array
+func isEmptyJsonLd(parsed any) bool {
+	switch v := parsed.(type) {
+	case map[string]any:
+		return len(v) == 0
+	case []any:
+		return len(v) == 0
+	default:
+		// a bare string or number is not JSON-LD
+		return true
+	}
+}
+
+// Flatten the JSON-LD blocks into the nodes of a single @graph. Each node keeps the
+// @context of the block it came from since blocks often use different contexts
+func mergeJsonLdBlocks(blocks []any) []any {
+	nodes := []any{}
+	var addNode func(node any, context any)
+	addNode = func(node any, context any) {
+		switch v := node.(type) {
+		case []any:
+			for _, item := range v {
+				addNode(item, context)
+			}
+		case map[string]any:
+			blockContext, hasContext := v["@context"]
+			if hasContext {
+				context = blockContext
+			}
+			// a block that only wraps a graph is unwrapped so
+			// its nodes don't end up inside a named graph
+			graph, hasGraph := v["@graph"]
+			otherKeys := len(v) - 1
+			if hasContext {
+				otherKeys--
+			}
+			if hasGraph && otherKeys == 0 {
+				addNode(graph, context)
+				return
+			}
+			if !hasContext && context != nil {
+				v["@context"] = context
+			}
+			nodes = append(nodes, v)
+		}
+	}
+	for _, block := range blocks {
+		addNode(block, nil)
+	}
+	return nodes
 }
 
 // Collect all <script> nodes under the given node
@@ -4,10 +4,14 @@
 package crawl
 
 import (
+	"net/http"
+	"net/url"
 	"os"
 	"testing"
 
 	"github.com/internetofwater/nabu/internal/common"
+	"github.com/internetofwater/nabu/internal/crawl/url_info"
+	"github.com/internetofwater/nabu/pkg"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -80,7 +84,9 @@ func TestRobots(t *testing.T) {
 			require.Error(t, err)
 		} else {
 			require.NoError(t, err)
-			allowed := robotstxt.Test(common.HarvestAgent)
+			parsed, err := url.Parse(tc.url)
+			require.NoError(t, err)
+			allowed := robotstxt.TestAgent(parsed.RequestURI(), common.HarvestAgent)
 			assert.Equal(t, tc.allowsCrawling, allowed)
 		}
 	}
@@ -129,6 +135,59 @@ func TestGetJsonLdFromHTML(t *testing.T) {
 	})
 }
 
+func TestGetJsonLdFromHTMLWithMultipleBlocks(t *testing.T) {
+	t.Run("jsonld in the body", func(t *testing.T) {
+		jsonld, err := GetJsonLDFromHTML([]byte(`<html><head></head><body>
+			<script type="application/ld+json">{"@id": "https://example.com/1", "name": "in the body"}</script>
+		</body></html>`))
+		require.NoError(t, err)
+		require.JSONEq(t, `{"@id": "https://example.com/1", "name": "in the body"}`, jsonld)
+	})
+
+	t.Run("blocks are merged into one graph", func(t *testing.T) {
+		jsonld, err := GetJsonLDFromHTML([]byte(`<html><head>
+			<script type="application/ld+json">{"@context": "https://schema.org/", "@id": "https://example.com/place", "@type": "Place"}</script>
+		</head><body>
+			<script type="application/ld+json">{"@context": "https://schema.org/", "@graph": [{"@id": "https://example.com/dataset", "@type": "Dataset"}]}</script>
+			<script type="application/ld+json">[{"@id": "https://example.com/other"}]</script>
+		</body></html>`))
+		require.NoError(t, err)
+		require.JSONEq(t, `{"@graph": [
+			{"@context": "https://schema.org/", "@id": "https://example.com/place", "@type": "Place"},
+			{"@context": "https://schema.org/", "@id": "https://example.com/dataset", "@type": "Dataset"},
+			{"@id": "https://example.com/other"}
+		]}`, jsonld)
+	})
+
+	t.Run("script without content", func(t *testing.T) {
+		_, err := GetJsonLDFromHTML([]byte(`<html><head><script type="application/ld+json"></script></head></html>`))
+		require.ErrorContains(t, err, "block 1 of 1 in the document is empty")
+	})
+
+	t.Run("empty object", func(t *testing.T) {
+		_, err := GetJsonLDFromHTML([]byte(`<html><head>
+			<script type="application/ld+json">{"@id": "https://example.com/1"}</script>
+			<script type="application/ld+json">{}</script>
+		</head></html>`))
+		require.ErrorContains(t, err, "block 2 of 2 in the document has no content")
+	})
+
+	t.Run("invalid json", func(t *testing.T) {
+		_, err := GetJsonLDFromHTML([]byte(`<html><body><script type="application/ld+json">{"@id": </script></body></html>`))
+		require.ErrorContains(t, err, "is not valid JSON")
+	})
+
+	t.Run("invalid blocks are reported as url crawl errors", func(t *testing.T) {
+		resp := &http.Response{StatusCode: 200, Header: http.Header{"Content-Type": []string{"text/html"}}}
+		url := url_info.NewUrlFromString("https://example.com/1")
+		_, err := getJSONLD(resp, url, []byte(`<html><body><script type="application/ld+json"> </script></body></html>`))
+		var urlErr pkg.UrlCrawlError
+		require.ErrorAs(t, err, &urlErr)
+		require.Equal(t, url.Loc, urlErr.Url)
+		require.Contains(t, urlErr.Message, "empty")
+	})
+}
+
 func TestSitemapStatusTracker(t *testing.T) {
 
 	tracker := NewSitemapStatusTracker(2)
@@ -0,0 +1,78 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/internetofwater/nabu/internal/crawl/storage"
+	"github.com/internetofwater/nabu/internal/crawl/url_info"
+	"github.com/internetofwater/nabu/pkg"
+)
+
+// A record of the lastmod values seen during the last successful
+// harvest of a sitemap. This allows later harvests to skip urls,
+// or entire sitemaps, that have not changed upstream
+type lastModManifest struct {
+	SitemapID string
+	// the lastmod of the sitemap in the sitemap index
+	// when the sitemap was last successfully harvested
+	SitemapLastMod string
+	// map of each url in the sitemap to its lastmod when it was last
+	// successfully harvested; urls without a lastmod are not included
+	UrlLastMods map[string]string
+}
+
+// The path in storage where the lastmod manifest for a sitemap is stored
+func lastModManifestPath(sitemapId string) string {
+	return fmt.Sprintf("manifests/%s.json", sitemapId)
+}
+
+// Read the lastmod manifest for a sitemap from storage; if no
+// manifest exists an empty one is returned so every url is harvested
+func loadLastModManifest(storageDestination storage.CrawlStorage, sitemapId string) (lastModManifest, error) {
+	manifest := lastModManifest{SitemapID: sitemapId}
+	path := lastModManifestPath(sitemapId)
+	if _, err := readJsonFromStorage(storageDestination, path, &manifest); err != nil {
+		return lastModManifest{}, err
+	}
+	if manifest.SitemapID != sitemapId {
+		return lastModManifest{}, fmt.Errorf("lastmod manifest at %s is for sitemap %s, not %s", path, manifest.SitemapID, sitemapId)
+	}
+	if manifest.UrlLastMods == nil {
+		manifest.UrlLastMods = make(map[string]string)
+	}
+	return manifest, nil
+}
+
+// Write the lastmod manifest to storage
+func (m lastModManifest) store(storageDestination storage.CrawlStorage) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return storageDestination.StoreWithoutServersideHash(lastModManifestPath(m.SitemapID), bytes.NewReader(data))
+}
+
+// Return true if the lastmod of the sitemap in the sitemap index
+// has not advanced since the sitemap was last successfully harvested
+func sitemapUnchangedSinceLastHarvest(storageDestination storage.CrawlStorage, metadata SitemapMetadata) (bool, error) {
+	manifest, err := loadLastModManifest(storageDestination, metadata.SitemapID)
+	if err != nil {
+		return false, err
+	}
+	return url_info.LastModUnchanged(manifest.SitemapLastMod, metadata.LastMod), nil
+}
+
+// The stats for a sitemap that was skipped entirely since it was unchanged
+func unchangedSitemapStats(metadata SitemapMetadata) pkg.SitemapCrawlStats {
+	return pkg.SitemapCrawlStats{
+		SitemapSourceLink:  metadata.Loc,
+		SitemapName:        metadata.SitemapID,
+		SitemapDescription: metadata.DatasetDescription,
+		SitemapUnchanged:   true,
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	hashchecks "github.com/internetofwater/nabu/internal/crawl/hash_checks"
+	"github.com/internetofwater/nabu/internal/crawl/storage"
+	"github.com/internetofwater/nabu/internal/crawl/url_info"
+	"github.com/internetofwater/nabu/internal/opentelemetry"
+	"github.com/internetofwater/nabu/pkg"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// Work out what harvesting the sitemap would do without storing or removing anything.
+// This checks robots.txt and the hash of each url the same way a harvest would, but
+// urls that would get a conditional request are planned as fetches since only the
+// server can say whether they have changed
+func (s *Sitemap) Plan(ctx context.Context, config *SitemapHarvestConfig) (pkg.SitemapHarvestPlan, error) {
+	if err := s.ensureValid(config.workers); err != nil {
+		return pkg.SitemapHarvestPlan{}, err
+	}
+
+	plan := pkg.SitemapHarvestPlan{
+		SitemapName:            s.metadata.SitemapID,
+		SitemapSourceLink:      s.metadata.Loc,
+		SitesInSitemap:         len(s.URL),
+		BulkSitemap:            s.metadata.IsBulkSitemap(),
+		UrlsToFetch:            []string{},
+		UrlsUnchanged:          []string{},
+		UrlsDisallowedByRobots: []string{},
+		HashCheckFailures:      []pkg.UrlCrawlError{},
+		FilesToCleanup:         []string{},
+	}
+	if plan.BulkSitemap {
+		return plan, nil
+	}
+
+	ctx, span := opentelemetry.SubSpanFromCtxWithName(ctx, fmt.Sprintf("sitemap_plan_%s", s.metadata.SitemapID))
+	defer span.End()
+
+	noPreviousData, err := s.storageDestination.IsEmptyDir("summoned/" + s.metadata.SitemapID)
+	if err != nil {
+		return pkg.SitemapHarvestPlan{}, err
+	}
+	config.checkExistenceBeforeCrawl.Store(!noPreviousData)
+
+	alreadyHarvested := make(map[string]struct{})
+	// a sample never resumes from a checkpoint so the plan of one doesn't either
+	if config.resumeFromCheckpoint && !s.sampled {
+		previous, found, err := loadCheckpoint(s.storageDestination, s.metadata.SitemapID)
+		if err != nil {
+			return pkg.SitemapHarvestPlan{}, err
+		}
+		if found {
+			for url := range previous.CompletedUrls {
+				alreadyHarvested[url] = struct{}{}
+			}
+		}
+	}
+
+	manifest := lastModManifest{}
+	if config.skipUnchangedLastMod {
+		manifest, err = loadLastModManifest(s.storageDestination, s.metadata.SitemapID)
+		if err != nil {
+			return pkg.SitemapHarvestPlan{}, err
+		}
+	}
+
+	sitesInSitemap := make(storage.Set)
+	// the jsonld of urls disallowed by a rule in robots.txt is cleaned up the same as in a harvest
+	disallowedByRule := []string{}
+	planMu := sync.Mutex{}
+
+	group, ctx := errgroup.WithContext(ctx)
+	group.SetLimit(config.workers)
+
+	for _, url := range s.URL {
+		path, err := urlToStoragePath(s.metadata.SitemapID, url)
+		if err != nil {
+			return pkg.SitemapHarvestPlan{}, err
+		}
+		sitesInSitemap.Add(path)
+
+		_, resumed := alreadyHarvested[url.Loc]
+		if resumed || (config.skipUnchangedLastMod && url_info.LastModUnchanged(manifest.UrlLastMods[url.Loc], url.LastMod)) {
+			// the workers started for earlier urls append to the same list
+			planMu.Lock()
+			plan.UrlsUnchanged = append(plan.UrlsUnchanged, url.Loc)
+			planMu.Unlock()
+			continue
+		}
+
+		group.Go(func() error {
+			unchanged, disallowedReason, hashErr, err := planOnePID(ctx, s.metadata.SitemapID, url, config)
+			if err != nil {
+				return err
+			}
+			planMu.Lock()
+			defer planMu.Unlock()
+			switch {
+			case disallowedReason != "":
+				plan.UrlsDisallowedByRobots = append(plan.UrlsDisallowedByRobots, url.Loc)
+				if disallowedReason == disallowedByRobotsRule {
+					disallowedByRule = append(disallowedByRule, path)
+				}
+			case !hashErr.IsNil():
+				plan.HashCheckFailures = append(plan.HashCheckFailures, hashErr)
+			case unchanged:
+				plan.UrlsUnchanged = append(plan.UrlsUnchanged, url.Loc)
+			default:
+				plan.UrlsToFetch = append(plan.UrlsToFetch, url.Loc)
+			}
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return pkg.SitemapHarvestPlan{}, err
+	}
+
+	// the workers finish in any order so sort the urls to make plans easy to compare
+	slices.Sort(plan.UrlsToFetch)
+	slices.Sort(plan.UrlsUnchanged)
+	slices.Sort(plan.UrlsDisallowedByRobots)
+	slices.SortFunc(plan.HashCheckFailures, func(a, b pkg.UrlCrawlError) int {
+		return strings.Compare(a.Url, b.Url)
+	})
+
+	if config.cleanupOutdatedJsonld && !noPreviousData {
+		for _, path := range disallowedByRule {
+			delete(sitesInSitemap, path)
+		}
+		plan.FilesToCleanup, err = storage.FilesToCleanup("summoned/"+s.metadata.SitemapID, sitesInSitemap, s.storageDestination)
+		if err != nil {
+			return pkg.SitemapHarvestPlan{}, err
+		}
+	}
+
+	log.Info(plan.Summary())
+	return plan, nil
+}
+
+// Check whether a single pid would be skipped as unchanged or disallowed by robots.txt;
+// disallowedReason is empty unless robots.txt disallows it. A hash check that fails in
+// a way that would be a crawl failure is returned as the UrlCrawlError
+func planOnePID(ctx context.Context, sitemapId string, url url_info.URL, config *SitemapHarvestConfig) (unchanged bool, disallowedReason string, hashErr pkg.UrlCrawlError, err error) {
+	if config.robots != nil {
+		allowed, reason, err := config.robots.Allowed(url.Loc)
+		if err != nil {
+			return false, "", hashErr, fmt.Errorf("failed to check robots.txt for %s: %w", url.Loc, err)
+		}
+		if !allowed {
+			return false, reason, hashErr, nil
+		}
+	}
+
+	if !config.checkExistenceBeforeCrawl.Load() {
+		return false, "", hashErr, nil
+	}
+	if err := waitForRateLimit(ctx, config, url, &harvestResult{}); err != nil {
+		return false, "", hashErr, err
+	}
+	hashChecker := hashchecks.NewHashChecker(config.httpClient, config.storageDestination)
+	result, err := hashChecker.CheckIfAlreadyExists(url, sitemapId)
+	if errors.As(err, &hashErr) {
+		return false, "", hashErr, nil
+	}
+	if err != nil {
+		return false, "", hashErr, fmt.Errorf("got fatal error when checking if %s already exists: %w", url.Loc, err)
+	}
+	if !result.ServerProvidedHash && config.checkExistenceBeforeCrawl.Load() {
+		// a harvest stops checking hashes as soon as the server doesn't provide one
+		config.checkExistenceBeforeCrawl.Store(false)
+		log.Warnf("Server didn't provide a hash on %s. Skipping hash checks going forward for planned sites", url.Loc)
+	}
+	return result.FileAlreadyExists, "", hashErr, nil
+}
+
+// Work out what harvesting every sitemap in the index would do without storing
+// or removing anything; the same options are used as for HarvestSitemaps
+func (i SitemapIndex) PlanSitemaps(ctx context.Context, client *http.Client) (pkg.HarvestPlan, error) {
+	if i.concurrentSitemaps < 1 {
+		return nil, fmt.Errorf("concurrent sitemap limit is set less than 1")
+	}
+	if i.sitemapWorkers < 1 {
+		return nil, fmt.Errorf("sitemap workers limit is set less than 1")
+	}
+
+	var group errgroup.Group
+	group.SetLimit(i.concurrentSitemaps)
+
+	robotsCache := i.newRobotsCache(client)
+
+	i, err := i.resolveNestedIndexes(ctx, client, robotsCache, i.sources)
+	if err != nil {
+		return nil, err
+	}
+	selected, err := i.SelectedSitemaps()
+	if err != nil {
+		return nil, err
+	}
+	plans := make([]pkg.SitemapHarvestPlan, len(selected))
+
+	for index, sitemap := range selected {
+		group.Go(func() error {
+			if i.incrementalHarvest {
+				unchanged, err := sitemapUnchangedSinceLastHarvest(i.storageDestination, sitemap)
+				if err != nil {
+					return err
+				}
+				if unchanged {
+					plans[index] = pkg.SitemapHarvestPlan{
+						SitemapName:       sitemap.SitemapID,
+						SitemapSourceLink: sitemap.Loc,
+						SitemapUnchanged:  true,
+					}
+					return nil
+				}
+			}
+
+			parsed, err := NewSitemap(ctx, client, i.sitemapWorkers, i.storageDestination, sitemap)
+			if err != nil {
+				return err
+			}
+			i.sample(parsed)
+			// shacl validation is skipped since nothing is downloaded
+			config, err := i.newSitemapHarvestConfig(client, parsed, nil, robotsCache)
+			if err != nil {
+				return err
+			}
+			plan, err := parsed.Plan(ctx, &config)
+			if err != nil {
+				return err
+			}
+			plans[index] = plan
+			return nil
+		})
+	}
+
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+
+	// each plan was written to the index of its sitemap so they are in the same order as the sitemap index
+	return pkg.HarvestPlan(plans), nil
+}
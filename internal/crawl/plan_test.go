@@ -0,0 +1,187 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/internetofwater/nabu/internal/crawl/storage"
+	"github.com/internetofwater/nabu/internal/crawl/url_info"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanHarvest(t *testing.T) {
+	unchangedDoc := []byte(`{"@id": "https://example.com/unchanged"}`)
+	unchangedDigest := sha256.Sum256(unchangedDoc)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			_, _ = w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+		case "/sitemap.xml":
+			_, _ = fmt.Fprintf(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+				<url><loc>%s/unchanged</loc></url>
+				<url><loc>%s/changed</loc></url>
+				<url><loc>%s/private/1</loc></url>
+			</urlset>`, server.URL, server.URL, server.URL)
+		case "/unchanged":
+			w.Header().Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(unchangedDigest[:])+":")
+		case "/changed":
+			digest := sha256.Sum256([]byte("something new"))
+			w.Header().Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(digest[:])+":")
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+		if r.Method != http.MethodHead && r.URL.Path != "/robots.txt" && r.URL.Path != "/sitemap.xml" {
+			t.Errorf("a dry run should only send HEAD requests but got %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+	require.NoError(t, err)
+	unchangedUrl := url_info.NewUrlFromString(server.URL + "/unchanged")
+	unchangedPath, err := urlToStoragePath("test", unchangedUrl)
+	require.NoError(t, err)
+	require.NoError(t, crawlStorage.StoreWithHash(unchangedPath, bytes.NewReader(unchangedDoc), len(unchangedDoc)))
+	outdatedPath := "summoned/test/outdated.jsonld"
+	require.NoError(t, crawlStorage.StoreWithoutServersideHash(outdatedPath, bytes.NewReader([]byte("{}"))))
+
+	index := SitemapIndex{Sitemaps: []SitemapMetadata{{SitemapID: "test", Loc: server.URL + "/sitemap.xml"}}}.
+		WithStorageDestination(crawlStorage).
+		WithConcurrencyConfig(1, 2).
+		WithOutdatedJsonldCleanup(true)
+
+	plan, err := index.PlanSitemaps(context.Background(), server.Client())
+	require.NoError(t, err)
+	require.Len(t, plan, 1)
+	require.Equal(t, 3, plan[0].SitesInSitemap)
+	require.Equal(t, []string{server.URL + "/changed"}, plan[0].UrlsToFetch)
+	require.Equal(t, []string{server.URL + "/unchanged"}, plan[0].UrlsUnchanged)
+	require.Equal(t, []string{server.URL + "/private/1"}, plan[0].UrlsDisallowedByRobots)
+	require.Len(t, plan[0].FilesToCleanup, 1)
+	require.Contains(t, plan[0].FilesToCleanup[0], outdatedPath)
+	require.Contains(t, plan.Summary(), "1 to fetch, 1 unchanged, 1 disallowed by robots.txt")
+
+	// nothing should have been stored or removed
+	exists, err := crawlStorage.Exists(outdatedPath)
+	require.NoError(t, err)
+	require.True(t, exists)
+	metadataExists, err := crawlStorage.Exists("metadata/sitemaps/test.json")
+	require.NoError(t, err)
+	require.False(t, metadataExists)
+	changedUrl := url_info.NewUrlFromString(server.URL + "/changed")
+	changedPath, err := urlToStoragePath("test", changedUrl)
+	require.NoError(t, err)
+	changedExists, err := crawlStorage.Exists(changedPath)
+	require.NoError(t, err)
+	require.False(t, changedExists)
+
+	t.Run("unknown source", func(t *testing.T) {
+		_, err := index.WithSpecifiedSourceFilter("does_not_exist").PlanSitemaps(context.Background(), server.Client())
+		require.ErrorContains(t, err, "no sitemap found with id does_not_exist")
+	})
+}
+
+// Run with -race; resumed urls are recorded by the main loop while the workers record the rest
+func TestPlanResumedSitemapWithManyWorkers(t *testing.T) {
+	const urls = 400
+	doc := []byte(`{"@id": "https://example.com/feature"}`)
+	digest := sha256.Sum256(doc)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			_, _ = w.Write([]byte("User-agent: *\nAllow: /\n"))
+		case "/sitemap.xml":
+			_, _ = w.Write([]byte(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`))
+			for i := range urls {
+				_, _ = fmt.Fprintf(w, "<url><loc>%s/feature/%d</loc></url>", server.URL, i)
+			}
+			_, _ = w.Write([]byte(`</urlset>`))
+		default:
+			w.Header().Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(digest[:])+":")
+		}
+	}))
+	defer server.Close()
+
+	crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+	require.NoError(t, err)
+	// every other url was harvested before the checkpoint and the rest are unchanged in storage
+	checkpoint := newCheckpointTracker("test", 0, crawlStorage)
+	for i := range urls {
+		url := url_info.NewUrlFromString(fmt.Sprintf("%s/feature/%d", server.URL, i))
+		path, err := urlToStoragePath("test", url)
+		require.NoError(t, err)
+		if i%2 == 0 {
+			checkpoint.restore(url.Loc, checkpointEntry{PathInStorage: path})
+		} else {
+			require.NoError(t, crawlStorage.StoreWithHash(path, bytes.NewReader(doc), len(doc)))
+		}
+	}
+	require.NoError(t, checkpoint.write(nil, 0))
+
+	index := SitemapIndex{Sitemaps: []SitemapMetadata{{SitemapID: "test", Loc: server.URL + "/sitemap.xml"}}}.
+		WithStorageDestination(crawlStorage).
+		WithConcurrencyConfig(1, 8).
+		WithCheckpointConfig(0, true)
+
+	plan, err := index.PlanSitemaps(context.Background(), server.Client())
+	require.NoError(t, err)
+	require.Len(t, plan, 1)
+	require.Len(t, plan[0].UrlsUnchanged, urls)
+	require.Empty(t, plan[0].UrlsToFetch)
+}
+
+func TestPlanSampleDoesNotResume(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			_, _ = w.Write([]byte("User-agent: *\nAllow: /\n"))
+		case "/sitemap.xml":
+			_, _ = fmt.Fprintf(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+				<url><loc>%s/feature/1</loc></url>
+				<url><loc>%s/feature/2</loc></url>
+			</urlset>`, server.URL, server.URL)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+	require.NoError(t, err)
+	checkpoint := newCheckpointTracker("test", 0, crawlStorage)
+	for _, loc := range []string{server.URL + "/feature/1", server.URL + "/feature/2"} {
+		path, err := urlToStoragePath("test", url_info.NewUrlFromString(loc))
+		require.NoError(t, err)
+		checkpoint.restore(loc, checkpointEntry{PathInStorage: path})
+	}
+	require.NoError(t, checkpoint.write(nil, 0))
+
+	index := SitemapIndex{Sitemaps: []SitemapMetadata{{SitemapID: "test", Loc: server.URL + "/sitemap.xml"}}}.
+		WithStorageDestination(crawlStorage).
+		WithConcurrencyConfig(1, 1).
+		WithCheckpointConfig(0, true)
+
+	plan, err := index.PlanSitemaps(context.Background(), server.Client())
+	require.NoError(t, err)
+	require.Len(t, plan[0].UrlsUnchanged, 2, "a full harvest resumes from the checkpoint")
+
+	plan, err = index.WithSampling(2, SampleRandom, 0).PlanSitemaps(context.Background(), server.Client())
+	require.NoError(t, err)
+	require.Empty(t, plan[0].UrlsUnchanged, "a sample ignores the checkpoint like a sampled harvest")
+	require.Len(t, plan[0].UrlsToFetch, 2)
+}
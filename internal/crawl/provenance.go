@@ -0,0 +1,133 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/internetofwater/nabu/internal/common"
+	"github.com/internetofwater/nabu/internal/crawl/storage"
+	"github.com/internetofwater/nabu/internal/crawl/url_info"
+	"github.com/internetofwater/nabu/pkg"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	provNamespace  = "http://www.w3.org/ns/prov#"
+	rdfType        = "http://www.w3.org/1999/02/22-rdf-syntax-ns#type"
+	xsdNamespace   = "http://www.w3.org/2001/XMLSchema#"
+	nabuNamespace  = "https://geoconnex.us/nabu/"
+	nabuSoftwareId = "https://github.com/internetofwater/nabu"
+)
+
+// The provenance of a single harvested document
+type harvestProvenance struct {
+	// the id of the sitemap the url is in
+	sitemapId string
+	// the url in the sitemap
+	url url_info.URL
+	// the url the document was fetched from after following redirects
+	finalUrl string
+	// the http status of the response; 0 if the page was rendered in headless chrome
+	statusCode int
+	// when the request for the document was sent
+	fetchedAt time.Time
+	// the path of the jsonld in storage
+	pathInStorage string
+	// the jsonld that was stored
+	jsonld []byte
+	// the outcome of shacl validation of the jsonld
+	shaclStatus pkg.ShaclStatus
+}
+
+// Given the sitemap identifier and the url return the path to store its provenance;
+// this is under prov/ so that it can be released as the <sitemap>_prov.nq graph
+func urlToProvenancePath(sitemapId string, url url_info.URL) (string, error) {
+	if url.Base64Loc == "" {
+		return "", fmt.Errorf("no base64 loc for url %s", url.Loc)
+	}
+	return fmt.Sprintf("prov/%s/%s.nq", sitemapId, url.Base64Loc), nil
+}
+
+// Serialize the provenance as a PROV-O activity in N-Triples. The entity
+// generated by the activity is the urn of the named graph the document is
+// released into, so every triple in a release can be traced to its harvest
+func (p harvestProvenance) toNTriples() (string, error) {
+	entity, err := common.MakeURN(p.pathInStorage)
+	if err != nil {
+		return "", err
+	}
+	activity := entity + ":harvest"
+
+	var triples strings.Builder
+	add := func(subject, predicate, object string) {
+		fmt.Fprintf(&triples, "<%s> <%s> %s .\n", subject, predicate, object)
+	}
+	iri := func(value string) string { return "<" + value + ">" }
+
+	add(activity, rdfType, iri(provNamespace+"Activity"))
+	add(activity, provNamespace+"startedAtTime", common.NTriplesLiteral(p.fetchedAt.UTC().Format(time.RFC3339Nano))+"^^"+iri(xsdNamespace+"dateTime"))
+	add(activity, provNamespace+"used", iri(p.url.Loc))
+	add(activity, provNamespace+"wasAssociatedWith", iri(nabuSoftwareId))
+	add(activity, nabuNamespace+"finalUrl", iri(p.finalUrl))
+	if p.statusCode != 0 {
+		add(activity, nabuNamespace+"httpStatus", common.NTriplesLiteral(strconv.Itoa(p.statusCode))+"^^"+iri(xsdNamespace+"integer"))
+	}
+	add(activity, nabuNamespace+"sitemapId", common.NTriplesLiteral(p.sitemapId))
+	add(activity, nabuNamespace+"nabuVersion", common.NTriplesLiteral(common.NabuVersion()))
+	add(activity, nabuNamespace+"shaclStatus", common.NTriplesLiteral(string(p.shaclStatus)))
+
+	add(nabuSoftwareId, rdfType, iri(provNamespace+"SoftwareAgent"))
+
+	digest := sha256.Sum256(p.jsonld)
+	add(entity, rdfType, iri(provNamespace+"Entity"))
+	add(entity, provNamespace+"wasGeneratedBy", iri(activity))
+	add(entity, provNamespace+"wasDerivedFrom", iri(p.finalUrl))
+	add(entity, nabuNamespace+"sha256", common.NTriplesLiteral(hex.EncodeToString(digest[:])))
+
+	return triples.String(), nil
+}
+
+// Store the provenance of a harvested document alongside it
+func storeProvenance(storageDestination storage.CrawlStorage, provenance harvestProvenance) error {
+	path, err := urlToProvenancePath(provenance.sitemapId, provenance.url)
+	if err != nil {
+		return err
+	}
+	triples, err := provenance.toNTriples()
+	if err != nil {
+		return err
+	}
+	return storageDestination.StoreWithoutServersideHash(path, strings.NewReader(triples))
+}
+
+// Remove the provenance of documents that are no longer in the sitemap
+// so that the provenance graph doesn't describe documents that were cleaned up
+func cleanupOutdatedProvenance(sitemapId string, provenanceInSitemap storage.Set, storageDestination storage.CrawlStorage) {
+	cleanupOutdatedFilesAlongsideJsonld("prov/"+sitemapId, provenanceInSitemap, storageDestination)
+}
+
+// Remove the files in a prefix that describe documents which are no longer in the sitemap, such
+// as their provenance or cache validators; failures are only logged since the jsonld was already cleaned up
+func cleanupOutdatedFilesAlongsideJsonld(prefix string, filesInSitemap storage.Set, storageDestination storage.CrawlStorage) {
+	empty, err := storageDestination.IsEmptyDir(prefix)
+	if err != nil {
+		log.Errorf("failed to check for files in %s: %v", prefix, err)
+		return
+	}
+	if empty {
+		return
+	}
+	cleanedUp, err := storage.CleanupFiles(prefix, filesInSitemap, storageDestination)
+	if err != nil {
+		log.Errorf("failed to clean up outdated files in %s: %v", prefix, err)
+		return
+	}
+	log.Infof("Cleaned up %d outdated files in %s", len(cleanedUp), prefix)
+}
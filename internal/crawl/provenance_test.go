@@ -0,0 +1,87 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/internetofwater/nabu/internal/common"
+	"github.com/internetofwater/nabu/internal/crawl/storage"
+	"github.com/internetofwater/nabu/internal/crawl/url_info"
+	"github.com/piprate/json-gold/ld"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvenance(t *testing.T) {
+	const jsonld = `{"@id": "https://example.com/feature"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		case "/new":
+			w.Header().Set("Content-Type", "application/ld+json")
+			_, _ = w.Write([]byte(jsonld))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+	require.NoError(t, err)
+	check := atomic.Bool{}
+	config := &SitemapHarvestConfig{
+		httpClient:                server.Client(),
+		storageDestination:        crawlStorage,
+		checkExistenceBeforeCrawl: &check,
+		recordProvenance:          true,
+	}
+	url := url_info.NewUrlFromString(server.URL + "/old")
+
+	result, err := harvestOnePID(context.Background(), "test_sitemap", url, config)
+	require.NoError(t, err)
+	require.True(t, result.nonFatalError.IsNil())
+
+	provenancePath, err := urlToProvenancePath("test_sitemap", url)
+	require.NoError(t, err)
+	reader, err := crawlStorage.Get(provenancePath)
+	require.NoError(t, err)
+	defer func() { _ = reader.Close() }()
+	triples, err := io.ReadAll(reader)
+	require.NoError(t, err)
+
+	// the provenance must be valid rdf so it can be released
+	_, err = ld.ParseNQuads(string(triples))
+	require.NoError(t, err)
+
+	entity, err := common.MakeURN(result.pathInStorage)
+	require.NoError(t, err)
+	digest := sha256.Sum256([]byte(jsonld))
+	require.Contains(t, string(triples), "<"+entity+":harvest> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://www.w3.org/ns/prov#Activity> .")
+	require.Contains(t, string(triples), "<"+entity+":harvest> <http://www.w3.org/ns/prov#used> <"+server.URL+"/old> .")
+	require.Contains(t, string(triples), "<"+entity+":harvest> <https://geoconnex.us/nabu/finalUrl> <"+server.URL+"/new> .")
+	require.Contains(t, string(triples), `<https://geoconnex.us/nabu/httpStatus> "200"^^<http://www.w3.org/2001/XMLSchema#integer> .`)
+	require.Contains(t, string(triples), `<https://geoconnex.us/nabu/sitemapId> "test_sitemap" .`)
+	require.Contains(t, string(triples), `<https://geoconnex.us/nabu/shaclStatus> "skipped" .`)
+	require.Contains(t, string(triples), "<"+entity+"> <https://geoconnex.us/nabu/sha256> \""+hex.EncodeToString(digest[:])+"\" .")
+
+	t.Run("no provenance unless it is enabled", func(t *testing.T) {
+		config.recordProvenance = false
+		otherUrl := url_info.NewUrlFromString(server.URL + "/new")
+		_, err := harvestOnePID(context.Background(), "test_sitemap", otherUrl, config)
+		require.NoError(t, err)
+		otherPath, err := urlToProvenancePath("test_sitemap", otherUrl)
+		require.NoError(t, err)
+		exists, err := crawlStorage.Exists(otherPath)
+		require.NoError(t, err)
+		require.False(t, exists)
+	})
+}
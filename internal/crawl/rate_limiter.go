@@ -0,0 +1,166 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A token bucket that holds a single token and refills it once every
+// interval; i.e. at most one request is sent to the host per interval
+type hostLimiter struct {
+	mu sync.Mutex
+	// the minimum time between requests to the host
+	interval time.Duration
+	// the time at which the next token becomes available
+	next time.Time
+}
+
+// Block until a token is available or the context is cancelled
+// and return how long the caller had to wait
+func (h *hostLimiter) wait(ctx context.Context) (time.Duration, error) {
+	h.mu.Lock()
+	now := time.Now()
+	start := now
+	if h.next.After(now) {
+		start = h.next
+	}
+	// reserve the token before sleeping so concurrent callers queue up behind each other
+	h.next = start.Add(h.interval)
+	h.mu.Unlock()
+
+	delay := start.Sub(now)
+	if delay <= 0 {
+		return 0, nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		return time.Since(now), ctx.Err()
+	}
+}
+
+// A rate limiter shared across every worker and every sitemap in a harvest
+// so that the total request rate against a host respects its robots.txt Crawl-delay
+type HostRateLimiter struct {
+	mu sync.Mutex
+	// the limiter for each host
+	hosts map[string]*hostLimiter
+	// delays that were explicitly configured for a host
+	// these take precedence over the robots.txt Crawl-delay
+	overrides map[string]time.Duration
+	// the smallest delay allowed for a host regardless of its robots.txt;
+	// these are set by the crawl delay of sitemaps in the sitemap index
+	minimums map[string]time.Duration
+}
+
+// Create a new rate limiter; overrides is a map of host to the delay
+// between requests that should be used regardless of robots.txt
+func NewHostRateLimiter(overrides map[string]time.Duration) *HostRateLimiter {
+	normalized := make(map[string]time.Duration, len(overrides))
+	for host, delay := range overrides {
+		normalized[strings.ToLower(host)] = delay
+	}
+	return &HostRateLimiter{
+		hosts:     make(map[string]*hostLimiter),
+		overrides: normalized,
+		minimums:  make(map[string]time.Duration),
+	}
+}
+
+// Get the host of a url in the form used as a key for rate limiting
+func hostKey(rawUrl string) (string, error) {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Host == "" {
+		return "", fmt.Errorf("url %s has no host", rawUrl)
+	}
+	return strings.ToLower(parsed.Host), nil
+}
+
+// Get the limiter for a host, creating it if it doesn't exist
+func (l *HostRateLimiter) limiterFor(host string) *hostLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limiter, ok := l.hosts[host]
+	if !ok {
+		limiter = &hostLimiter{interval: l.overrides[host]}
+		l.hosts[host] = limiter
+	}
+	return limiter
+}
+
+// Set the delay between requests for the host of the url from its robots.txt
+// Crawl-delay. This has no effect if the host has a configured override
+func (l *HostRateLimiter) SetCrawlDelay(rawUrl string, delay time.Duration) error {
+	host, err := hostKey(rawUrl)
+	if err != nil {
+		return err
+	}
+	if _, overridden := l.overrides[host]; overridden {
+		return nil
+	}
+	l.mu.Lock()
+	delay = max(delay, l.minimums[host])
+	l.mu.Unlock()
+	limiter := l.limiterFor(host)
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if limiter.interval != delay {
+		log.Infof("Limiting requests to %s to one every %s based on robots.txt", host, delay)
+		limiter.interval = delay
+	}
+	return nil
+}
+
+// Make sure requests to the host of the url are at least delay apart, even if its robots.txt
+// allows them to be sent faster. Since a host may serve more than one sitemap, the largest
+// minimum set for it is kept. This has no effect if the host has a configured override
+func (l *HostRateLimiter) SetMinimumCrawlDelay(rawUrl string, delay time.Duration) error {
+	host, err := hostKey(rawUrl)
+	if err != nil {
+		return err
+	}
+	if _, overridden := l.overrides[host]; overridden {
+		return nil
+	}
+	l.mu.Lock()
+	if delay <= l.minimums[host] {
+		l.mu.Unlock()
+		return nil
+	}
+	l.minimums[host] = delay
+	l.mu.Unlock()
+
+	limiter := l.limiterFor(host)
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if limiter.interval < delay {
+		log.Infof("Limiting requests to %s to one every %s based on the sitemap index", host, delay)
+		limiter.interval = delay
+	}
+	return nil
+}
+
+// Block until a request can be sent to the host of the url
+// and return how long the caller had to wait
+func (l *HostRateLimiter) Wait(ctx context.Context, rawUrl string) (time.Duration, error) {
+	host, err := hostKey(rawUrl)
+	if err != nil {
+		return 0, err
+	}
+	return l.limiterFor(host).wait(ctx)
+}
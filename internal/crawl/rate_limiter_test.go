@@ -0,0 +1,145 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/internetofwater/nabu/internal/crawl/storage"
+	"github.com/internetofwater/nabu/internal/crawl/url_info"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostRateLimiter(t *testing.T) {
+	t.Run("crawl delay is shared across goroutines", func(t *testing.T) {
+		limiter := NewHostRateLimiter(nil)
+		require.NoError(t, limiter.SetCrawlDelay("https://example.com/sitemap.xml", 50*time.Millisecond))
+
+		start := time.Now()
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := limiter.Wait(context.Background(), "https://example.com/a")
+				require.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+		// the first request is immediate and the other three each wait one interval
+		require.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+	})
+
+	t.Run("hosts are limited independently", func(t *testing.T) {
+		limiter := NewHostRateLimiter(nil)
+		require.NoError(t, limiter.SetCrawlDelay("https://slow.example.com", time.Hour))
+
+		_, err := limiter.Wait(context.Background(), "https://slow.example.com/1")
+		require.NoError(t, err)
+		waited, err := limiter.Wait(context.Background(), "https://fast.example.com/1")
+		require.NoError(t, err)
+		require.Zero(t, waited)
+	})
+
+	t.Run("override takes precedence over robots.txt", func(t *testing.T) {
+		limiter := NewHostRateLimiter(map[string]time.Duration{"Example.com": 0})
+		require.NoError(t, limiter.SetCrawlDelay("https://example.com", time.Hour))
+
+		for range 3 {
+			waited, err := limiter.Wait(context.Background(), "https://example.com/a")
+			require.NoError(t, err)
+			require.Zero(t, waited)
+		}
+	})
+
+	t.Run("minimum from the sitemap index is kept over a shorter robots.txt delay", func(t *testing.T) {
+		limiter := NewHostRateLimiter(nil)
+		require.NoError(t, limiter.SetMinimumCrawlDelay("https://example.com/sitemap.xml", time.Hour))
+		require.NoError(t, limiter.SetMinimumCrawlDelay("https://example.com/other.xml", time.Millisecond))
+		require.NoError(t, limiter.SetCrawlDelay("https://example.com", 0))
+
+		_, err := limiter.Wait(context.Background(), "https://example.com/a")
+		require.NoError(t, err)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err = limiter.Wait(ctx, "https://example.com/b")
+		require.ErrorIs(t, err, context.DeadlineExceeded, "the largest minimum for the host should be used")
+	})
+
+	t.Run("override takes precedence over the sitemap index", func(t *testing.T) {
+		limiter := NewHostRateLimiter(map[string]time.Duration{"example.com": 0})
+		require.NoError(t, limiter.SetMinimumCrawlDelay("https://example.com", time.Hour))
+
+		for range 3 {
+			waited, err := limiter.Wait(context.Background(), "https://example.com/a")
+			require.NoError(t, err)
+			require.Zero(t, waited)
+		}
+	})
+
+	t.Run("wait time is reported", func(t *testing.T) {
+		limiter := NewHostRateLimiter(map[string]time.Duration{"example.com": 30 * time.Millisecond})
+		_, err := limiter.Wait(context.Background(), "https://example.com/a")
+		require.NoError(t, err)
+		waited, err := limiter.Wait(context.Background(), "https://example.com/b")
+		require.NoError(t, err)
+		require.Greater(t, waited, time.Duration(0))
+	})
+
+	t.Run("cancelled context stops waiting", func(t *testing.T) {
+		limiter := NewHostRateLimiter(map[string]time.Duration{"example.com": time.Hour})
+		_, err := limiter.Wait(context.Background(), "https://example.com/a")
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err = limiter.Wait(ctx, "https://example.com/b")
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("url without host is an error", func(t *testing.T) {
+		limiter := NewHostRateLimiter(nil)
+		_, err := limiter.Wait(context.Background(), "not a url")
+		require.Error(t, err)
+	})
+}
+
+func TestHashCheckAndFetchTakeOneRateLimitToken(t *testing.T) {
+	requests := atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(make([]byte, 32))+":")
+		w.Header().Set("Content-Type", "application/ld+json")
+		_, _ = w.Write([]byte(`{"@id": "https://example.com/1"}`))
+	}))
+	defer server.Close()
+
+	crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+	require.NoError(t, err)
+	limiter := NewHostRateLimiter(nil)
+	require.NoError(t, limiter.SetCrawlDelay(server.URL, time.Hour))
+	check := atomic.Bool{}
+	check.Store(true)
+	config := &SitemapHarvestConfig{
+		httpClient:                server.Client(),
+		storageDestination:        crawlStorage,
+		checkExistenceBeforeCrawl: &check,
+		rateLimiter:               limiter,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := harvestOnePID(ctx, "test", url_info.NewUrlFromString(server.URL+"/1"), config)
+	require.NoError(t, err, "the GET after the hash check should not wait for a second token")
+	require.True(t, result.serverHadHash)
+	require.Zero(t, result.rateLimitWait)
+	require.Equal(t, int32(2), requests.Load(), "both the HEAD and the GET should be sent")
+}
@@ -0,0 +1,99 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/internetofwater/nabu/internal/crawl/url_info"
+	"github.com/internetofwater/nabu/pkg"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// A url that failed with a transient error and is
+// attempted again once the rest of the sitemap is harvested
+type queuedRetry struct {
+	url url_info.URL
+	// the failure from the most recent attempt
+	lastFailure pkg.UrlCrawlError
+}
+
+// The urls in a sitemap that failed with a transient error; these are retried
+// after the main pass since the server may have recovered by then
+type retryQueue struct {
+	mu     sync.Mutex
+	queued []queuedRetry
+}
+
+func (q *retryQueue) add(url url_info.URL, failure pkg.UrlCrawlError) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.queued = append(q.queued, queuedRetry{url: url, lastFailure: failure})
+}
+
+// Remove and return every url in the queue
+func (q *retryQueue) drain() []queuedRetry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queued := q.queued
+	q.queued = nil
+	return queued
+}
+
+// Retries use a quarter of the workers of the main pass
+// so a struggling server isn't hit as hard the second time
+func retryWorkers(workers int) int {
+	return max(1, workers/4)
+}
+
+// Attempt every url in the queue again, in rounds, until the queue is empty or
+// maxRetries rounds have run. The backoff before each round doubles from the base.
+// harvest is called with the attempt number, counting the first attempt of the main
+// pass as 1, and is responsible for adding the url back to the queue if it should be retried again.
+// Urls that were not attempted due to an error are left in the queue so their last failure can be reported
+func (q *retryQueue) retry(ctx context.Context, workers int, maxRetries int, backoff time.Duration, harvest func(ctx context.Context, url url_info.URL, attempt int) error) error {
+	for round := 1; round <= maxRetries; round++ {
+		queued := q.drain()
+		if len(queued) == 0 {
+			return nil
+		}
+
+		delay := backoff << (round - 1)
+		log.Infof("Retrying %d urls that failed with a transient error in %s", len(queued), delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			// keep the urls so their last failure is still reported
+			for _, item := range queued {
+				q.add(item.url, item.lastFailure)
+			}
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		group, groupCtx := errgroup.WithContext(ctx)
+		group.SetLimit(retryWorkers(workers))
+		for _, item := range queued {
+			group.Go(func() error {
+				if groupCtx.Err() != nil {
+					q.add(item.url, item.lastFailure)
+					return groupCtx.Err()
+				}
+				if err := harvest(groupCtx, item.url, round+1); err != nil {
+					q.add(item.url, item.lastFailure)
+					return err
+				}
+				return nil
+			})
+		}
+		if err := group.Wait(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
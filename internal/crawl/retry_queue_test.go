@@ -0,0 +1,107 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/internetofwater/nabu/internal/crawl/storage"
+	"github.com/internetofwater/nabu/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+// Serve a sitemap with a url that recovers after failing once,
+// a url that is always unavailable, and a url that doesn't exist
+func newFlakyServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	flakyRequests := atomic.Int32{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			_, _ = w.Write([]byte("User-agent: *\nAllow: /\n"))
+		case "/sitemap.xml":
+			_, _ = fmt.Fprintf(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+				<url><loc>%s/flaky</loc></url>
+				<url><loc>%s/down</loc></url>
+				<url><loc>%s/missing</loc></url>
+			</urlset>`, server.URL, server.URL, server.URL)
+		case "/flaky":
+			if flakyRequests.Add(1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Content-Type", "application/ld+json")
+			_, _ = w.Write([]byte(`{"@id": "https://example.com/flaky"}`))
+		case "/down":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &flakyRequests
+}
+
+func harvestFlakySitemap(t *testing.T, retries int) (pkg.SitemapCrawlStats, *atomic.Int32) {
+	server, flakyRequests := newFlakyServer(t)
+
+	crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+	require.NoError(t, err)
+	sitemap, err := NewSitemap(context.Background(), server.Client(), 4, crawlStorage, SitemapMetadata{SitemapID: "test", Loc: server.URL + "/sitemap.xml"})
+	require.NoError(t, err)
+	config, err := NewSitemapHarvestConfig(server.Client(), sitemap, nil, false, false)
+	require.NoError(t, err)
+	config.endOfSitemapRetries = retries
+	config.endOfSitemapRetryBackoff = time.Millisecond
+
+	stats, _, err := sitemap.Harvest(context.Background(), &config)
+	require.NoError(t, err)
+	slices.SortFunc(stats.CrawlFailures, func(a, b pkg.UrlCrawlError) int {
+		return strings.Compare(a.Url, b.Url)
+	})
+	return stats, flakyRequests
+}
+
+func TestEndOfSitemapRetries(t *testing.T) {
+	t.Run("transient failures are retried", func(t *testing.T) {
+		stats, flakyRequests := harvestFlakySitemap(t, 2)
+
+		require.Equal(t, 1, stats.SuccessfulSites, "the flaky url should succeed when retried")
+		require.Equal(t, int32(2), flakyRequests.Load())
+		require.Len(t, stats.CrawlFailures, 2)
+
+		require.True(t, strings.HasSuffix(stats.CrawlFailures[0].Url, "/down"))
+		require.Equal(t, http.StatusBadGateway, stats.CrawlFailures[0].Status)
+		require.Equal(t, 3, stats.CrawlFailures[0].Attempts, "the url should be attempted once and then retried twice")
+
+		require.True(t, strings.HasSuffix(stats.CrawlFailures[1].Url, "/missing"))
+		require.Equal(t, 1, stats.CrawlFailures[1].Attempts, "a 404 is not transient so it should not be retried")
+		require.False(t, stats.DatasetDown)
+	})
+
+	t.Run("retries can be disabled", func(t *testing.T) {
+		stats, flakyRequests := harvestFlakySitemap(t, 0)
+
+		require.Equal(t, 0, stats.SuccessfulSites)
+		require.Equal(t, int32(1), flakyRequests.Load())
+		require.Len(t, stats.CrawlFailures, 3)
+		for _, failure := range stats.CrawlFailures {
+			require.Equal(t, 1, failure.Attempts)
+		}
+	})
+}
+
+func TestRetryWorkers(t *testing.T) {
+	require.Equal(t, 1, retryWorkers(1))
+	require.Equal(t, 1, retryWorkers(3))
+	require.Equal(t, 2, retryWorkers(10))
+}
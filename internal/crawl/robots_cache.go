@@ -0,0 +1,111 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/internetofwater/nabu/internal/common"
+	log "github.com/sirupsen/logrus"
+	"github.com/temoto/robotstxt"
+)
+
+// How long a robots.txt is used before it is fetched again;
+// this matches how long most search engines cache robots.txt
+const defaultRobotsTTL = 24 * time.Hour
+
+// The reason Allowed gives for a url that a rule in the robots.txt of its host disallows,
+// as opposed to a url on a host whose robots.txt could not be fetched
+const disallowedByRobotsRule = "disallowed by robots.txt"
+
+// The robots.txt for a single host
+type robotsCacheEntry struct {
+	// ensures the robots.txt for a host is only fetched once
+	// even if many workers need it at the same time
+	once sync.Once
+	// the parsed robots.txt; nil if it could not be fetched
+	data *robotstxt.RobotsData
+	// the error from fetching the robots.txt, if any
+	err error
+	// when the entry should be fetched again
+	expires time.Time
+}
+
+// A cache of robots.txt keyed by host so every url in a sitemap can be checked
+// against the rules for its own host, even in sitemaps that mix hosts.
+// Following the robots.txt spec, 4xx responses allow everything while 5xx
+// responses and hosts that can't be reached disallow everything
+type RobotsCache struct {
+	mu         sync.Mutex
+	entries    map[string]*robotsCacheEntry
+	httpClient *http.Client
+	ttl        time.Duration
+	// the Crawl-delay of each host is applied to this limiter
+	// when its robots.txt is fetched; nil to ignore Crawl-delay
+	rateLimiter *HostRateLimiter
+}
+
+func NewRobotsCache(httpClient *http.Client, ttl time.Duration, rateLimiter *HostRateLimiter) *RobotsCache {
+	return &RobotsCache{
+		entries:     make(map[string]*robotsCacheEntry),
+		httpClient:  httpClient,
+		ttl:         ttl,
+		rateLimiter: rateLimiter,
+	}
+}
+
+// Get the entry for the host of the url, fetching its robots.txt if it
+// isn't cached or is older than the ttl
+func (c *RobotsCache) lookup(rawUrl string) (*robotsCacheEntry, error) {
+	host, err := getHostname(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	if !ok || time.Now().After(entry.expires) {
+		entry = &robotsCacheEntry{expires: time.Now().Add(c.ttl)}
+		c.entries[host] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.data, entry.err = newRobots(c.httpClient, rawUrl)
+		if entry.err != nil {
+			log.Warnf("failed to get robots.txt for %s so treating all of its urls as disallowed: %v", host, entry.err)
+			return
+		}
+		if c.rateLimiter != nil {
+			if err := c.rateLimiter.SetCrawlDelay(rawUrl, entry.data.FindGroup(common.HarvestAgent).CrawlDelay); err != nil {
+				log.Errorf("failed to set the crawl delay for %s: %v", host, err)
+			}
+		}
+	})
+	return entry, nil
+}
+
+// Return whether the robots.txt for the host of the url allows us to crawl it;
+// if not, the string describes why
+func (c *RobotsCache) Allowed(rawUrl string) (bool, string, error) {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return false, "", err
+	}
+	entry, err := c.lookup(rawUrl)
+	if err != nil {
+		return false, "", err
+	}
+	if entry.err != nil {
+		return false, fmt.Sprintf("robots.txt could not be fetched: %v", entry.err), nil
+	}
+	if !entry.data.TestAgent(parsed.RequestURI(), common.HarvestAgent) {
+		return false, disallowedByRobotsRule, nil
+	}
+	return true, "", nil
+}
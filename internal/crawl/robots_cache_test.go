@@ -0,0 +1,230 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/internetofwater/nabu/internal/crawl/storage"
+	"github.com/internetofwater/nabu/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRobotsCache(t *testing.T) {
+	robotsRequests := atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		robotsRequests.Add(1)
+		_, _ = w.Write([]byte("User-agent: *\nDisallow: /private\nCrawl-delay: 2\n"))
+	}))
+	defer server.Close()
+
+	t.Run("each url is checked against its path", func(t *testing.T) {
+		cache := NewRobotsCache(server.Client(), time.Hour, nil)
+
+		allowed, _, err := cache.Allowed(server.URL + "/public/1")
+		require.NoError(t, err)
+		require.True(t, allowed)
+
+		allowed, reason, err := cache.Allowed(server.URL + "/private/1")
+		require.NoError(t, err)
+		require.False(t, allowed)
+		require.Equal(t, "disallowed by robots.txt", reason)
+	})
+
+	t.Run("robots.txt is only fetched once per host within the ttl", func(t *testing.T) {
+		robotsRequests.Store(0)
+		cache := NewRobotsCache(server.Client(), time.Hour, nil)
+		for i := range 10 {
+			_, _, err := cache.Allowed(fmt.Sprintf("%s/public/%d", server.URL, i))
+			require.NoError(t, err)
+		}
+		require.Equal(t, int32(1), robotsRequests.Load())
+
+		expiring := NewRobotsCache(server.Client(), time.Nanosecond, nil)
+		_, _, err := expiring.Allowed(server.URL + "/public/1")
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond)
+		_, _, err = expiring.Allowed(server.URL + "/public/2")
+		require.NoError(t, err)
+		require.Equal(t, int32(3), robotsRequests.Load(), "an expired entry should be fetched again")
+	})
+
+	t.Run("crawl delay is applied to the rate limiter", func(t *testing.T) {
+		limiter := NewHostRateLimiter(nil)
+		cache := NewRobotsCache(server.Client(), time.Hour, limiter)
+		_, _, err := cache.Allowed(server.URL + "/public/1")
+		require.NoError(t, err)
+
+		host, err := hostKey(server.URL)
+		require.NoError(t, err)
+		require.Equal(t, 2*time.Second, limiter.limiterFor(host).interval)
+	})
+
+	t.Run("error policies", func(t *testing.T) {
+		statusServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer statusServer.Close()
+
+		// a missing robots.txt means there are no restrictions
+		cache := NewRobotsCache(statusServer.Client(), time.Hour, nil)
+		allowed, _, err := cache.Allowed(statusServer.URL + "/anything")
+		require.NoError(t, err)
+		require.True(t, allowed)
+
+		// a server error means everything is disallowed
+		unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer unavailable.Close()
+		cache = NewRobotsCache(unavailable.Client(), time.Hour, nil)
+		allowed, _, err = cache.Allowed(unavailable.URL + "/anything")
+		require.NoError(t, err)
+		require.False(t, allowed)
+
+		// as does a host that can't be reached
+		cache = NewRobotsCache(http.DefaultClient, time.Hour, nil)
+		allowed, reason, err := cache.Allowed("http://127.0.0.1:1/anything")
+		require.NoError(t, err)
+		require.False(t, allowed)
+		require.Contains(t, reason, "could not be fetched")
+	})
+}
+
+func TestHarvestSkipsUrlsDisallowedByRobots(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			_, _ = w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+		case "/sitemap.xml":
+			_, _ = fmt.Fprintf(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+				<url><loc>%s/public/1</loc></url>
+				<url><loc>%s/private/1</loc></url>
+				<url><loc>%s/private/2</loc></url>
+			</urlset>`, server.URL, server.URL, server.URL)
+		case "/public/1":
+			w.Header().Set("Content-Type", "application/ld+json")
+			_, _ = w.Write([]byte(`{"@id": "https://example.com/public/1"}`))
+		default:
+			t.Errorf("a disallowed url was crawled: %s", r.URL.Path)
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+	require.NoError(t, err)
+	sitemap, err := NewSitemap(context.Background(), server.Client(), 1, crawlStorage, SitemapMetadata{SitemapID: "test", Loc: server.URL + "/sitemap.xml"})
+	require.NoError(t, err)
+	config, err := NewSitemapHarvestConfig(server.Client(), sitemap, nil, false, false)
+	require.NoError(t, err)
+
+	stats, _, err := sitemap.Harvest(context.Background(), &config)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.SuccessfulSites)
+	require.Equal(t, 2, stats.SitesDisallowedByRobots)
+	require.Empty(t, stats.CrawlFailures)
+}
+
+// Serve a sitemap index with two sitemaps on the same host whose robots.txt disallows /private
+func newRobotsIndexServer(t *testing.T, robotsRequests *atomic.Int32, disallowPrivate *atomic.Bool) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			robotsRequests.Add(1)
+			if disallowPrivate.Load() {
+				_, _ = w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+			} else {
+				_, _ = w.Write([]byte("User-agent: *\nAllow: /\n"))
+			}
+		case "/sitemap.xml":
+			_, _ = fmt.Fprintf(w, `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:geoconnex="https://geoconnex.us">
+				<sitemap><loc>%s/first.xml</loc><geoconnex:sitemap_id>first</geoconnex:sitemap_id></sitemap>
+				<sitemap><loc>%s/second.xml</loc><geoconnex:sitemap_id>second</geoconnex:sitemap_id></sitemap>
+			</sitemapindex>`, server.URL, server.URL)
+		case "/first.xml", "/second.xml":
+			name := r.URL.Path[1 : len(r.URL.Path)-len(".xml")]
+			_, _ = fmt.Fprintf(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+				<url><loc>%s/public/%s</loc></url>
+				<url><loc>%s/private/%s</loc></url>
+			</urlset>`, server.URL, name, server.URL, name)
+		default:
+			w.Header().Set("Content-Type", "application/ld+json")
+			_, _ = fmt.Fprintf(w, `{"@id": "https://example.com%s"}`, r.URL.Path)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestSitemapIndexRobots(t *testing.T) {
+	harvest := func(t *testing.T, server *httptest.Server, crawlStorage storage.CrawlStorage, ignoreRobots bool) pkg.SitemapIndexCrawlStats {
+		index, err := NewSitemapIndex(server.URL+"/sitemap.xml", server.Client())
+		require.NoError(t, err)
+		stats, err := index.
+			WithStorageDestination(crawlStorage).
+			WithConcurrencyConfig(2, 1).
+			WithOutdatedJsonldCleanup(true).
+			WithIgnoreRobots(ignoreRobots).
+			HarvestSitemaps(context.Background(), server.Client())
+		require.NoError(t, err)
+		return stats
+	}
+
+	t.Run("robots.txt is fetched once per host for every sitemap", func(t *testing.T) {
+		robotsRequests, disallowPrivate := &atomic.Int32{}, &atomic.Bool{}
+		disallowPrivate.Store(true)
+		server := newRobotsIndexServer(t, robotsRequests, disallowPrivate)
+		crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+		require.NoError(t, err)
+
+		stats := harvest(t, server, crawlStorage, false)
+		require.Len(t, stats, 2)
+		for _, sitemapStats := range stats {
+			require.Equal(t, 1, sitemapStats.SitesDisallowedByRobots)
+		}
+		require.Equal(t, int32(1), robotsRequests.Load())
+	})
+
+	t.Run("robots.txt is not fetched or checked when ignored", func(t *testing.T) {
+		robotsRequests, disallowPrivate := &atomic.Int32{}, &atomic.Bool{}
+		disallowPrivate.Store(true)
+		server := newRobotsIndexServer(t, robotsRequests, disallowPrivate)
+		crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+		require.NoError(t, err)
+
+		stats := harvest(t, server, crawlStorage, true)
+		for _, sitemapStats := range stats {
+			require.Equal(t, 2, sitemapStats.SuccessfulSites)
+			require.Zero(t, sitemapStats.SitesDisallowedByRobots)
+		}
+		require.Zero(t, robotsRequests.Load())
+	})
+
+	t.Run("jsonld of urls that robots.txt now disallows is cleaned up", func(t *testing.T) {
+		robotsRequests, disallowPrivate := &atomic.Int32{}, &atomic.Bool{}
+		server := newRobotsIndexServer(t, robotsRequests, disallowPrivate)
+		crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+		require.NoError(t, err)
+
+		harvest(t, server, crawlStorage, false)
+		files, err := crawlStorage.ListDir("summoned/first")
+		require.NoError(t, err)
+		require.Len(t, files, 2)
+
+		disallowPrivate.Store(true)
+		harvest(t, server, crawlStorage, false)
+		files, err = crawlStorage.ListDir("summoned/first")
+		require.NoError(t, err)
+		require.Len(t, files, 1, "the jsonld of the disallowed url should be removed")
+	})
+}
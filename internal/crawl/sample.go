@@ -0,0 +1,137 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"path"
+	"slices"
+
+	"github.com/internetofwater/nabu/internal/crawl/url_info"
+	log "github.com/sirupsen/logrus"
+)
+
+// Where the crawl report of a sitemap is stored if only a sample of its urls were harvested;
+// these are kept apart from the reports in metadata/sitemaps/ so a smoke test doesn't replace them
+const sampleReportPrefix = "metadata/samples/"
+
+// How the urls in a sitemap are chosen when only a sample of them are harvested
+type SampleStrategy string
+
+const (
+	// every url in the sitemap is equally likely to be sampled
+	SampleRandom SampleStrategy = "random"
+	// the urls are grouped by their host and the directory of their path and
+	// are sampled from each group in turn so that every group is represented
+	SampleStratified SampleStrategy = "stratified"
+)
+
+// Parse the strategy for sampling urls from its name; an empty name is random
+func ParseSampleStrategy(strategy string) (SampleStrategy, error) {
+	switch parsed := SampleStrategy(strategy); parsed {
+	case "":
+		return SampleRandom, nil
+	case SampleRandom, SampleStratified:
+		return parsed, nil
+	}
+	return "", fmt.Errorf("unknown sample strategy %q; must be one of %s or %s", strategy, SampleRandom, SampleStratified)
+}
+
+// Options for harvesting only a subset of the urls in each
+// sitemap, i.e. to smoke test a provider that is being onboarded
+type urlSampling struct {
+	// the number of urls to sample from each sitemap; 0 disables sampling
+	size     int
+	strategy SampleStrategy
+	// the maximum number of urls to harvest from each sitemap; 0 means no maximum
+	maxUrls int
+}
+
+func (o urlSampling) enabled() bool {
+	return o.size > 0 || o.maxUrls > 0
+}
+
+// Return the group a url is sampled from when sampling is stratified; i.e.
+// https://geoconnex.us/ref/gages/1000 is in the group geoconnex.us/ref/gages
+func sampleStratum(loc string) string {
+	parsed, err := url.Parse(loc)
+	if err != nil {
+		return ""
+	}
+	return parsed.Host + path.Dir(parsed.Path)
+}
+
+// Return a sample of size urls; the sampled urls keep the order they had in the sitemap
+func sampleUrls(urls []url_info.URL, size int, strategy SampleStrategy, rng *rand.Rand) []url_info.URL {
+	if size >= len(urls) {
+		return urls
+	}
+
+	var chosen []int
+	switch strategy {
+	case SampleStratified:
+		strata := make(map[string][]int)
+		var keys []string
+		for index, url := range urls {
+			key := sampleStratum(url.Loc)
+			if _, ok := strata[key]; !ok {
+				keys = append(keys, key)
+			}
+			strata[key] = append(strata[key], index)
+		}
+		// shuffle which groups go first since there may be fewer urls in the sample than groups
+		rng.Shuffle(len(keys), func(a, b int) { keys[a], keys[b] = keys[b], keys[a] })
+		for _, key := range keys {
+			stratum := strata[key]
+			rng.Shuffle(len(stratum), func(a, b int) { stratum[a], stratum[b] = stratum[b], stratum[a] })
+		}
+		// since the sample is smaller than the sitemap this always ends
+		for round := 0; len(chosen) < size; round++ {
+			for _, key := range keys {
+				if round < len(strata[key]) && len(chosen) < size {
+					chosen = append(chosen, strata[key][round])
+				}
+			}
+		}
+	default:
+		chosen = rng.Perm(len(urls))[:size]
+	}
+
+	slices.Sort(chosen)
+	sampled := make([]url_info.URL, 0, len(chosen))
+	for _, index := range chosen {
+		sampled = append(sampled, urls[index])
+	}
+	return sampled
+}
+
+// Reduce the urls in the sitemap to a sample of them and then to at most the maximum number
+// of urls. The sitemap is marked as sampled even if every url was kept so that a harvest
+// of it is never mistaken for a full one. Bulk sitemaps have no urls to sample
+func (s *Sitemap) applySampling(sampling urlSampling, rng *rand.Rand) {
+	if !sampling.enabled() {
+		return
+	}
+	if s.metadata.IsBulkSitemap() {
+		log.Warnf("Sitemap %s is a bulk sitemap so all of its documents will be harvested instead of a sample", s.metadata.SitemapID)
+		return
+	}
+
+	s.sampled = true
+	s.urlsBeforeSample = len(s.URL)
+	if sampling.size > 0 {
+		s.URL = sampleUrls(s.URL, sampling.size, sampling.strategy, rng)
+	}
+	if sampling.maxUrls > 0 && len(s.URL) > sampling.maxUrls {
+		s.URL = s.URL[:sampling.maxUrls]
+	}
+	log.Infof("Harvesting a sample of %d of the %d urls in sitemap %s", len(s.URL), s.urlsBeforeSample, s.metadata.SitemapID)
+}
+
+// Reduce the urls in a parsed sitemap to the sample that was set on the index, if any
+func (i SitemapIndex) sample(sitemap *Sitemap) {
+	sitemap.applySampling(i.sampling, rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())))
+}
@@ -0,0 +1,181 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/internetofwater/nabu/internal/crawl/storage"
+	"github.com/internetofwater/nabu/internal/crawl/url_info"
+	"github.com/internetofwater/nabu/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+func urlsFromLocs(locs ...string) []url_info.URL {
+	urls := []url_info.URL{}
+	for _, loc := range locs {
+		urls = append(urls, url_info.NewUrlFromString(loc))
+	}
+	return urls
+}
+
+func TestSampleUrls(t *testing.T) {
+	locs := []string{}
+	for i := range 100 {
+		locs = append(locs, fmt.Sprintf("https://example.com/gages/%d", i))
+	}
+	for i := range 3 {
+		locs = append(locs, fmt.Sprintf("https://example.com/wells/%d", i))
+	}
+	locs = append(locs, "https://other.example.com/gages/1")
+	urls := urlsFromLocs(locs...)
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	t.Run("random", func(t *testing.T) {
+		sampled := sampleUrls(urls, 10, SampleRandom, rng)
+		require.Len(t, sampled, 10)
+		seen := map[string]bool{}
+		previousIndex := -1
+		for _, url := range sampled {
+			require.False(t, seen[url.Loc], "urls should not be sampled twice")
+			seen[url.Loc] = true
+			index := -1
+			for i, loc := range locs {
+				if loc == url.Loc {
+					index = i
+				}
+			}
+			require.Greater(t, index, previousIndex, "the sample should keep the order of the sitemap")
+			previousIndex = index
+		}
+	})
+
+	t.Run("stratified", func(t *testing.T) {
+		sampled := sampleUrls(urls, 5, SampleStratified, rng)
+		require.Len(t, sampled, 5)
+		strata := map[string]int{}
+		for _, url := range sampled {
+			strata[sampleStratum(url.Loc)]++
+		}
+		require.Equal(t, map[string]int{
+			"example.com/gages":       2,
+			"example.com/wells":       2,
+			"other.example.com/gages": 1,
+		}, strata, "every group should be sampled before any group is sampled twice")
+	})
+
+	t.Run("sample larger than the sitemap", func(t *testing.T) {
+		require.Equal(t, urls, sampleUrls(urls, len(urls)+1, SampleStratified, rng))
+	})
+}
+
+func TestApplySampling(t *testing.T) {
+	urls := urlsFromLocs("https://example.com/1", "https://example.com/2", "https://example.com/3", "https://example.com/4")
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	sitemap := &Sitemap{URL: urls}
+	sitemap.applySampling(urlSampling{}, rng)
+	require.False(t, sitemap.sampled)
+	require.Len(t, sitemap.URL, 4)
+
+	sitemap.applySampling(urlSampling{maxUrls: 2}, rng)
+	require.True(t, sitemap.sampled)
+	require.Equal(t, 4, sitemap.urlsBeforeSample)
+	require.Equal(t, urls[:2], sitemap.URL, "the maximum should keep the first urls in the sitemap")
+
+	bulk := &Sitemap{URL: urls, metadata: SitemapMetadata{BulkContainerImage: "example/image"}}
+	bulk.applySampling(urlSampling{size: 1}, rng)
+	require.False(t, bulk.sampled, "bulk sitemaps should not be sampled")
+	require.Len(t, bulk.URL, 4)
+}
+
+func TestParseSampleStrategy(t *testing.T) {
+	strategy, err := ParseSampleStrategy("")
+	require.NoError(t, err)
+	require.Equal(t, SampleRandom, strategy)
+
+	strategy, err = ParseSampleStrategy("stratified")
+	require.NoError(t, err)
+	require.Equal(t, SampleStratified, strategy)
+
+	_, err = ParseSampleStrategy("systematic")
+	require.Error(t, err)
+}
+
+func TestHarvestSampleNeverCleansUp(t *testing.T) {
+	fetched := map[string]bool{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/robots.txt":
+			_, _ = w.Write([]byte("User-agent: *\nAllow: /\n"))
+		case r.URL.Path == "/sitemap.xml":
+			_, _ = fmt.Fprintf(w, `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:geoconnex="https://geoconnex.us">
+				<sitemap><loc>%s/features.xml</loc><geoconnex:sitemap_id>features</geoconnex:sitemap_id></sitemap>
+			</sitemapindex>`, server.URL)
+		case r.URL.Path == "/features.xml":
+			urls := ""
+			for i := range 10 {
+				urls += fmt.Sprintf("<url><loc>%s/features/%d</loc></url>", server.URL, i)
+			}
+			_, _ = fmt.Fprintf(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">%s</urlset>`, urls)
+		case strings.HasPrefix(r.URL.Path, "/features/"):
+			if r.Method == http.MethodGet {
+				fetched[r.URL.Path] = true
+			}
+			w.Header().Set("Content-Type", "application/ld+json")
+			_, _ = fmt.Fprintf(w, `{"@id": "https://example.com%s"}`, r.URL.Path)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+	require.NoError(t, err)
+	const outdated = "summoned/features/outdated.jsonld"
+	require.NoError(t, crawlStorage.StoreWithoutServersideHash(outdated, strings.NewReader(`{}`)))
+
+	index, err := NewSitemapIndex(server.URL+"/sitemap.xml", server.Client())
+	require.NoError(t, err)
+	stats, err := index.
+		WithStorageDestination(crawlStorage).
+		WithConcurrencyConfig(1, 1).
+		WithOutdatedJsonldCleanup(true).
+		WithSampling(3, SampleRandom, 0).
+		HarvestSitemaps(context.Background(), server.Client())
+	require.NoError(t, err)
+
+	require.Len(t, fetched, 3, "only the sampled urls should be fetched")
+	require.Len(t, stats, 1)
+	require.True(t, stats[0].Sample)
+	require.Equal(t, 10, stats[0].UrlsBeforeSample)
+	require.Equal(t, 3, stats[0].SitesInSitemap)
+	require.Equal(t, 3, stats[0].SuccessfulSites)
+
+	exists, err := crawlStorage.Exists(outdated)
+	require.NoError(t, err)
+	require.True(t, exists, "a sample must never clean up outdated jsonld")
+
+	exists, err = crawlStorage.Exists("metadata/sitemaps/features.json")
+	require.NoError(t, err)
+	require.False(t, exists, "a sample should not replace the report of a full harvest")
+
+	reader, err := crawlStorage.Get(sampleReportPrefix + "features.json")
+	require.NoError(t, err)
+	defer func() { _ = reader.Close() }()
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	var report pkg.SitemapCrawlStats
+	require.NoError(t, json.Unmarshal(data, &report))
+	require.True(t, report.Sample)
+}
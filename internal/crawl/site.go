@@ -8,7 +8,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"reflect"
 	"strings"
@@ -16,6 +15,7 @@ import (
 
 	common "github.com/internetofwater/nabu/internal/common"
 	hashchecks "github.com/internetofwater/nabu/internal/crawl/hash_checks"
+	"github.com/internetofwater/nabu/internal/crawl/headless"
 	"github.com/internetofwater/nabu/internal/crawl/url_info"
 	"github.com/internetofwater/nabu/internal/opentelemetry"
 	"github.com/internetofwater/nabu/pkg"
@@ -25,10 +25,15 @@ import (
 	"go.opentelemetry.io/otel/trace"
 )
 
+// The media types we accept when fetching a pid. JSON-LD is preferred since it is
+// stored as is and html is next since most landing pages embed their JSON-LD.
+// Other RDF serializations are only used if the server offers nothing else
+const harvestAcceptHeader = "application/ld+json;q=1.0, text/html;q=0.8, text/turtle;q=0.5, application/n-triples;q=0.5, application/rdf+xml;q=0.5"
+
 // Given a response, get the jsonld within the response
 // it will first try to get the jsonld directly if the content
 // type is application/ld+json otherwise it tries to find it
-// inside the html
+// inside the html. Turtle, N-Triples, and RDF/XML are converted to jsonld
 func getJSONLD(resp *http.Response, url url_info.URL, body []byte) ([]byte, error) {
 	mime := resp.Header.Get("Content-Type")
 	if strings.Contains(mime, "application/ld+json") {
@@ -39,8 +44,17 @@ func getJSONLD(resp *http.Response, url url_info.URL, body []byte) ([]byte, erro
 			log.Errorf("failed to parse jsonld within the html for %s", url.Loc)
 			return nil, pkg.UrlCrawlError{Url: url.Loc, Status: resp.StatusCode, Message: err.Error()}
 		}
-		if jsonldString == "" || jsonldString == "{}" {
-			log.Errorf("empty jsonld string '%s' found within the html for %s", jsonldString, url.Loc)
+		return []byte(jsonldString), nil
+	} else if rdfMediaType := common.RdfMediaType(mime); rdfMediaType != "" {
+		// relative iris are resolved against wherever we were redirected to
+		baseIRI := url.Loc
+		if resp.Request != nil && resp.Request.URL != nil {
+			baseIRI = resp.Request.URL.String()
+		}
+		jsonldString, err := common.RdfToJsonld(string(body), rdfMediaType, baseIRI)
+		if err != nil {
+			log.Errorf("failed to convert %s to jsonld for %s", rdfMediaType, url.Loc)
+			return nil, pkg.UrlCrawlError{Url: url.Loc, Status: resp.StatusCode, Message: err.Error()}
 		}
 		return []byte(jsonldString), nil
 	}
@@ -55,6 +69,175 @@ type harvestResult struct {
 	serverHadHash bool
 	warning       pkg.ShaclInfo
 	nonFatalError pkg.UrlCrawlError
+	// the server responded with 304 Not Modified to a conditional request
+	// so the jsonld already in storage was kept
+	notModified bool
+	// the total time spent waiting on the host rate limiter
+	rateLimitWait time.Duration
+	// a token was already taken from the host rate limiter for this attempt at the url
+	tookRateLimitToken bool
+	// robots.txt does not allow the url to be crawled so it was skipped
+	disallowedByRobots bool
+	// a rule in robots.txt disallows the url, as opposed to its robots.txt being
+	// unreachable, so its jsonld from earlier harvests is no longer kept
+	disallowedByRobotsRule bool
+	// the nonFatalError was transient, i.e. a timeout or a 5xx response,
+	// so the url may succeed if it is attempted again later
+	retryable bool
+}
+
+// Whether a response with the status code is a transient failure that is worth retrying
+func isRetryableStatus(statusCode int) bool {
+	return statusCode >= 500 || statusCode == http.StatusTooManyRequests
+}
+
+// Whether a request that failed without a response is a transient failure that is worth retrying;
+// i.e. the retries of the http client were exhausted or the request timed out
+func isRetryableRequestError(err error) bool {
+	var maxErr *common.MaxRetryError
+	return errors.As(err, &maxErr) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// Wait until the rate limiter allows another request to the host of the url
+// and record the time spent waiting in the result and the span. Each attempt at a
+// url takes a single token, so the HEAD of a hash check and the GET that follows it
+// count as one request against the Crawl-delay of the host rather than two
+func waitForRateLimit(ctx context.Context, config *SitemapHarvestConfig, url url_info.URL, result *harvestResult) error {
+	if config.rateLimiter == nil || result.tookRateLimitToken {
+		return nil
+	}
+	result.tookRateLimitToken = true
+	waited, err := config.rateLimiter.Wait(ctx, url.Loc)
+	result.rateLimitWait += waited
+	if waited > 0 {
+		trace.SpanFromContext(ctx).AddEvent("rate_limit_wait", trace.WithAttributes(attribute.Float64("wait_seconds", waited.Seconds())))
+	}
+	return err
+}
+
+// Validate the jsonld with shacl, if a shacl service is configured, and store it.
+// Shacl failures are recorded as a warning in the result since they are non fatal
+// unless the config says to exit on them
+func validateAndStoreJsonld(ctx context.Context, config *SitemapHarvestConfig, url url_info.URL, summonedPath string, jsonld []byte, result_metadata *harvestResult) error {
+	// make sure the pointer itself is not nil and not empty
+	if config.grpcClient != nil && *config.grpcClient != nil {
+		err := validate_shacl(ctx, *config.grpcClient, url.Loc, string(jsonld))
+		if err != nil {
+			if shaclErr, ok := err.(ShaclValidationFailureError); ok {
+				result_metadata.warning = pkg.ShaclInfo{
+					ShaclStatus:            pkg.ShaclInvalid,
+					ShaclValidationMessage: shaclErr.ShaclErrorMessage,
+					Url:                    url.Loc,
+				}
+
+				// we don't always return here because it is non fatal
+				// and not all integrations may be compliant with our shacl shapes yet;
+				// For the time being, it is better to harvest and then have the integrator fix it
+				// after the fact; in the future there could be a strict
+				// validation mode wherein we fail fast upon shacl non-compliance
+				// however, we do allow a flag to exit and strictly fail
+				if config.exitOnShaclFailure {
+					log.Errorf("Returning early on shacl failure for %s with message %s", url.Loc, shaclErr.ShaclErrorMessage)
+					return fmt.Errorf("exiting early for %s with shacl failure %s", url.Loc, shaclErr.ShaclErrorMessage)
+				}
+			} else {
+				// if there is an other arbitrary issue with the shacl validation service, we mark it as a failure
+				// but it is non fatal; we don't want to fail the entire harvest due to an issue with the shacl validation service; thus we log the error and continue on
+				msg := fmt.Sprintf("failed to communicate with shacl validation service: %v when harvesting %s", err, url.Loc)
+				log.Error(msg)
+				result_metadata.warning = pkg.ShaclInfo{
+					ShaclStatus:            pkg.ShaclInvalid,
+					ShaclValidationMessage: msg,
+					Url:                    url.Loc,
+				}
+			}
+		}
+	}
+
+	// Store from the buffered copy
+	return config.storageDestination.StoreWithHash(summonedPath, bytes.NewReader(jsonld), len(jsonld))
+}
+
+// The outcome of shacl validation for a harvested document
+func shaclOutcome(config *SitemapHarvestConfig, result harvestResult) pkg.ShaclStatus {
+	if !result.warning.IsNil() {
+		return result.warning.ShaclStatus
+	}
+	if config.grpcClient != nil && *config.grpcClient != nil {
+		return pkg.ShaclValid
+	}
+	return pkg.ShaclSkipped
+}
+
+// Record where and when a stored document was fetched from, if provenance is enabled.
+// A failure only loses the provenance of the document so it is logged rather than failing the harvest
+func recordProvenance(config *SitemapHarvestConfig, provenance harvestProvenance, result harvestResult) {
+	if !config.recordProvenance {
+		return
+	}
+	provenance.shaclStatus = shaclOutcome(config, result)
+	if err := storeProvenance(config.storageDestination, provenance); err != nil {
+		log.Errorf("failed to store provenance for %s: %v", provenance.url.Loc, err)
+	}
+}
+
+// Harvest a pid whose jsonld is generated client side by rendering it in headless chrome
+func harvestRenderedPID(ctx context.Context, sitemapId string, url url_info.URL, config *SitemapHarvestConfig, result_metadata harvestResult) (harvestResult, error) {
+	span := trace.SpanFromContext(ctx)
+
+	if err := waitForRateLimit(ctx, config, url, &result_metadata); err != nil {
+		return result_metadata, err
+	}
+
+	fetchedAt := time.Now()
+	log.Tracef("rendering %s in headless chrome", url.Loc)
+	renderedHtml, err := config.renderer.Render(ctx, url.Loc)
+	if err != nil {
+		var pageErr headless.PageError
+		if errors.As(err, &pageErr) {
+			span.SetStatus(codes.Error, pageErr.Error())
+			result_metadata.nonFatalError = pkg.UrlCrawlError{Url: url.Loc, Message: pageErr.Error()}
+			return result_metadata, nil
+		}
+		return result_metadata, fmt.Errorf("failed to render %s with headless chrome: %w", url.Loc, err)
+	}
+	span.AddEvent("rendered_with_headless_chrome")
+
+	if config.maxDocumentBytes > 0 && int64(len(renderedHtml)) > config.maxDocumentBytes {
+		errormsg := fmt.Sprintf("rendered html for %s exceeded the maximum document size of %d bytes", url.Loc, config.maxDocumentBytes)
+		log.Error(errormsg)
+		span.SetStatus(codes.Error, errormsg)
+		result_metadata.nonFatalError = pkg.UrlCrawlError{Url: url.Loc, Message: errormsg, Category: pkg.DocumentTooLarge}
+		return result_metadata, nil
+	}
+
+	jsonldString, err := GetJsonLDFromHTML([]byte(renderedHtml))
+	if err != nil {
+		log.Errorf("failed to parse jsonld within the rendered html for %s", url.Loc)
+		span.SetStatus(codes.Error, err.Error())
+		result_metadata.nonFatalError = pkg.UrlCrawlError{Url: url.Loc, Message: err.Error()}
+		return result_metadata, nil
+	}
+	jsonld := []byte(jsonldString)
+
+	summonedPath, err := urlToStoragePath(sitemapId, url)
+	if err != nil {
+		return result_metadata, fmt.Errorf("failed to get storage path: %w", err)
+	}
+	if err := validateAndStoreJsonld(ctx, config, url, summonedPath, jsonld, &result_metadata); err != nil {
+		return result_metadata, err
+	}
+	recordProvenance(config, harvestProvenance{
+		sitemapId:     sitemapId,
+		url:           url,
+		finalUrl:      url.Loc,
+		fetchedAt:     fetchedAt,
+		pathInStorage: summonedPath,
+		jsonld:        jsonld,
+	}, result_metadata)
+	config.identifiers.recordDocument(sitemapId, url.Loc, jsonld)
+	result_metadata.pathInStorage = summonedPath
+	return result_metadata, nil
 }
 
 // Crawl and download a single pid
@@ -75,12 +258,31 @@ func harvestOnePID(ctx context.Context, sitemapId string, url url_info.URL, conf
 
 	result_metadata := harvestResult{}
 
+	if config.robots != nil {
+		allowed, reason, err := config.robots.Allowed(url.Loc)
+		if err != nil {
+			return result_metadata, fmt.Errorf("failed to check robots.txt for %s: %w", url.Loc, err)
+		}
+		if !allowed {
+			log.Debugf("skipping %s: %s", url.Loc, reason)
+			span.AddEvent("disallowed_by_robots", trace.WithAttributes(attribute.String("reason", reason)))
+			result_metadata.disallowedByRobots = true
+			result_metadata.disallowedByRobotsRule = reason == disallowedByRobotsRule
+			return result_metadata, nil
+		}
+	}
+
+	hashChecker := hashchecks.NewHashChecker(config.httpClient, config.storageDestination)
+
 	if config.checkExistenceBeforeCrawl.Load() {
-		result, err := hashchecks.NewHashChecker(config.httpClient, config.storageDestination).
-			CheckIfAlreadyExists(url, sitemapId)
+		if err := waitForRateLimit(ctx, config, url, &result_metadata); err != nil {
+			return result_metadata, err
+		}
+		result, err := hashChecker.CheckIfAlreadyExists(url, sitemapId)
 		var nonFatalError pkg.UrlCrawlError
 		if errors.As(err, &nonFatalError) {
 			result_metadata.nonFatalError = nonFatalError
+			result_metadata.retryable = isRetryableRequestError(err)
 			return result_metadata, nil
 		}
 		if err != nil {
@@ -94,19 +296,42 @@ func harvestOnePID(ctx context.Context, sitemapId string, url url_info.URL, conf
 		}
 	}
 
+	if config.renderer != nil {
+		return harvestRenderedPID(ctx, sitemapId, url, config, result_metadata)
+	}
+
 	log.Tracef("fetching %s", url.Loc)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url.Loc, nil)
 	if err != nil {
 		return result_metadata, fmt.Errorf("failed to create http request for %s: %w", url.Loc, err)
 	}
 	req.Header.Set("User-Agent", common.HarvestAgent)
-	req.Header.Set("Accept", "application/ld+json;q=1.0, text/html;q=0.8")
+	req.Header.Set("Accept", harvestAcceptHeader)
+
+	// if the server couldn't tell us the hash of the document, fall back to
+	// a conditional request using the validators from the last harvest
+	var previousValidators hashchecks.CacheValidators
+	hasPreviousValidators := false
+	if config.useConditionalRequests && !result_metadata.serverHadHash {
+		previousValidators, hasPreviousValidators, err = hashChecker.GetCacheValidators(url, sitemapId)
+		if err != nil {
+			return result_metadata, fmt.Errorf("failed to get cache validators for %s: %w", url.Loc, err)
+		}
+		if hasPreviousValidators {
+			previousValidators.SetConditionalHeaders(req)
+		}
+	}
+
+	if err := waitForRateLimit(ctx, config, url, &result_metadata); err != nil {
+		return result_metadata, err
+	}
 
+	fetchedAt := time.Now()
 	resp, err := config.httpClient.Do(req)
 	if err != nil {
-		var maxErr *common.MaxRetryError
-		if errors.As(err, &maxErr) || errors.Is(err, context.DeadlineExceeded) {
+		if isRetryableRequestError(err) {
 			result_metadata.nonFatalError = pkg.UrlCrawlError{Url: url.Loc, Message: err.Error()}
+			result_metadata.retryable = true
 			return result_metadata, nil
 		}
 		return result_metadata, fmt.Errorf("got fatal error of type %s when fetching %s: %w", reflect.TypeOf(err).String(), url.Loc, err)
@@ -115,19 +340,38 @@ func harvestOnePID(ctx context.Context, sitemapId string, url url_info.URL, conf
 
 	defer func() { _ = resp.Body.Close() }()
 
+	if resp.StatusCode == http.StatusNotModified && hasPreviousValidators {
+		log.Tracef("skipping %s since the server responded that it was not modified", url.Loc)
+		summonedPath, err := urlToStoragePath(sitemapId, url)
+		if err != nil {
+			return result_metadata, fmt.Errorf("failed to get storage path: %w", err)
+		}
+		result_metadata.pathInStorage = summonedPath
+		result_metadata.notModified = true
+		return result_metadata, nil
+	}
+
 	if resp.StatusCode >= 400 {
 		errormsg := fmt.Sprintf("failed to fetch %s, got status %s", url.Loc, resp.Status)
 		log.Error(errormsg)
 		// status makes jaeger mark as failed with red, whereas SetEvent just marks it with a message
 		span.SetStatus(codes.Error, errormsg)
 		result_metadata.nonFatalError = pkg.UrlCrawlError{Url: url.Loc, Status: resp.StatusCode, Message: errormsg}
+		result_metadata.retryable = isRetryableStatus(resp.StatusCode)
 		return result_metadata, nil
 	}
 
-	rawbytes, err := io.ReadAll(resp.Body)
+	rawbytes, tooLarge, err := readBoundedBody(resp.Body, resp.ContentLength, config.maxDocumentBytes)
 	if err != nil {
 		return result_metadata, fmt.Errorf("failed to read response body for %s: %w", url.Loc, err)
 	}
+	if tooLarge {
+		errormsg := fmt.Sprintf("response for %s exceeded the maximum document size of %d bytes", url.Loc, config.maxDocumentBytes)
+		log.Error(errormsg)
+		span.SetStatus(codes.Error, errormsg)
+		result_metadata.nonFatalError = pkg.UrlCrawlError{Url: url.Loc, Status: resp.StatusCode, Message: errormsg, Category: pkg.DocumentTooLarge}
+		return result_metadata, nil
+	}
 
 	if len(rawbytes) <= 2 {
 		// if the server is not providing content, this is a sign that something is wrong, and thus
@@ -163,50 +407,36 @@ func harvestOnePID(ctx context.Context, sitemapId string, url url_info.URL, conf
 		}
 	}
 
-	// make sure the pointer itself is not nil and not empty
-	if config.grpcClient != nil && *config.grpcClient != nil {
-		err = validate_shacl(ctx, *config.grpcClient, url.Loc, string(jsonld))
-		if err != nil {
-			if shaclErr, ok := err.(ShaclValidationFailureError); ok {
-				result_metadata.warning = pkg.ShaclInfo{
-					ShaclStatus:            pkg.ShaclInvalid,
-					ShaclValidationMessage: shaclErr.ShaclErrorMessage,
-					Url:                    url.Loc,
-				}
-
-				// we don't always return here because it is non fatal
-				// and not all integrations may be compliant with our shacl shapes yet;
-				// For the time being, it is better to harvest and then have the integrator fix it
-				// after the fact; in the future there could be a strict
-				// validation mode wherein we fail fast upon shacl non-compliance
-				// however, we do allow a flag to exit and strictly fail
-				if config.exitOnShaclFailure {
-					log.Errorf("Returning early on shacl failure for %s with message %s", url.Loc, shaclErr.ShaclErrorMessage)
-					return result_metadata, fmt.Errorf("exiting early for %s with shacl failure %s", url.Loc, shaclErr.ShaclErrorMessage)
-				}
-			} else {
-				// if there is an other arbitrary issue with the shacl validation service, we mark it as a failure
-				// but it is non fatal; we don't want to fail the entire harvest due to an issue with the shacl validation service; thus we log the error and continue on
-				msg := fmt.Sprintf("failed to communicate with shacl validation service: %v when harvesting %s", err, url.Loc)
-				log.Error(msg)
-				result_metadata.warning = pkg.ShaclInfo{
-					ShaclStatus:            pkg.ShaclInvalid,
-					ShaclValidationMessage: msg,
-					Url:                    url.Loc,
-				}
-			}
-		}
+	if err := validateAndStoreJsonld(ctx, config, url, summonedPath, jsonld, &result_metadata); err != nil {
+		return result_metadata, err
 	}
 
-	// Store from the buffered copy
-	if err = config.storageDestination.StoreWithHash(summonedPath, bytes.NewReader(jsonld), len(jsonld)); err != nil {
-		return result_metadata, err
+	finalUrl := url.Loc
+	if resp.Request != nil && resp.Request.URL != nil {
+		finalUrl = resp.Request.URL.String()
 	}
+	recordProvenance(config, harvestProvenance{
+		sitemapId:     sitemapId,
+		url:           url,
+		finalUrl:      finalUrl,
+		statusCode:    resp.StatusCode,
+		fetchedAt:     fetchedAt,
+		pathInStorage: summonedPath,
+		jsonld:        jsonld,
+	}, result_metadata)
+	config.identifiers.recordDocument(sitemapId, url.Loc, jsonld)
 
-	if config.robots != nil && config.robots.CrawlDelay > 0 {
-		log.Debug("sleeping for", config.robots.CrawlDelay)
-		time.Sleep(config.robots.CrawlDelay)
+	if validators := hashchecks.CacheValidatorsFromResponse(resp); !validators.IsEmpty() {
+		if err := hashChecker.StoreCacheValidators(url, sitemapId, validators); err != nil {
+			// without the validators the next harvest just downloads the document again
+			log.Errorf("failed to store cache validators for %s: %v", url.Loc, err)
+		}
+	} else if hasPreviousValidators {
+		if err := hashChecker.RemoveCacheValidators(url, sitemapId); err != nil {
+			log.Errorf("failed to remove outdated cache validators for %s: %v", url.Loc, err)
+		}
 	}
+
 	result_metadata.pathInStorage = summonedPath
 	return result_metadata, nil
 }
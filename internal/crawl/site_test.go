@@ -6,8 +6,10 @@ package crawl
 import (
 	"bufio"
 	"context"
+	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -18,6 +20,8 @@ import (
 
 	common "github.com/internetofwater/nabu/internal/common"
 	"github.com/internetofwater/nabu/internal/common/projectpath"
+	hashchecks "github.com/internetofwater/nabu/internal/crawl/hash_checks"
+	"github.com/internetofwater/nabu/internal/crawl/headless"
 	"github.com/internetofwater/nabu/internal/crawl/storage"
 	"github.com/internetofwater/nabu/internal/crawl/url_info"
 	"github.com/internetofwater/nabu/pkg"
@@ -92,6 +96,7 @@ func TestTimeout(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, 0, report.nonFatalError.Status)
 	require.Contains(t, report.nonFatalError.Message, "timeout")
+	require.True(t, report.retryable, "a timeout is transient so it should be retried")
 }
 
 func TestTimeoutWithHEADRequest(t *testing.T) {
@@ -117,6 +122,7 @@ func TestTimeoutWithHEADRequest(t *testing.T) {
 	require.Equal(t, 0, report.nonFatalError.Status)
 	require.Contains(t, report.nonFatalError.Message, "Head")
 	require.Contains(t, report.nonFatalError.Message, "timeout")
+	require.True(t, report.retryable, "a timeout is transient so it should be retried")
 }
 
 func TestHarvestOneSite(t *testing.T) {
@@ -142,6 +148,54 @@ func TestHarvestOneSite(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestHarvestRdfSite(t *testing.T) {
+	const dummy_domain = "http://google.com"
+
+	crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+	require.NoError(t, err)
+	url := url_info.NewUrlFromString(dummy_domain)
+	check := atomic.Bool{}
+	check.Store(false)
+	config := &SitemapHarvestConfig{
+		storageDestination:        crawlStorage,
+		checkExistenceBeforeCrawl: &check,
+	}
+
+	t.Run("n-triples are stored as jsonld", func(t *testing.T) {
+		config.httpClient = common.NewMockedClient(true, map[string]common.MockResponse{
+			dummy_domain: {
+				StatusCode:  200,
+				Body:        `<http://google.com> <https://schema.org/name> "Google" .`,
+				ContentType: "application/n-triples",
+			},
+		})
+		result, err := harvestOnePID(context.Background(), "DUMMY_SITEMAP", url, config)
+		require.NoError(t, err)
+		require.True(t, result.nonFatalError.IsNil())
+
+		stored, err := crawlStorage.Get(result.pathInStorage)
+		require.NoError(t, err)
+		defer func() { _ = stored.Close() }()
+		jsonld, err := io.ReadAll(stored)
+		require.NoError(t, err)
+		require.Contains(t, string(jsonld), `"@id":"http://google.com"`)
+		require.Contains(t, string(jsonld), `"schema:name":"Google"`)
+	})
+
+	t.Run("invalid rdf is a crawl error", func(t *testing.T) {
+		config.httpClient = common.NewMockedClient(true, map[string]common.MockResponse{
+			dummy_domain: {
+				StatusCode:  200,
+				Body:        `this is not n-triples`,
+				ContentType: "application/n-triples",
+			},
+		})
+		result, err := harvestOnePID(context.Background(), "DUMMY_SITEMAP", url, config)
+		require.NoError(t, err)
+		require.Equal(t, dummy_domain, result.nonFatalError.Url)
+	})
+}
+
 func TestHarvestWithShaclValidation(t *testing.T) {
 
 	// if rust is installed just skip this since it is a non essential test
@@ -311,3 +365,193 @@ func TestHarvestWithShaclValidation(t *testing.T) {
 		require.Error(t, err)
 	})
 }
+
+func TestConditionalGetFallback(t *testing.T) {
+	jsonld, err := os.ReadFile("testdata/reference_feature.jsonld")
+	require.NoError(t, err)
+
+	const etag = `"v1"`
+	const lastModified = "Wed, 05 Feb 2025 16:39:34 GMT"
+	conditionalRequests := atomic.Int32{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/etag":
+			if r.Header.Get("If-None-Match") == etag {
+				conditionalRequests.Add(1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+		case "/last_modified":
+			if r.Header.Get("If-Modified-Since") == lastModified {
+				conditionalRequests.Add(1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("Last-Modified", lastModified)
+		}
+		w.Header().Set("Content-Type", "application/ld+json")
+		_, _ = w.Write(jsonld)
+	}))
+	defer server.Close()
+
+	crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+	require.NoError(t, err)
+	check := atomic.Bool{}
+	check.Store(false)
+	config := &SitemapHarvestConfig{
+		httpClient:                server.Client(),
+		storageDestination:        crawlStorage,
+		checkExistenceBeforeCrawl: &check,
+		useConditionalRequests:    true,
+	}
+
+	for _, path := range []string{"/etag", "/last_modified"} {
+		url := url_info.NewUrlFromString(server.URL + path)
+
+		first, err := harvestOnePID(context.Background(), "test", url, config)
+		require.NoError(t, err)
+		require.True(t, first.nonFatalError.IsNil())
+		require.False(t, first.notModified, "there are no validators on the first harvest")
+		require.NotEmpty(t, first.pathInStorage)
+
+		second, err := harvestOnePID(context.Background(), "test", url, config)
+		require.NoError(t, err)
+		require.True(t, second.nonFatalError.IsNil(), "a 304 should not be a crawl error")
+		require.True(t, second.notModified)
+		require.Equal(t, first.pathInStorage, second.pathInStorage)
+
+		stored, err := crawlStorage.Get(second.pathInStorage)
+		require.NoError(t, err)
+		storedBytes, err := io.ReadAll(stored)
+		require.NoError(t, err)
+		require.NoError(t, stored.Close())
+		require.Equal(t, jsonld, storedBytes, "the jsonld from the first harvest should be kept")
+	}
+	require.Equal(t, int32(2), conditionalRequests.Load())
+
+	t.Run("no conditional request if the jsonld is missing", func(t *testing.T) {
+		url := url_info.NewUrlFromString(server.URL + "/etag")
+		path, err := urlToStoragePath("test", url)
+		require.NoError(t, err)
+		require.NoError(t, crawlStorage.Remove(path))
+
+		result, err := harvestOnePID(context.Background(), "test", url, config)
+		require.NoError(t, err)
+		require.False(t, result.notModified)
+		exists, err := crawlStorage.Exists(path)
+		require.NoError(t, err)
+		require.True(t, exists)
+	})
+}
+
+func TestCacheValidatorsAreCleanedUpWithTheirJsonld(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			_, _ = fmt.Fprintf(w, `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:geoconnex="https://geoconnex.us">
+				<sitemap><loc>%s/features.xml</loc><geoconnex:sitemap_id>features</geoconnex:sitemap_id></sitemap>
+			</sitemapindex>`, server.URL)
+		case "/features.xml":
+			_, _ = fmt.Fprintf(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"><url><loc>%s/features/kept</loc></url></urlset>`, server.URL)
+		case "/features/kept":
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Content-Type", "application/ld+json")
+			_, _ = w.Write([]byte(`{"@id": "https://example.com/kept"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+	require.NoError(t, err)
+	removed := url_info.NewUrlFromString(server.URL + "/features/removed")
+	removedJsonld, err := urlToStoragePath("features", removed)
+	require.NoError(t, err)
+	require.NoError(t, crawlStorage.StoreWithoutServersideHash(removedJsonld, strings.NewReader(`{}`)))
+	removedValidators := hashchecks.CacheValidatorsPath("features", removed)
+	require.NoError(t, crawlStorage.StoreWithoutServersideHash(removedValidators, strings.NewReader(`{"ETag": "\"v1\""}`)))
+
+	index, err := NewSitemapIndex(server.URL+"/sitemap.xml", server.Client())
+	require.NoError(t, err)
+	_, err = index.
+		WithStorageDestination(crawlStorage).
+		WithConcurrencyConfig(1, 1).
+		WithOutdatedJsonldCleanup(true).
+		HarvestSitemaps(context.Background(), server.Client())
+	require.NoError(t, err)
+
+	for _, path := range []string{removedJsonld, removedValidators} {
+		exists, err := crawlStorage.Exists(path)
+		require.NoError(t, err)
+		require.False(t, exists, "%s is for a url that left the sitemap", path)
+	}
+	exists, err := crawlStorage.Exists(hashchecks.CacheValidatorsPath("features", url_info.NewUrlFromString(server.URL+"/features/kept")))
+	require.NoError(t, err)
+	require.True(t, exists, "the validators for a url in the sitemap should be kept")
+}
+
+func TestHarvestWithHeadlessChrome(t *testing.T) {
+	const renderedPage = `<html><head></head><body>
+		<script type="application/ld+json">{"@id": "https://example.com/rendered", "name": "injected client side"}</script>
+	</body></html>`
+	fake := headless.NewFakeChrome(map[string]string{"https://example.com/rendered": renderedPage})
+	defer fake.Close()
+
+	crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+	require.NoError(t, err)
+	check := atomic.Bool{}
+	check.Store(false)
+	config := &SitemapHarvestConfig{
+		// every request must go through the renderer so deny all direct requests
+		httpClient:                common.NewMockedClient(true, map[string]common.MockResponse{}),
+		storageDestination:        crawlStorage,
+		checkExistenceBeforeCrawl: &check,
+		renderer:                  headless.NewChromeRenderer(fake.URL, fake.Client()),
+	}
+
+	result, err := harvestOnePID(context.Background(), "test", url_info.NewUrlFromString("https://example.com/rendered"), config)
+	require.NoError(t, err)
+	require.True(t, result.nonFatalError.IsNil())
+
+	stored, err := crawlStorage.Get(result.pathInStorage)
+	require.NoError(t, err)
+	storedBytes, err := io.ReadAll(stored)
+	require.NoError(t, err)
+	require.NoError(t, stored.Close())
+	require.JSONEq(t, `{"@id": "https://example.com/rendered", "name": "injected client side"}`, string(storedBytes))
+
+	t.Run("rendered html larger than the maximum document size is a crawl error", func(t *testing.T) {
+		limited := *config
+		limited.maxDocumentBytes = int64(len(renderedPage)) - 1
+		result, err := harvestOnePID(context.Background(), "test", url_info.NewUrlFromString("https://example.com/rendered"), &limited)
+		require.NoError(t, err)
+		require.Equal(t, pkg.DocumentTooLarge, result.nonFatalError.Category)
+	})
+
+	t.Run("page that fails to render is a crawl error", func(t *testing.T) {
+		result, err := harvestOnePID(context.Background(), "test", url_info.NewUrlFromString("https://example.com/missing"), config)
+		require.NoError(t, err)
+		require.Contains(t, result.nonFatalError.Message, "ERR_NAME_NOT_RESOLVED")
+	})
+
+	t.Run("render_js requires a headless chrome url", func(t *testing.T) {
+		sitemap := &Sitemap{
+			URL:      []url_info.URL{url_info.NewUrlFromString("https://example.com/rendered")},
+			metadata: SitemapMetadata{SitemapID: "test", RenderJavascript: true},
+			workers:  1,
+		}
+		mockedClient := common.NewMockedClient(true, map[string]common.MockResponse{
+			"https://example.com/robots.txt": {StatusCode: 404, Body: "not found"},
+		})
+		_, err := SitemapIndex{}.newSitemapHarvestConfig(mockedClient, sitemap, nil, NewRobotsCache(mockedClient, defaultRobotsTTL, nil))
+		require.ErrorContains(t, err, "no headless chrome url")
+
+		config, err := SitemapIndex{}.WithHeadlessChromeUrl(fake.URL).newSitemapHarvestConfig(mockedClient, sitemap, nil, NewRobotsCache(mockedClient, defaultRobotsTTL, nil))
+		require.NoError(t, err)
+		require.NotNil(t, config.renderer)
+	})
+}
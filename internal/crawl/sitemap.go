@@ -10,18 +10,20 @@ import (
 	"fmt"
 	"math"
 	"net/http"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/internetofwater/nabu/internal/common"
+	hashchecks "github.com/internetofwater/nabu/internal/crawl/hash_checks"
+	"github.com/internetofwater/nabu/internal/crawl/headless"
 	"github.com/internetofwater/nabu/internal/crawl/storage"
 	"github.com/internetofwater/nabu/internal/opentelemetry"
 	"github.com/internetofwater/nabu/internal/protoBuild"
 	"github.com/internetofwater/nabu/pkg"
 	sitemap "github.com/oxffaa/gopher-parse-sitemap"
 	log "github.com/sirupsen/logrus"
-	"github.com/temoto/robotstxt"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/internetofwater/nabu/internal/crawl/url_info"
 	"golang.org/x/sync/errgroup"
@@ -53,6 +55,12 @@ type Sitemap struct {
 	// the number of parallel workers to use when harvesting the sitemap
 	// i.e. 1 worker = 1 goroutine = 1 URL
 	workers int `xml:"-"`
+
+	// true if only a sample of the urls in the sitemap are harvested; a sampled
+	// sitemap never removes outdated data since most urls weren't harvested
+	sampled bool `xml:"-"`
+	// the number of urls in the sitemap before it was sampled
+	urlsBeforeSample int `xml:"-"`
 }
 
 // all the of the clients and config needed to harvest a particular site
@@ -60,8 +68,9 @@ type Sitemap struct {
 type SitemapHarvestConfig struct {
 	// the number of parallel workers to use when harvesting the sitemap
 	workers int
-	// the config for the robotstxt behavior
-	robots *robotstxt.Group
+	// the robots.txt rules for each host; urls are only
+	// checked against robots.txt if this is set
+	robots *RobotsCache
 	// the config for http requests
 	httpClient *http.Client
 	// the config for grpc requests
@@ -82,28 +91,85 @@ type SitemapHarvestConfig struct {
 	// the number of failed sites in a row before we exit
 	// and assume the sitemap is down
 	failedSitesToAssumeDatasetDown int
+	// the number of successfully harvested urls between
+	// each checkpoint written to storage; 0 disables checkpoints
+	checkpointInterval int
+	// skip urls that were already harvested according
+	// to the checkpoint left by a previous run
+	resumeFromCheckpoint bool
+	// skip urls whose lastmod in the sitemap has not advanced
+	// since the url was last successfully harvested
+	skipUnchangedLastMod bool
+	// send a conditional request with the ETag and Last-Modified from
+	// the previous harvest if the server didn't provide a hash
+	useConditionalRequests bool
+	// limits the rate of requests to each host; this may
+	// be shared with the configs for other sitemaps
+	rateLimiter *HostRateLimiter
+	// renders each page in headless chrome before extracting its jsonld;
+	// nil unless the sitemap index says the sitemap needs javascript
+	renderer *headless.ChromeRenderer
+	// store a PROV-O record in prov/ of when and where each document was fetched
+	recordProvenance bool
+	// the number of times a url that failed with a transient error is attempted
+	// again once the rest of the sitemap is harvested; 0 disables retries
+	endOfSitemapRetries int
+	// the delay before the first round of end of sitemap retries; it doubles each round
+	endOfSitemapRetryBackoff time.Duration
+	// the maximum size of a document fetched from a single url; 0 means no maximum
+	maxDocumentBytes int64
+	// the maximum size of a single jsonld document output by a bulk container; 0 means no maximum
+	maxBulkLineBytes int64
+	// the resource limits and maximum runtime of the containers and executables of bulk sitemaps
+	bulkLimits BulkSourceLimits
+	// whether bulk sitemaps may run local executables and read local files
+	allowLocalBulkSources bool
+	// whether lines in the output of a bulk source that aren't jsonld documents fail the harvest
+	bulkLineErrors bulkLineErrorPolicy
+	// the fraction of the documents stored for a bulk sitemap that its cleanup
+	// may remove before it is skipped; 0 means there is no maximum
+	maxBulkCleanupFraction float64
+	// indexes the top level @id of every harvested document across all sitemaps
+	// in the index so that duplicates can be reported; nil if this is disabled
+	identifiers *identifierIndex
+	// the settings from the command line and the sitemap index that the
+	// sitemap is harvested with; these are recorded in the crawl report
+	settings pkg.SitemapHarvestSettings
 }
 
 // Make a new SiteHarvestConfig with all the clients and config
 // initialized and ready to crawl a sitemap
 // this config is shared across all goroutines and thus must be thread safe
 func NewSitemapHarvestConfig(httpClient *http.Client, sitemap *Sitemap, shaclGRPCClient protoBuild.ShaclValidatorClient, exitOnShaclFailure bool, cleanupOutdatedJsonld bool) (SitemapHarvestConfig, error) {
+	rateLimiter := NewHostRateLimiter(nil)
+	robotsCache := NewRobotsCache(httpClient, defaultRobotsTTL, rateLimiter)
+	return newSitemapHarvestConfigWithRobots(httpClient, sitemap, shaclGRPCClient, exitOnShaclFailure, cleanupOutdatedJsonld, robotsCache, rateLimiter)
+}
+
+// Make a new SitemapHarvestConfig that checks urls against the given robots.txt cache and whose
+// requests are limited by the given rate limiter; a sitemap index passes the cache and limiter
+// that are shared by every sitemap in the harvest. robots.txt isn't checked if robotsCache is nil
+func newSitemapHarvestConfigWithRobots(httpClient *http.Client, sitemap *Sitemap, shaclGRPCClient protoBuild.ShaclValidatorClient, exitOnShaclFailure bool, cleanupOutdatedJsonld bool, robotsCache *RobotsCache, rateLimiter *HostRateLimiter) (SitemapHarvestConfig, error) {
 
 	if sitemap.workers < 1 {
 		return SitemapHarvestConfig{}, fmt.Errorf("no workers set for sitemap %s", sitemap.metadata.SitemapID)
 	}
 
-	var robotsTxt *robotstxt.Group
 	// don't check robots.txt for bulk sitemaps
 	// since they point to docker images and not individual web pages to crawl
-	if !sitemap.metadata.IsBulkSitemap() {
+	if sitemap.metadata.IsBulkSitemap() {
+		robotsCache = nil
+	}
+	if robotsCache != nil {
+		// fetch the robots.txt for the first url up front so that a sitemap
+		// whose host can't be reached fails before any workers are started
 		firstUrl := sitemap.URL[0]
-		robotsTxt, err := newRobots(httpClient, firstUrl.Loc)
+		entry, err := robotsCache.lookup(firstUrl.Loc)
 		if err != nil {
 			return SitemapHarvestConfig{}, err
 		}
-		if !robotsTxt.Test(common.HarvestAgent) {
-			return SitemapHarvestConfig{}, fmt.Errorf("robots.txt does not allow us to crawl %s", firstUrl.Loc)
+		if entry.err != nil {
+			return SitemapHarvestConfig{}, entry.err
 		}
 	}
 
@@ -111,7 +177,7 @@ func NewSitemapHarvestConfig(httpClient *http.Client, sitemap *Sitemap, shaclGRP
 	checkJsonldExistsBeforeDownloading.Store(true)
 
 	return SitemapHarvestConfig{
-		robots:                    robotsTxt,
+		robots:                    robotsCache,
 		httpClient:                httpClient,
 		grpcClient:                &shaclGRPCClient,
 		storageDestination:        sitemap.storageDestination,
@@ -119,10 +185,17 @@ func NewSitemapHarvestConfig(httpClient *http.Client, sitemap *Sitemap, shaclGRP
 		exitOnShaclFailure:        exitOnShaclFailure,
 		cleanupOutdatedJsonld:     cleanupOutdatedJsonld,
 		workers:                   sitemap.workers,
-		// currently hard coded. could be configurable in the future
-		maxShaclErrorsToStore: 20,
-		// currently hard coded. could be configurable in the future
+		rateLimiter:               rateLimiter,
+		// the defaults unless they are set on the sitemap index or for the sitemap itself
+		maxShaclErrorsToStore:          20,
 		failedSitesToAssumeDatasetDown: 20,
+		maxDocumentBytes:               defaultMaxDocumentBytes,
+		maxBulkLineBytes:               defaultMaxBulkLineBytes,
+		bulkLineErrors: bulkLineErrorPolicy{
+			maxErrorsToStore: defaultMaxBulkLineErrorsToStore,
+			maxErrorRate:     defaultMaxBulkLineErrorRate,
+		},
+		maxBulkCleanupFraction: defaultMaxBulkCleanupFraction,
 	}, nil
 }
 
@@ -142,10 +215,7 @@ func (s *Sitemap) ensureValid(workers int) error {
 
 // given the sitemap identifier and the url return the path to store it
 func urlToStoragePath(sitemapId string, url url_info.URL) (string, error) {
-	if url.Base64Loc == "" {
-		return "", fmt.Errorf("no base64 loc for url %s", url.Loc)
-	}
-	return fmt.Sprintf("summoned/%s/%s.jsonld", sitemapId, url.Base64Loc), nil
+	return url_info.SummonedPath(sitemapId, url)
 }
 
 func (s *Sitemap) Harvest(ctx context.Context, config *SitemapHarvestConfig) (pkg.SitemapCrawlStats, []string, error) {
@@ -157,26 +227,38 @@ func (s *Sitemap) Harvest(ctx context.Context, config *SitemapHarvestConfig) (pk
 	var err error
 	var cleanedUpFilesNames []string
 	if s.metadata.IsBulkSitemap() {
-		stats, err = s.HarvestBulkSitemap(ctx, config)
-		// bulk doesn't do cleanup
-		cleanedUpFilesNames = []string{}
+		stats, cleanedUpFilesNames, err = s.HarvestBulkSitemap(ctx, config)
 	} else {
 		stats, cleanedUpFilesNames, err = s.HarvestPIDsSitemap(ctx, config)
 	}
 	if err != nil {
 		log.Errorf("Error harvesting sitemap %s: %s", s.metadata.SitemapID, err)
+		// the report of a failed bulk source is still stored since its stderr explains the failure
+		if stats.BulkSourceFailure != nil {
+			if storeErr := s.storeCrawlReport(stats); storeErr != nil {
+				log.Errorf("Failed to store the crawl report for %s: %v", s.metadata.SitemapID, storeErr)
+			}
+		}
 		return stats, cleanedUpFilesNames, err
 	}
 
-	asJson, err := stats.ToJsonIoReader()
-	if err != nil {
+	if err := s.storeCrawlReport(stats); err != nil {
 		return pkg.SitemapCrawlStats{}, nil, err
 	}
-	err = s.storageDestination.StoreMetadata(fmt.Sprintf("metadata/sitemaps/%s.json", s.metadata.SitemapID), asJson)
+	return stats, cleanedUpFilesNames, nil
+}
+
+// Store the crawl report of the sitemap in the metadata bucket
+func (s *Sitemap) storeCrawlReport(stats pkg.SitemapCrawlStats) error {
+	asJson, err := stats.ToJsonIoReader()
 	if err != nil {
-		return pkg.SitemapCrawlStats{}, nil, err
+		return err
 	}
-	return stats, cleanedUpFilesNames, err
+	reportPath := fmt.Sprintf("metadata/sitemaps/%s.json", s.metadata.SitemapID)
+	if s.sampled {
+		reportPath = fmt.Sprintf("%s%s.json", sampleReportPrefix, s.metadata.SitemapID)
+	}
+	return s.storageDestination.StoreMetadata(reportPath, asJson)
 }
 
 // Harvest all the URLs in the given sitemap and return the associated metadata as well as a list
@@ -189,7 +271,8 @@ func (s *Sitemap) HarvestPIDsSitemap(ctx context.Context, config *SitemapHarvest
 	ctx, span := opentelemetry.SubSpanFromCtxWithName(ctx, fmt.Sprintf("sitemap_harvest_%s", s.metadata.SitemapID))
 	defer span.End()
 
-	group, ctx := errgroup.WithContext(ctx)
+	// the retries run after the errgroup is done and its context is canceled
+	group, groupCtx := errgroup.WithContext(ctx)
 	group.SetLimit(config.workers)
 
 	start := time.Now()
@@ -207,9 +290,28 @@ func (s *Sitemap) HarvestPIDsSitemap(ctx context.Context, config *SitemapHarvest
 	totalSitesContacted := atomic.Int64{}
 
 	sitesInSitemap := make(storage.Set)
+	// the provenance of every site in the sitemap; only used if provenance is recorded
+	provenanceInSitemap := make(storage.Set)
+	// the cache validators of every site in the sitemap; the ones for sites that
+	// left the sitemap are cleaned up along with their jsonld
+	cacheValidatorsInSitemap := make(storage.Set)
 
 	sitesWithShaclFailures := atomic.Int32{}
 
+	// the number of sites that responded 304 Not Modified to a conditional request
+	sitesNotModified := atomic.Int32{}
+
+	// the cumulative time all workers spent waiting on the host rate limiter
+	rateLimitWait := atomic.Int64{}
+
+	// the number of sites that were skipped since robots.txt disallows crawling them
+	sitesDisallowedByRobots := atomic.Int32{}
+	// the urls disallowed by a rule in robots.txt; their jsonld is removed by the cleanup
+	// since they are no longer harvested, but urls on a host whose robots.txt is
+	// unreachable are kept so an outage doesn't remove everything from that host
+	urlsDisallowedByRobotsRule := []url_info.URL{}
+	urlsDisallowedByRobotsRuleMu := sync.Mutex{}
+
 	noPreviousData, err := s.storageDestination.IsEmptyDir("summoned/" + s.metadata.SitemapID)
 	if err != nil {
 		return pkg.SitemapCrawlStats{}, nil, err
@@ -221,85 +323,241 @@ func (s *Sitemap) HarvestPIDsSitemap(ctx context.Context, config *SitemapHarvest
 	} else {
 		config.checkExistenceBeforeCrawl.Store(true)
 	}
-
-	for _, url := range s.URL {
-
-		if path, err := urlToStoragePath(s.metadata.SitemapID, url); err != nil {
+	// there can't be cache validators from a previous harvest if there is no data
+	config.useConditionalRequests = !noPreviousData
+
+	// a sample neither resumes nor leaves a checkpoint since
+	// that would be mistaken for the progress of a full harvest
+	checkpointInterval := config.checkpointInterval
+	if s.sampled {
+		checkpointInterval = 0
+	}
+	checkpoints := newCheckpointTracker(s.metadata.SitemapID, checkpointInterval, s.storageDestination)
+	// the time spent harvesting this sitemap in previous runs that were resumed
+	previousSeconds := 0.0
+	// urls that were harvested in a previous run and can thus be skipped
+	alreadyHarvested := make(map[string]struct{})
+	if config.resumeFromCheckpoint && !s.sampled {
+		previous, found, err := loadCheckpoint(s.storageDestination, s.metadata.SitemapID)
+		if err != nil {
 			return pkg.SitemapCrawlStats{}, nil, err
-		} else {
-			sitesInSitemap.Add(path)
 		}
-		group.Go(func() error {
-			if sitemapStatusTracker.AppearsDown() {
-				return &SitemapAppearsDownError{
-					message: fmt.Sprintf("Returning early since %d failures were detected without a single successful harvest; the sitemap is assumed to be down or had a change in the underlying API", config.failedSitesToAssumeDatasetDown),
+		if found {
+			log.Infof("Resuming harvest of %s from a checkpoint with %d already harvested urls", s.metadata.SitemapID, len(previous.CompletedUrls))
+			previousSeconds = previous.SecondsSoFar
+			// only restore urls that are still in the sitemap
+			for _, url := range s.URL {
+				if entry, ok := previous.CompletedUrls[url.Loc]; ok {
+					checkpoints.restore(url.Loc, entry)
+					alreadyHarvested[url.Loc] = struct{}{}
+					successfulSites.Add(entry.PathInStorage)
+					if entry.ShaclInvalid {
+						sitesWithShaclFailures.Add(1)
+					}
 				}
 			}
-
-			result_metadata, err := harvestOnePID(ctx, s.metadata.SitemapID, url, config)
-			if err != nil {
-				if !errors.Is(err, context.Canceled) {
-					log.Error(err)
+			for _, warning := range previous.ShaclWarnings {
+				if _, ok := alreadyHarvested[warning.Url]; ok {
+					s.warnings = append(s.warnings, warning)
 				}
-				return err
 			}
+		} else {
+			log.Infof("No checkpoint found for %s so harvesting the entire sitemap", s.metadata.SitemapID)
+		}
+	}
+
+	manifest := lastModManifest{}
+	if config.skipUnchangedLastMod {
+		manifest, err = loadLastModManifest(s.storageDestination, s.metadata.SitemapID)
+		if err != nil {
+			return pkg.SitemapCrawlStats{}, nil, err
+		}
+	}
+	// the lastmods of all urls that were harvested or skipped in this run
+	// these are used as the manifest for the next harvest
+	currentLastMods := make(map[string]string)
+	currentLastModsMu := sync.Mutex{}
+	recordLastMod := func(url url_info.URL) {
+		if url.LastMod == "" {
+			return
+		}
+		currentLastModsMu.Lock()
+		currentLastMods[url.Loc] = url.LastMod
+		currentLastModsMu.Unlock()
+	}
+	sitesWithUnchangedLastMod := 0
+
+	// urls that failed with a transient error and are attempted again after the main pass
+	retries := &retryQueue{}
 
+	// Harvest a single url and record the outcome; attempt is 1 for the main pass
+	// and is incremented for every end of sitemap retry
+	harvestAndRecord := func(ctx context.Context, url url_info.URL, attempt int) error {
+		result_metadata, err := harvestOnePID(ctx, s.metadata.SitemapID, url, config)
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				log.Error(err)
+			}
+			return err
+		}
+
+		if result_metadata.disallowedByRobots {
+			// this is neither a success nor a failure since we never contacted the site
+			sitesDisallowedByRobots.Add(1)
+			if result_metadata.disallowedByRobotsRule {
+				urlsDisallowedByRobotsRuleMu.Lock()
+				urlsDisallowedByRobotsRule = append(urlsDisallowedByRobotsRule, url)
+				urlsDisallowedByRobotsRuleMu.Unlock()
+			}
+			return nil
+		}
+
+		if attempt == 1 {
 			totalSitesContacted.Store((totalSitesContacted.Add(1)))
+		}
+
+		if result_metadata.notModified {
+			sitesNotModified.Add(1)
+		}
+		rateLimitWait.Add(int64(result_metadata.rateLimitWait))
 
-			if !result_metadata.nonFatalError.IsNil() {
-				s.errorMu.Lock()
-				s.nonFatalErrors = append(s.nonFatalErrors, result_metadata.nonFatalError)
-				s.errorMu.Unlock()
+		if !result_metadata.nonFatalError.IsNil() {
+			// only the first attempt counts towards the dataset appearing down
+			if attempt == 1 {
 				sitemapStatusTracker.AddSiteFailure()
-			} else {
-				sitemapStatusTracker.AddSiteSuccess()
 			}
+			failure := result_metadata.nonFatalError
+			failure.Attempts = attempt
+			if result_metadata.retryable && attempt <= config.endOfSitemapRetries {
+				retries.add(url, failure)
+				return nil
+			}
+			s.errorMu.Lock()
+			s.nonFatalErrors = append(s.nonFatalErrors, failure)
+			s.errorMu.Unlock()
+		} else {
+			sitemapStatusTracker.AddSiteSuccess()
+		}
 
-			if !result_metadata.warning.IsNil() {
-				shaclFailuresSoFar := sitesWithShaclFailures.Load()
-				if shaclFailuresSoFar < int32(config.maxShaclErrorsToStore) {
-					log.Errorf("Shacl validation failed for %s: %s", url.Loc, result_metadata.warning)
-					s.warningMu.Lock()
-					s.warnings = append(s.warnings, result_metadata.warning)
-					s.warningMu.Unlock()
-				} else if shaclFailuresSoFar == int32(config.maxShaclErrorsToStore) {
-					log.Warnf("Too many shacl errors for %s. Skipping further errors to prevent log spam", s.metadata.SitemapID)
-				}
-				if result_metadata.warning.ShaclStatus == pkg.ShaclInvalid {
-					sitesWithShaclFailures.Store(
-						shaclFailuresSoFar + 1,
-					)
-				}
+		if !result_metadata.warning.IsNil() {
+			shaclFailuresSoFar := sitesWithShaclFailures.Load()
+			if shaclFailuresSoFar < int32(config.maxShaclErrorsToStore) {
+				log.Errorf("Shacl validation failed for %s: %s", url.Loc, result_metadata.warning)
+				s.warningMu.Lock()
+				s.warnings = append(s.warnings, result_metadata.warning)
+				s.warningMu.Unlock()
+			} else if shaclFailuresSoFar == int32(config.maxShaclErrorsToStore) {
+				log.Warnf("Too many shacl errors for %s. Skipping further errors to prevent log spam", s.metadata.SitemapID)
 			}
-			if result_metadata.pathInStorage != "" {
-				successfulSitesMu.Lock()
-				if successfulSites.Contains(result_metadata.pathInStorage) {
-					successfulSitesMu.Unlock()
-					errMsg := fmt.Sprintf("Got at least two responses in the same sitemap crawl that resolved to the same path in storage: %s. URL %s has potential duplicate data in API", result_metadata.pathInStorage, url.Loc)
-					log.Error(errMsg)
-					return pkg.UrlCrawlError{Url: url.Loc, Message: errMsg}
-				}
-				successfulSites.Add(result_metadata.pathInStorage)
+			if result_metadata.warning.ShaclStatus == pkg.ShaclInvalid {
+				sitesWithShaclFailures.Store(
+					shaclFailuresSoFar + 1,
+				)
+			}
+		}
+		if result_metadata.pathInStorage != "" {
+			successfulSitesMu.Lock()
+			if successfulSites.Contains(result_metadata.pathInStorage) {
 				successfulSitesMu.Unlock()
+				errMsg := fmt.Sprintf("Got at least two responses in the same sitemap crawl that resolved to the same path in storage: %s. URL %s has potential duplicate data in API", result_metadata.pathInStorage, url.Loc)
+				log.Error(errMsg)
+				return pkg.UrlCrawlError{Url: url.Loc, Message: errMsg}
 			}
-			if !result_metadata.serverHadHash && config.checkExistenceBeforeCrawl.Load() {
-				// if the server didn't provide a hash then we can skip the hash check
-				// since presumably the server doesn't support this header in the HEAD request
-				config.checkExistenceBeforeCrawl.Store(false)
-				log.Warnf("Server didn't provide a hash on %s. Skipping hash checks going forward for harvested sites", url.Loc)
+			successfulSites.Add(result_metadata.pathInStorage)
+			successfulSitesMu.Unlock()
+
+			if result_metadata.nonFatalError.IsNil() {
+				recordLastMod(url)
+				shouldCheckpoint := checkpoints.markCompleted(url.Loc, checkpointEntry{
+					PathInStorage: result_metadata.pathInStorage,
+					ShaclInvalid:  result_metadata.warning.ShaclStatus == pkg.ShaclInvalid,
+				})
+				if shouldCheckpoint {
+					s.warningMu.Lock()
+					warnings := slices.Clone(s.warnings)
+					s.warningMu.Unlock()
+					if err := checkpoints.write(warnings, previousSeconds+time.Since(start).Seconds()); err != nil {
+						// a failed checkpoint only affects resuming so it shouldn't stop the harvest
+						log.Errorf("Failed to write checkpoint for %s: %v", s.metadata.SitemapID, err)
+					}
+				}
 			}
-			if math.Mod(float64(totalSitesContacted.Load()), 500) == 0 {
-				log.Infof("Harvested %d/%d sites for %s", totalSitesContacted.Load(), len(s.URL), s.metadata.SitemapID)
+		}
+		if !result_metadata.serverHadHash && config.checkExistenceBeforeCrawl.Load() {
+			// if the server didn't provide a hash then we can skip the hash check
+			// since presumably the server doesn't support this header in the HEAD request
+			config.checkExistenceBeforeCrawl.Store(false)
+			log.Warnf("Server didn't provide a hash on %s. Skipping hash checks going forward for harvested sites", url.Loc)
+		}
+		if attempt == 1 && math.Mod(float64(totalSitesContacted.Load()), 500) == 0 {
+			log.Infof("Harvested %d/%d sites for %s", totalSitesContacted.Load(), len(s.URL), s.metadata.SitemapID)
+		}
+
+		return nil
+	}
+
+	for _, url := range s.URL {
+
+		path, err := urlToStoragePath(s.metadata.SitemapID, url)
+		if err != nil {
+			return pkg.SitemapCrawlStats{}, nil, err
+		}
+		sitesInSitemap.Add(path)
+		cacheValidatorsInSitemap.Add(hashchecks.CacheValidatorsPath(s.metadata.SitemapID, url))
+		if config.recordProvenance {
+			provenancePath, err := urlToProvenancePath(s.metadata.SitemapID, url)
+			if err != nil {
+				return pkg.SitemapCrawlStats{}, nil, err
 			}
+			provenanceInSitemap.Add(provenancePath)
+		}
 
-			return nil
+		if _, ok := alreadyHarvested[url.Loc]; ok {
+			log.Tracef("Skipping %s since it was harvested before the checkpoint", url.Loc)
+			recordLastMod(url)
+			continue
+		}
+		if config.skipUnchangedLastMod && url_info.LastModUnchanged(manifest.UrlLastMods[url.Loc], url.LastMod) {
+			log.Tracef("Skipping %s since its lastmod %s has not changed since the last harvest", url.Loc, url.LastMod)
+			successfulSitesMu.Lock()
+			successfulSites.Add(path)
+			successfulSitesMu.Unlock()
+			recordLastMod(url)
+			sitesWithUnchangedLastMod++
+			continue
+		}
+		group.Go(func() error {
+			if sitemapStatusTracker.AppearsDown() {
+				return &SitemapAppearsDownError{
+					message: fmt.Sprintf("Returning early since %d failures were detected without a single successful harvest; the sitemap is assumed to be down or had a change in the underlying API", config.failedSitesToAssumeDatasetDown),
+				}
+			}
+			return harvestAndRecord(groupCtx, url, 1)
 		})
 	}
 	err = group.Wait()
+	if err == nil && config.endOfSitemapRetries > 0 {
+		err = retries.retry(ctx, config.workers, config.endOfSitemapRetries, config.endOfSitemapRetryBackoff, harvestAndRecord)
+	}
+	// urls still in the queue weren't retried since the harvest stopped early
+	for _, item := range retries.drain() {
+		s.nonFatalErrors = append(s.nonFatalErrors, item.lastFailure)
+	}
+
+	// documents that were skipped as unchanged weren't read so their @ids come from the last harvest
+	if restoreErr := config.identifiers.restoreSkipped(s.storageDestination, s.metadata.SitemapID, s.URL); restoreErr != nil {
+		log.Errorf("Failed to restore the @ids of skipped documents in %s: %v", s.metadata.SitemapID, restoreErr)
+	}
+	// a sample doesn't have the @ids of the whole sitemap so it leaves the stored ones as they are
+	if !s.sampled {
+		if storeErr := config.identifiers.store(s.storageDestination, s.metadata.SitemapID); storeErr != nil {
+			log.Errorf("Failed to store the @ids of %s: %v", s.metadata.SitemapID, storeErr)
+		}
+	}
 
 	stats := pkg.SitemapCrawlStats{
 		SitemapSourceLink:  s.metadata.Loc,
-		SecondsToComplete:  time.Since(start).Seconds(),
+		SecondsToComplete:  previousSeconds + time.Since(start).Seconds(),
 		SitemapName:        s.metadata.SitemapID,
 		SitemapDescription: s.metadata.DatasetDescription,
 		SuccessfulSites:    len(successfulSites),
@@ -308,18 +566,67 @@ func (s *Sitemap) HarvestPIDsSitemap(ctx context.Context, config *SitemapHarvest
 			TotalShaclFailures: int(sitesWithShaclFailures.Load()),
 			ShaclWarnings:      s.warnings,
 		},
-		CrawlFailures: s.nonFatalErrors,
-		DatasetDown:   sitemapStatusTracker.AppearsDown(),
+		CrawlFailures:              s.nonFatalErrors,
+		DatasetDown:                sitemapStatusTracker.AppearsDown(),
+		SitesResumedFromCheckpoint: len(alreadyHarvested),
+		SitesWithUnchangedLastMod:  sitesWithUnchangedLastMod,
+		SitesNotModified:           int(sitesNotModified.Load()),
+		SecondsWaitingOnRateLimit:  time.Duration(rateLimitWait.Load()).Seconds(),
+		SitesDisallowedByRobots:    int(sitesDisallowedByRobots.Load()),
+		Sample:                     s.sampled,
+		UrlsBeforeSample:           s.urlsBeforeSample,
+		Settings:                   config.settings,
 	}
+	span.SetAttributes(attribute.Float64("rate_limit_wait_seconds", stats.SecondsWaitingOnRateLimit))
 
 	if err != nil {
+		// save whatever progress was made so the harvest can be resumed
+		if checkpointInterval > 0 {
+			if checkpointErr := checkpoints.write(s.warnings, stats.SecondsToComplete); checkpointErr != nil {
+				log.Errorf("Failed to write checkpoint for %s: %v", s.metadata.SitemapID, checkpointErr)
+			}
+		}
 		// we still return the stats if there is a failure
 		// so that a caller can decide what to log
 		return stats, nil, err
 	}
 
+	// the checkpoint and lastmod manifest describe the full sitemap so a sample leaves them as they are
+	if !s.sampled {
+		if err := removeCheckpoint(s.storageDestination, s.metadata.SitemapID); err != nil {
+			log.Errorf("Failed to remove checkpoint for %s: %v", s.metadata.SitemapID, err)
+		}
+	}
+
+	if config.skipUnchangedLastMod && !s.sampled {
+		newManifest := lastModManifest{
+			SitemapID:      s.metadata.SitemapID,
+			SitemapLastMod: s.metadata.LastMod,
+			UrlLastMods:    currentLastMods,
+		}
+		if err := newManifest.store(s.storageDestination); err != nil {
+			// the next harvest will just crawl more than it needs to
+			log.Errorf("Failed to store lastmod manifest for %s: %v", s.metadata.SitemapID, err)
+		}
+	}
+
 	cleanedUpFiles := []string{}
-	if config.cleanupOutdatedJsonld {
+	if s.sampled {
+		log.Warnf("Only a sample of %s was harvested so outdated JSON-LD is never cleaned up", s.metadata.SitemapID)
+	} else if config.cleanupOutdatedJsonld {
+		for _, url := range urlsDisallowedByRobotsRule {
+			path, err := urlToStoragePath(s.metadata.SitemapID, url)
+			if err != nil {
+				return stats, nil, err
+			}
+			delete(sitesInSitemap, path)
+			delete(cacheValidatorsInSitemap, hashchecks.CacheValidatorsPath(s.metadata.SitemapID, url))
+			provenancePath, err := urlToProvenancePath(s.metadata.SitemapID, url)
+			if err != nil {
+				return stats, nil, err
+			}
+			delete(provenanceInSitemap, provenancePath)
+		}
 		log.Info("Cleaning up outdated JSON-LD files in summoned/" + s.metadata.SitemapID)
 		cleanedUpFiles, err = storage.CleanupFiles("summoned/"+s.metadata.SitemapID, sitesInSitemap, s.storageDestination)
 		if err != nil {
@@ -327,6 +634,10 @@ func (s *Sitemap) HarvestPIDsSitemap(ctx context.Context, config *SitemapHarvest
 		} else {
 			log.Infof("Cleaned up %d outdated JSON-LD files in summoned/%s", len(cleanedUpFiles), s.metadata.SitemapID)
 		}
+		if config.recordProvenance {
+			cleanupOutdatedProvenance(s.metadata.SitemapID, provenanceInSitemap, s.storageDestination)
+		}
+		cleanupOutdatedFilesAlongsideJsonld("cache_validators/"+s.metadata.SitemapID, cacheValidatorsInSitemap, s.storageDestination)
 	} else {
 		log.Warnf("Skipping old JSON-LD cleanups. It is possible %s will contain outdated JSON-LD files", "summoned/"+s.metadata.SitemapID)
 	}
@@ -363,13 +674,13 @@ func NewSitemap(ctx context.Context, client *http.Client, workers int, storageDe
 
 	urls := make([]url_info.URL, 0)
 
-	resp, err := client.Get(metadata.Loc)
+	sitemapData, err := openSitemapLocation(client, metadata.Loc)
 	if err != nil {
 		return &serializedSitemap, err
 	}
-	defer func() { _ = resp.Body.Close() }()
+	defer func() { _ = sitemapData.Close() }()
 
-	if err = sitemap.Parse(resp.Body, func(entry sitemap.Entry) error {
+	if err = sitemap.Parse(sitemapData, func(entry sitemap.Entry) error {
 		urls = append(urls, *url_info.NewUrlFromSitemapEntry(entry))
 		return nil
 	}); err != nil {
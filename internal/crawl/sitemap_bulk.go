@@ -7,8 +7,11 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/md5"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
@@ -16,10 +19,6 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/image"
-	"github.com/docker/docker/client"
-	"github.com/moby/moby/pkg/stdcopy"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/internetofwater/nabu/internal/crawl/storage"
@@ -28,26 +27,28 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
-// HarvestBulkSitemap processes a bulk sitemap by pulling and running Docker images specified as sitemap URLs.
-func (s *Sitemap) HarvestBulkSitemap(ctx context.Context, config *SitemapHarvestConfig) (pkg.SitemapCrawlStats, error) {
+// The default fraction of the documents stored for a bulk sitemap that its cleanup may remove
+const defaultMaxBulkCleanupFraction = 0.5
+
+// A document read from the output of a bulk source that has not been uploaded yet
+type bulkDocument struct {
+	path string
+	data []byte
+}
+
+// HarvestBulkSitemap processes a bulk sitemap by opening each of its urls with a bulk source, such as
+// running a Docker image, and harvesting every line of the output as a jsonld document. Documents
+// that are identical to the ones already in storage are not uploaded again. Returns the crawl
+// stats and the outdated documents that were cleaned up after harvesting
+func (s *Sitemap) HarvestBulkSitemap(ctx context.Context, config *SitemapHarvestConfig) (pkg.SitemapCrawlStats, []string, error) {
 
 	if config.workers != 1 {
 		log.Warn("Bulk sitemaps do not allow for specifying workers, using default worker count")
 	}
 
-	if config.cleanupOutdatedJsonld {
-		log.Warn("cleanup outdated jsonld not supported currently for bulk sitemaps")
-	}
-
 	ctx, span := opentelemetry.SubSpanFromCtxWithName(ctx, fmt.Sprintf("bulk_harvest_%s", s.metadata.SitemapID))
 	defer span.End()
 
-	dockerClient, err := client.NewClientWithOpts(client.WithAPIVersionNegotiation())
-	if err != nil {
-		return pkg.SitemapCrawlStats{}, err
-	}
-	defer func() { _ = dockerClient.Close() }()
-
 	start := time.Now()
 
 	var warningStats []pkg.ShaclInfo
@@ -57,17 +58,37 @@ func (s *Sitemap) HarvestBulkSitemap(ctx context.Context, config *SitemapHarvest
 	validJsonldDocsMu := sync.Mutex{}
 
 	numNewlineSeparateJSONLDDocs := atomic.Int32{}
+	unchangedDocuments := atomic.Int32{}
+
+	// there is nothing to compare hashes against on the first harvest
+	noPreviousData, err := s.storageDestination.IsEmptyDir("summoned/" + s.metadata.SitemapID)
+	if err != nil {
+		return pkg.SitemapCrawlStats{}, nil, err
+	}
+	// the hashes of the stored documents are listed once up front
+	// instead of asking storage for the hash of every line
+	storedHashes := map[storage.ObjectPath]storage.Md5Hash{}
+	if !noPreviousData {
+		storedHashes, err = s.storageDestination.ListDirHashes("summoned/" + s.metadata.SitemapID + "/")
+		if err != nil {
+			return pkg.SitemapCrawlStats{}, nil, err
+		}
+	}
+
+	// lines that could not be harvested, such as those larger than the maximum bulk line size
+	lineErrors := newBulkLineErrors(config.bulkLineErrors)
 
-	log.Debugf("starting bulk harvest for sitemap %s with %d container urls", s.metadata.SitemapID, len(s.URL))
+	log.Debugf("starting bulk harvest for sitemap %s with %d bulk source urls", s.metadata.SitemapID, len(s.URL))
 
 	var errGroupError error = nil
 	for _, url := range s.URL {
 		// by using an error group we can make it so that if any of the container processing fails, we can immediately stop the entire harvest and return an error
 		// it is easier to keep in sync compared to channels
 		group, ctx := errgroup.WithContext(ctx)
-		group.SetLimit(2)
 
-		// channel for bulk storage
+		// documents that are read from the bulk source are first checked against storage
+		// and only the ones that changed are passed on to the channel for bulk storage
+		bulkDocumentChan := make(chan bulkDocument, 1000)
 		bulkUploadChan := make(chan storage.BulkStorageItem, 1000)
 
 		group.Go(func() error {
@@ -79,86 +100,44 @@ func (s *Sitemap) HarvestBulkSitemap(ctx context.Context, config *SitemapHarvest
 		})
 
 		group.Go(func() error {
-
 			defer close(bulkUploadChan)
+			if noPreviousData {
+				for document := range bulkDocumentChan {
+					bulkUploadChan <- document.toStorageItem()
+				}
+				return nil
+			}
+			skipUnchangedBulkDocuments(bulkDocumentChan, bulkUploadChan, storedHashes, &unchangedDocuments)
+			return nil
+		})
 
-			docker_image_name := url.Loc
-
-			if strings.Contains(docker_image_name, "/") {
+		group.Go(func() error {
 
-				log.Infof("Pulling docker image %s", docker_image_name)
-				reader, err := dockerClient.ImagePull(ctx, docker_image_name, image.PullOptions{})
-				if err != nil {
-					return err
-				}
-				defer func() { _ = reader.Close() }()
+			defer close(bulkDocumentChan)
 
-				// read the output to completion to ensure the image is pulled
-				_, err = io.ReadAll(reader)
-				if err != nil {
-					return err
-				}
-			}
-			creationResp, err := dockerClient.ContainerCreate(
-				ctx,
-				&container.Config{Image: docker_image_name},
-				&container.HostConfig{
-					LogConfig: container.LogConfig{
-						// disable Docker disk logging
-						// this makes it so the docker daemon
-						// does not log to disk and requires
-						// something to attach to it to read the logs;
-						// this is more efficient for bulk data which would otherwise
-						// overwhelm the daemon or add overhead to log to disk
-						Type: "none",
-					},
-				},
-				nil,
-				nil,
-				// no container name is specified in order to
-				// ensure the container name is unique
-				"",
-			)
+			kind, loc, err := resolveBulkSource(s.metadata.BulkSource, url.Loc)
 			if err != nil {
 				return err
 			}
-			log.Infof("Created container %s for image %s", creationResp.ID, docker_image_name)
-
-			// attach BEFORE starting; this avoids the race where the container
-			// exits and flushes stdout before we connect
-			attachResp, err := dockerClient.ContainerAttach(ctx, creationResp.ID, container.AttachOptions{
-				Stream: true,
-				Stdout: true,
-				Stderr: false,
-			})
-			if err != nil {
+			if err := checkLocalBulkSourceAllowed(kind, loc, config.allowLocalBulkSources); err != nil {
 				return err
 			}
-			defer attachResp.Close()
-
-			log.Infof("Starting container %s for image %s", creationResp.ID, docker_image_name)
-			if err = dockerClient.ContainerStart(ctx, creationResp.ID, container.StartOptions{}); err != nil {
+			log.Infof("Harvesting %s from a %s bulk source", loc, kind)
+			output, err := newBulkSource(kind, config.httpClient, config.bulkLimits).Open(ctx, loc)
+			if err != nil {
 				return err
 			}
+			// stops the source if the output isn't read to the end; closing it again below is a no-op
+			defer func() { _ = output.Close() }()
 
-			pipeReader, pipeWriter := io.Pipe()
-
-			waitResponseChan, errChan := dockerClient.ContainerWait(ctx, creationResp.ID, container.WaitConditionNotRunning)
-
-			// demux the multiplexed stdout stream
-			go func() {
-				_, err := stdcopy.StdCopy(pipeWriter, io.Discard, attachResp.Reader)
-				if err != nil {
-					log.Errorf("error demuxing container attach stream: %v", err)
-				}
-				_ = pipeWriter.Close()
-			}()
-			reader := bufio.NewReader(pipeReader)
+			reader := bufio.NewReader(output)
 			_, processSubspan := opentelemetry.SubSpanFromCtxWithName(ctx, fmt.Sprintf("process_bulk_jsonld_%s", s.metadata.SitemapID))
 			defer processSubspan.End()
 			foundEOF := false
+			lineNumber := 0
 			for !foundEOF {
-				line, err := reader.ReadBytes('\n')
+				line, tooLarge, err := readBoundedLine(reader, config.maxBulkLineBytes)
+				lineNumber++
 				if err != nil {
 					if err == io.EOF {
 						// if we've reached EOF, we need to mark it as such,
@@ -170,6 +149,19 @@ func (s *Sitemap) HarvestBulkSitemap(ctx context.Context, config *SitemapHarvest
 						return fmt.Errorf("error reading line from pipe in bulk sitemap harvest: %w", err)
 					}
 				}
+				if tooLarge {
+					// the document was never read into memory so there is no @id to report it under
+					msg := fmt.Sprintf("line %d of the output of %s exceeded the maximum bulk line size of %d bytes", lineNumber, url.Loc, config.maxBulkLineBytes)
+					log.Error(msg)
+					numNewlineSeparateJSONLDDocs.Add(1)
+					lineErrors.record(pkg.UrlCrawlError{Url: url.Loc, Message: msg, Category: pkg.DocumentTooLarge, Line: lineNumber})
+					if config.bulkLineErrors.tolerate {
+						if err := lineErrors.checkErrorRate(int(numNewlineSeparateJSONLDDocs.Load())); err != nil {
+							return err
+						}
+					}
+					continue
+				}
 				if len(bytes.TrimSpace(line)) == 0 {
 					log.Warn("found a line with no data. Skipping...")
 					continue
@@ -185,19 +177,41 @@ func (s *Sitemap) HarvestBulkSitemap(ctx context.Context, config *SitemapHarvest
 				}
 
 				var jsonObj map[string]any
+				var lineErr string
+				idStr := ""
 				if err := json.Unmarshal(line, &jsonObj); err != nil {
-					return fmt.Errorf("error unmarshaling line as JSON-LD from container logs: %w with data %s", err, string(line))
-				}
-
-				idStr, ok := jsonObj["@id"].(string)
-				if !ok {
+					if !config.bulkLineErrors.tolerate {
+						return fmt.Errorf("error unmarshaling line as JSON-LD from container logs: %w with data %s", err, string(line))
+					}
+					lineErr = fmt.Sprintf("line %d of the output of %s is not valid JSON: %v", lineNumber, url.Loc, err)
+				} else if id, ok := jsonObj["@id"].(string); ok {
+					idStr = id
+				} else {
 					log.Errorf("missing or invalid @id in JSON-LD for %s", string(line))
-					// this is a fatal error since there is no way to data the error to a specific identifier
-					// without an id; thus we return a fatal error
-					return fmt.Errorf("missing or invalid @id in JSON-LD: %s", string(line))
+					if !config.bulkLineErrors.tolerate {
+						// without an id there is no way to tie the document to a specific
+						// identifier so this is fatal unless invalid lines are tolerated
+						return fmt.Errorf("missing or invalid @id in JSON-LD: %s", string(line))
+					}
+					lineErr = fmt.Sprintf("line %d of the output of %s has a missing or invalid @id", lineNumber, url.Loc)
+				}
+				if lineErr != "" {
+					log.Error(lineErr)
+					lineErrors.record(pkg.UrlCrawlError{
+						Url:      url.Loc,
+						Message:  lineErr,
+						Category: pkg.InvalidBulkLine,
+						Line:     lineNumber,
+						Snippet:  bulkLineSnippet(line),
+					})
+					if err := lineErrors.checkErrorRate(int(totalDocuments)); err != nil {
+						return err
+					}
+					continue
 				}
 
 				encodedId := base64.StdEncoding.EncodeToString([]byte(idStr))
+				config.identifiers.recordId(s.metadata.SitemapID, url.Loc, idStr)
 
 				if config.grpcClient != nil && *config.grpcClient != nil {
 					err = validate_shacl(ctx, *config.grpcClient, url.Loc, string(line))
@@ -247,34 +261,19 @@ func (s *Sitemap) HarvestBulkSitemap(ctx context.Context, config *SitemapHarvest
 				validJsonldDocs.Add(path)
 				validJsonldDocsMu.Unlock()
 
-				// we copy the line so we have unique ownership;
-				// otherwise without it the line has shared ownership between the scanner buffer and the
-				// bulk upload goroutine
-				lineCopy := append([]byte(nil), line...)
-				bulkUploadChan <- storage.BulkStorageItem{
-					Path:       path,
-					Data:       bytes.NewReader(lineCopy),
-					ByteLength: len(lineCopy),
-				}
+				// readBoundedLine copies the line out of the reader's buffer so
+				// it can be owned by the bulk upload goroutines without another copy
+				bulkDocumentChan <- bulkDocument{path: path, data: line}
 			}
 
-			log.Infof("finished reading logs for container %s", url.Loc)
-
-			select {
-			case err := <-errChan:
-				if err != nil && err != io.EOF {
+			if config.bulkLineErrors.tolerate {
+				if err := lineErrors.checkErrorRate(int(numNewlineSeparateJSONLDDocs.Load())); err != nil {
 					return err
 				}
-			case exitResp := <-waitResponseChan:
-				err = dockerClient.ContainerRemove(ctx, creationResp.ID, container.RemoveOptions{Force: true})
-				if err != nil {
-					log.Errorf("failed to remove container %s: %v", creationResp.ID, err)
-				}
-				if exitResp.StatusCode != 0 {
-					return fmt.Errorf("container exited with status %d", exitResp.StatusCode)
-				}
 			}
-			return nil
+
+			// the source only reports whether it failed once all of its output was read
+			return output.Close()
 		})
 
 		// if any of the goroutines in the group failed, we want to return that error and stop loop
@@ -289,9 +288,51 @@ func (s *Sitemap) HarvestBulkSitemap(ctx context.Context, config *SitemapHarvest
 		}
 	}
 
-	firstTwentyWarnings := warningStats
-	if len(warningStats) > 20 {
-		firstTwentyWarnings = warningStats[:20]
+	// every line of the output is read so the @ids of the whole sitemap are known
+	if errGroupError == nil {
+		if err := config.identifiers.store(s.storageDestination, s.metadata.SitemapID); err != nil {
+			log.Errorf("Failed to store the @ids of %s: %v", s.metadata.SitemapID, err)
+		}
+	}
+
+	var sourceFailure *pkg.BulkSourceFailure
+	if failure := (pkg.BulkSourceFailure{}); errors.As(errGroupError, &failure) {
+		sourceFailure = &failure
+	}
+
+	cleanedUpFiles := []string{}
+	switch {
+	case errGroupError != nil:
+		// an incomplete harvest doesn't know every document that is still published
+	case !config.cleanupOutdatedJsonld:
+		log.Warnf("Skipping old JSON-LD cleanups. It is possible %s will contain outdated JSON-LD files", "summoned/"+s.metadata.SitemapID)
+	case lineErrors.count() > 0:
+		// a line that could not be harvested has no @id so its previous version can't be told apart from an outdated document
+		log.Warnf("Skipping old JSON-LD cleanups for %s since %d lines could not be harvested from its bulk source", s.metadata.SitemapID, lineErrors.count())
+	default:
+		var outdated []string
+		outdated, errGroupError = storage.FilesToCleanup("summoned/"+s.metadata.SitemapID, validJsonldDocs, s.storageDestination)
+		if errGroupError != nil {
+			log.Error(errGroupError)
+			break
+		}
+		// every harvested document was uploaded so the stored documents are the outdated ones and the harvested ones
+		if err := checkBulkCleanupFraction(len(outdated), len(outdated)+len(validJsonldDocs), config.maxBulkCleanupFraction); err != nil {
+			log.Warnf("Skipping old JSON-LD cleanups for %s: %v", s.metadata.SitemapID, err)
+			break
+		}
+		log.Info("Cleaning up outdated JSON-LD files in summoned/" + s.metadata.SitemapID)
+		cleanedUpFiles, errGroupError = storage.CleanupFiles("summoned/"+s.metadata.SitemapID, validJsonldDocs, s.storageDestination)
+		if errGroupError != nil {
+			log.Error(errGroupError)
+		} else {
+			log.Infof("Cleaned up %d outdated JSON-LD files in summoned/%s", len(cleanedUpFiles), s.metadata.SitemapID)
+		}
+	}
+
+	storedWarnings := warningStats
+	if len(warningStats) > config.maxShaclErrorsToStore {
+		storedWarnings = warningStats[:config.maxShaclErrorsToStore]
 	}
 	stats := pkg.SitemapCrawlStats{
 		SitemapSourceLink:  s.metadata.Loc,
@@ -299,15 +340,63 @@ func (s *Sitemap) HarvestBulkSitemap(ctx context.Context, config *SitemapHarvest
 		SitemapDescription: s.metadata.DatasetDescription,
 		WarningStats: pkg.WarningReport{
 			TotalShaclFailures: len(warningStats),
-			ShaclWarnings:      firstTwentyWarnings,
+			ShaclWarnings:      storedWarnings,
 		},
 		SecondsToComplete: time.Since(start).Seconds(),
 		SuccessfulSites:   len(validJsonldDocs),
 		SitesInSitemap:    int(numNewlineSeparateJSONLDDocs.Load()),
-		// since bulk sitemaps are run via docker images,
-		// we don't have the ability to propagate per-site crawl errors
-		CrawlFailures: []pkg.UrlCrawlError{},
+		// since bulk sitemaps are produced by a bulk source, the only per-site
+		// crawl errors are for lines that could not be harvested from the output
+		CrawlFailures:          lineErrors.stored,
+		FailedBulkLines:        lineErrors.count(),
+		UnchangedBulkDocuments: int(unchangedDocuments.Load()),
+		BulkSourceFailure:      sourceFailure,
+		Settings:               config.settings,
+	}
+
+	return stats, cleanedUpFiles, errGroupError
+}
+
+func (d bulkDocument) toStorageItem() storage.BulkStorageItem {
+	return storage.BulkStorageItem{
+		Path:       d.path,
+		Data:       bytes.NewReader(d.data),
+		ByteLength: len(d.data),
 	}
+}
+
+// Returns true if the stored document has the same md5 as the new document. If the hash
+// can't be compared, i.e. the object was uploaded in multiple parts, the document is treated as changed
+func (d bulkDocument) unchangedIn(storedHashes map[storage.ObjectPath]storage.Md5Hash) bool {
+	hash, ok := storedHashes[d.path]
+	if !ok || hash == "" {
+		return false
+	}
+	sum := md5.Sum(d.data)
+	return strings.EqualFold(strings.Trim(hash, `"`), hex.EncodeToString(sum[:]))
+}
+
+// Pass on the documents that changed since the last harvest to the uploads channel
+// and count the ones that didn't
+func skipUnchangedBulkDocuments(documents <-chan bulkDocument, uploads chan<- storage.BulkStorageItem, storedHashes map[storage.ObjectPath]storage.Md5Hash, unchanged *atomic.Int32) {
+	for document := range documents {
+		if document.unchangedIn(storedHashes) {
+			unchanged.Add(1)
+			continue
+		}
+		uploads <- document.toStorageItem()
+	}
+}
 
-	return stats, errGroupError
+// Return an error if a cleanup would remove more than maxFraction of the documents stored for a
+// bulk sitemap; a bulk source that suddenly outputs far fewer documents has most likely lost data
+// rather than retired them. A maxFraction of 0 means there is no maximum
+func checkBulkCleanupFraction(toRemove int, stored int, maxFraction float64) error {
+	if maxFraction <= 0 || stored == 0 {
+		return nil
+	}
+	if fraction := float64(toRemove) / float64(stored); fraction > maxFraction {
+		return fmt.Errorf("the cleanup would remove %d of the %d stored documents which is more than the maximum fraction of %g", toRemove, stored, maxFraction)
+	}
+	return nil
 }
@@ -5,12 +5,22 @@ package crawl
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	common "github.com/internetofwater/nabu/internal/common"
 	"github.com/internetofwater/nabu/internal/crawl/storage"
+	"github.com/internetofwater/nabu/internal/crawl/url_info"
+	"github.com/internetofwater/nabu/pkg"
 	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
 )
@@ -209,3 +219,285 @@ func TestBulkSitemapWithShaclConnectionIssueDoesntCrash(t *testing.T) {
 
 	require.Equal(t, 3, stats.SuccessfulSites, "All 3 sites should be successful in strict shacl mode")
 }
+
+// Local storage that reports the md5 of its files like the ETag in S3
+type md5TempFSStorage struct {
+	*storage.LocalTempFSCrawlStorage
+}
+
+func (m md5TempFSStorage) ListDirHashes(prefix string) (map[storage.ObjectPath]storage.Md5Hash, error) {
+	files, err := m.ListDir(prefix)
+	if err != nil {
+		return nil, err
+	}
+	hashes := map[storage.ObjectPath]storage.Md5Hash{}
+	for absPath := range files {
+		relativePath := absPath[strings.Index(absPath, prefix):]
+		reader, err := m.Get(relativePath)
+		if err != nil {
+			return nil, err
+		}
+		hasher := md5.New()
+		_, err = io.Copy(hasher, reader)
+		_ = reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		hashes[relativePath] = `"` + hex.EncodeToString(hasher.Sum(nil)) + `"`
+	}
+	return hashes, nil
+}
+
+func TestHarvestBulkSitemapSkipsUnchangedDocumentsAndCleansUp(t *testing.T) {
+	tempFS, err := storage.NewLocalTempFSCrawlStorage()
+	require.NoError(t, err)
+	crawlStorage := md5TempFSStorage{tempFS}
+
+	data, err := os.ReadFile("testdata/bulk_sitemap/data.txt")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 3)
+	ndjson := filepath.Join(t.TempDir(), "features.ndjson")
+
+	harvest := func(cleanup bool) (pkg.SitemapCrawlStats, []string) {
+		sitemap := &Sitemap{
+			URL:                []url_info.URL{{Loc: ndjson}},
+			metadata:           SitemapMetadata{SitemapID: "test_sitemap", BulkSource: "ndjson"},
+			storageDestination: crawlStorage,
+			workers:            1,
+		}
+		stats, cleanedUp, err := sitemap.Harvest(context.Background(), &SitemapHarvestConfig{
+			workers:               1,
+			storageDestination:    crawlStorage,
+			maxBulkLineBytes:      defaultMaxBulkLineBytes,
+			allowLocalBulkSources: true,
+			maxShaclErrorsToStore: 20,
+			cleanupOutdatedJsonld: cleanup,
+		})
+		require.NoError(t, err)
+		return stats, cleanedUp
+	}
+
+	require.NoError(t, os.WriteFile(ndjson, data, 0o600))
+	stats, cleanedUp := harvest(true)
+	require.Equal(t, 0, stats.UnchangedBulkDocuments, "nothing was in storage before the first harvest")
+	require.Empty(t, cleanedUp)
+
+	stats, _ = harvest(true)
+	require.Equal(t, 3, stats.UnchangedBulkDocuments)
+	require.Equal(t, 3, stats.SuccessfulSites)
+
+	// the first document is removed upstream and the last one changes
+	changed := strings.Replace(lines[2], `"@id"`, `"description":"changed","@id"`, 1)
+	require.NoError(t, os.WriteFile(ndjson, []byte(lines[1]+"\n"+changed+"\n"), 0o600))
+
+	stats, cleanedUp = harvest(false)
+	require.Equal(t, 1, stats.UnchangedBulkDocuments)
+	require.Empty(t, cleanedUp, "cleanup was not enabled")
+	stored, err := crawlStorage.ListDir("summoned/test_sitemap/")
+	require.NoError(t, err)
+	require.Len(t, stored, 3)
+
+	stats, cleanedUp = harvest(true)
+	require.Equal(t, 2, stats.UnchangedBulkDocuments)
+	require.Len(t, cleanedUp, 1, "the document that was removed upstream should be cleaned up")
+	stored, err = crawlStorage.ListDir("summoned/test_sitemap/")
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+}
+
+func TestHarvestBulkSitemapSkipsCleanupThatRemovesTooMuch(t *testing.T) {
+	crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+	require.NoError(t, err)
+
+	data, err := os.ReadFile("testdata/bulk_sitemap/data.txt")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 3)
+	ndjson := filepath.Join(t.TempDir(), "features.ndjson")
+
+	harvest := func(maxCleanupFraction float64) []string {
+		sitemap := &Sitemap{
+			URL:                []url_info.URL{{Loc: ndjson}},
+			metadata:           SitemapMetadata{SitemapID: "test_sitemap", BulkSource: "ndjson"},
+			storageDestination: crawlStorage,
+			workers:            1,
+		}
+		_, cleanedUp, err := sitemap.Harvest(context.Background(), &SitemapHarvestConfig{
+			workers:                1,
+			storageDestination:     crawlStorage,
+			maxBulkLineBytes:       defaultMaxBulkLineBytes,
+			allowLocalBulkSources:  true,
+			maxShaclErrorsToStore:  20,
+			cleanupOutdatedJsonld:  true,
+			maxBulkCleanupFraction: maxCleanupFraction,
+		})
+		require.NoError(t, err)
+		return cleanedUp
+	}
+
+	require.NoError(t, os.WriteFile(ndjson, data, 0o600))
+	require.Empty(t, harvest(defaultMaxBulkCleanupFraction))
+
+	// the source suddenly outputs only one of the three documents
+	require.NoError(t, os.WriteFile(ndjson, []byte(lines[0]+"\n"), 0o600))
+	require.Empty(t, harvest(defaultMaxBulkCleanupFraction), "removing two thirds of the documents is more than the maximum")
+	stored, err := crawlStorage.ListDir("summoned/test_sitemap/")
+	require.NoError(t, err)
+	require.Len(t, stored, 3)
+
+	require.Len(t, harvest(0), 2, "there is no maximum")
+	stored, err = crawlStorage.ListDir("summoned/test_sitemap/")
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+}
+
+func TestCheckBulkCleanupFraction(t *testing.T) {
+	require.NoError(t, checkBulkCleanupFraction(1, 2, 0.5))
+	require.Error(t, checkBulkCleanupFraction(2, 3, 0.5))
+	require.NoError(t, checkBulkCleanupFraction(3, 3, 0), "0 means there is no maximum")
+	require.NoError(t, checkBulkCleanupFraction(0, 0, 0.5))
+}
+
+func TestHarvestBulkSitemapKeepsDocumentsThatCouldNotBeRead(t *testing.T) {
+	crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+	require.NoError(t, err)
+	previous := "summoned/test_sitemap/previous.jsonld"
+	require.NoError(t, crawlStorage.StoreWithoutServersideHash(previous, strings.NewReader(`{}`)))
+
+	ndjson := filepath.Join(t.TempDir(), "features.ndjson")
+	require.NoError(t, os.WriteFile(ndjson, []byte(`{"@id": "https://example.com/1"}`+"\n"+`{"@id": "https://example.com/2", "description": "too large"}`+"\n"), 0o600))
+
+	sitemap := &Sitemap{
+		URL:                []url_info.URL{{Loc: ndjson}},
+		metadata:           SitemapMetadata{SitemapID: "test_sitemap", BulkSource: "ndjson"},
+		storageDestination: crawlStorage,
+		workers:            1,
+	}
+	stats, cleanedUp, err := sitemap.Harvest(context.Background(), &SitemapHarvestConfig{
+		workers:               1,
+		storageDestination:    crawlStorage,
+		maxBulkLineBytes:      40,
+		allowLocalBulkSources: true,
+		cleanupOutdatedJsonld: true,
+	})
+	require.NoError(t, err)
+	require.Len(t, stats.CrawlFailures, 1)
+	require.Empty(t, cleanedUp)
+	exists, err := crawlStorage.Exists(previous)
+	require.NoError(t, err)
+	require.True(t, exists, "the line that was too large may be the new version of a stored document")
+}
+
+func TestHarvestBulkSitemapToleratesInvalidLines(t *testing.T) {
+	harvest := func(t *testing.T, ndjson string, policy bulkLineErrorPolicy) (pkg.SitemapCrawlStats, error) {
+		crawlStorage, err := storage.NewLocalTempFSCrawlStorage()
+		require.NoError(t, err)
+		path := filepath.Join(t.TempDir(), "features.ndjson")
+		require.NoError(t, os.WriteFile(path, []byte(ndjson), 0o600))
+		sitemap := &Sitemap{
+			URL:                []url_info.URL{{Loc: path}},
+			metadata:           SitemapMetadata{SitemapID: "test_sitemap", BulkSource: "ndjson"},
+			storageDestination: crawlStorage,
+			workers:            1,
+		}
+		stats, _, err := sitemap.Harvest(context.Background(), &SitemapHarvestConfig{
+			workers:               1,
+			storageDestination:    crawlStorage,
+			maxBulkLineBytes:      defaultMaxBulkLineBytes,
+			allowLocalBulkSources: true,
+			bulkLineErrors:        policy,
+		})
+		return stats, err
+	}
+	const withInvalidLines = `{"@id": "https://example.com/1"}
+not json
+{"name": "no id"}
+{"@id": "https://example.com/2"}
+`
+
+	t.Run("invalid lines fail the harvest unless they are tolerated", func(t *testing.T) {
+		_, err := harvest(t, withInvalidLines, bulkLineErrorPolicy{})
+		require.ErrorContains(t, err, "error unmarshaling line as JSON-LD")
+	})
+
+	t.Run("invalid lines are recorded up to the maximum", func(t *testing.T) {
+		stats, err := harvest(t, withInvalidLines, bulkLineErrorPolicy{tolerate: true, maxErrorsToStore: 1})
+		require.NoError(t, err)
+		require.Equal(t, 4, stats.SitesInSitemap)
+		require.Equal(t, 2, stats.SuccessfulSites)
+		require.Equal(t, 2, stats.FailedBulkLines)
+		require.Len(t, stats.CrawlFailures, 1, "only the maximum number of errors should be stored")
+		failure := stats.CrawlFailures[0]
+		require.Equal(t, pkg.InvalidBulkLine, failure.Category)
+		require.Equal(t, 2, failure.Line)
+		require.Equal(t, "not json", failure.Snippet)
+		require.Contains(t, failure.Url, "features.ndjson")
+	})
+
+	t.Run("the harvest is aborted once the error rate is exceeded", func(t *testing.T) {
+		withErrorRate := func(invalidEvery int) string {
+			var lines strings.Builder
+			for i := range bulkErrorRateMinimumLines {
+				if i%invalidEvery == 0 {
+					lines.WriteString("{\"name\": \"no id\"}\n")
+				} else {
+					fmt.Fprintf(&lines, "{\"@id\": \"https://example.com/%d\"}\n", i)
+				}
+			}
+			return lines.String()
+		}
+		policy := bulkLineErrorPolicy{tolerate: true, maxErrorRate: 0.1}
+
+		_, err := harvest(t, withErrorRate(5), policy)
+		require.ErrorContains(t, err, "maximum error rate")
+
+		stats, err := harvest(t, withErrorRate(20), policy)
+		require.NoError(t, err)
+		require.Equal(t, bulkErrorRateMinimumLines/20, stats.FailedBulkLines)
+	})
+}
+
+func TestBulkLineSnippet(t *testing.T) {
+	require.Equal(t, `{"name": "no id"}`, bulkLineSnippet([]byte("  {\"name\": \"no id\"}\n")))
+	long := bulkLineSnippet([]byte(strings.Repeat("é", bulkLineSnippetBytes)))
+	require.True(t, strings.HasSuffix(long, "..."))
+	require.LessOrEqual(t, len(long), bulkLineSnippetBytes+len("..."))
+	require.True(t, utf8.ValidString(long))
+}
+
+func TestBulkLineErrorPolicyFromSitemapIndex(t *testing.T) {
+	sitemap := &Sitemap{
+		URL:      []url_info.URL{url_info.NewUrlFromString("https://example.com/docs.ndjson")},
+		metadata: SitemapMetadata{SitemapID: "test", BulkSource: "ndjson"},
+		workers:  1,
+	}
+	client := &http.Client{}
+	robots := NewRobotsCache(client, defaultRobotsTTL, nil)
+
+	config, err := SitemapIndex{}.newSitemapHarvestConfig(client, sitemap, nil, robots)
+	require.NoError(t, err)
+	require.Equal(t, defaultMaxBulkLineErrorRate, config.bulkLineErrors.maxErrorRate, "the default should be kept if no policy was set")
+
+	config, err = SitemapIndex{}.WithBulkLineErrorTolerance(true, 5, 0).newSitemapHarvestConfig(client, sitemap, nil, robots)
+	require.NoError(t, err)
+	require.Equal(t, bulkLineErrorPolicy{tolerate: true, maxErrorsToStore: 5}, config.bulkLineErrors, "a rate of 0 should mean there is no maximum")
+}
+
+func TestMaxBulkCleanupFractionFromSitemapIndex(t *testing.T) {
+	sitemap := &Sitemap{
+		URL:      []url_info.URL{url_info.NewUrlFromString("https://example.com/docs.ndjson")},
+		metadata: SitemapMetadata{SitemapID: "test", BulkSource: "ndjson"},
+		workers:  1,
+	}
+	client := &http.Client{}
+	robots := NewRobotsCache(client, defaultRobotsTTL, nil)
+
+	config, err := SitemapIndex{}.newSitemapHarvestConfig(client, sitemap, nil, robots)
+	require.NoError(t, err)
+	require.Equal(t, defaultMaxBulkCleanupFraction, config.maxBulkCleanupFraction, "the default should be kept if no fraction was set")
+
+	config, err = SitemapIndex{}.WithMaxBulkCleanupFraction(0).newSitemapHarvestConfig(client, sitemap, nil, robots)
+	require.NoError(t, err)
+	require.Zero(t, config.maxBulkCleanupFraction, "a fraction of 0 should mean there is no maximum")
+}
@@ -7,15 +7,17 @@ import (
 	"bytes"
 	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"os"
-	"sync/atomic"
+	"time"
 
+	"github.com/internetofwater/nabu/internal/crawl/headless"
 	"github.com/internetofwater/nabu/internal/crawl/storage"
 	"github.com/internetofwater/nabu/internal/opentelemetry"
+	"github.com/internetofwater/nabu/internal/protoBuild"
 	"github.com/internetofwater/nabu/pkg"
 	log "github.com/sirupsen/logrus"
 
@@ -30,14 +32,40 @@ type SitemapIndex struct {
 	// the info for all the urls in the sitemap itself is in the `Sitemap` struct
 	Sitemaps []SitemapMetadata `xml:"sitemap"`
 
-	storageDestination           storage.CrawlStorage `xml:"-"`
-	concurrentSitemaps           int                  `xml:"-"`
-	specificSourceToHarvest      string               `xml:"-"`
-	sitemapWorkers               int                  `xml:"-"`
-	headlessChromeUrl            string               `xml:"-"`
-	shaclAddress                 string               `xml:"-"`
-	outdatedJsonldCleanupEnabled bool                 `xml:"-"`
-	exitOnShaclFailure           bool                 `xml:"-"`
+	// the location the sitemap index was read from
+	loc string `xml:"-"`
+	// whether entries that are themselves sitemap indexes were already replaced by their sitemaps
+	nestedIndexesResolved bool `xml:"-"`
+
+	storageDestination             storage.CrawlStorage     `xml:"-"`
+	concurrentSitemaps             int                      `xml:"-"`
+	sources                        SourceSelection          `xml:"-"`
+	sitemapWorkers                 int                      `xml:"-"`
+	headlessChromeUrl              string                   `xml:"-"`
+	shaclAddress                   string                   `xml:"-"`
+	outdatedJsonldCleanupEnabled   bool                     `xml:"-"`
+	exitOnShaclFailure             bool                     `xml:"-"`
+	checkpointInterval             int                      `xml:"-"`
+	resumeFromCheckpoint           bool                     `xml:"-"`
+	incrementalHarvest             bool                     `xml:"-"`
+	hostCrawlDelays                map[string]time.Duration `xml:"-"`
+	recordProvenance               bool                     `xml:"-"`
+	endOfSitemapRetries            int                      `xml:"-"`
+	endOfSitemapRetryBackoff       time.Duration            `xml:"-"`
+	maxDocumentBytes               int64                    `xml:"-"`
+	maxBulkLineBytes               int64                    `xml:"-"`
+	maxDocumentSizeSet             bool                     `xml:"-"`
+	bulkLimits                     BulkSourceLimits         `xml:"-"`
+	allowLocalBulkSources          bool                     `xml:"-"`
+	bulkLineErrors                 bulkLineErrorPolicy      `xml:"-"`
+	bulkLineErrorsSet              bool                     `xml:"-"`
+	maxBulkCleanupFraction         float64                  `xml:"-"`
+	maxBulkCleanupFractionSet      bool                     `xml:"-"`
+	duplicateIdMode                DuplicateIdMode          `xml:"-"`
+	sampling                       urlSampling              `xml:"-"`
+	failedSitesToAssumeDatasetDown int                      `xml:"-"`
+	ignoreRobots                   bool                     `xml:"-"`
+	maxShaclErrorsToStore          int                      `xml:"-"`
 }
 
 // Represents the structure of <sitemap> within a <sitemapindex>
@@ -51,10 +79,35 @@ type SitemapMetadata struct {
 	AddMainstems       bool   `xml:"https://geoconnex.us add_associated_mainstems"`
 	ContactEmail       string `xml:"https://geoconnex.us contact_email"`
 	BulkContainerImage string `xml:"https://geoconnex.us bulk_container_image"`
+	// the kind of source that produces the documents for a bulk sitemap; one of
+	// docker, exec, or ndjson. If this isn't set it is chosen by the scheme of each url
+	BulkSource string `xml:"https://geoconnex.us bulk_source"`
+	// the jsonld for the sitemap is generated client side and
+	// each page must be rendered in headless chrome to get it
+	RenderJavascript bool `xml:"https://geoconnex.us render_js"`
+
+	// Settings for harvesting this sitemap that take precedence over the command line
+	// flags; a setting whose element is missing keeps the value from the flags
+	Workers *int `xml:"https://geoconnex.us workers"`
+	// one of skip, warn, or strict
+	ShaclMode string `xml:"https://geoconnex.us shacl_mode"`
+	// the minimum delay between requests to each host in the sitemap;
+	// either a duration like 500ms or a number of seconds
+	CrawlDelay string `xml:"https://geoconnex.us crawl_delay"`
+	// remove jsonld that is no longer in the sitemap after it is harvested
+	Cleanup *bool `xml:"https://geoconnex.us cleanup"`
+	// the number of failed urls in a row before the dataset is assumed to be down
+	DatasetDownThreshold *int `xml:"https://geoconnex.us dataset_down_threshold"`
+	// the maximum number of shacl errors to store in the crawl report
+	MaxShaclErrorsToStore *int `xml:"https://geoconnex.us max_shacl_errors_to_store"`
+
+	// the id of the entry in the top level of the sitemap index that this sitemap was
+	// found in if that entry is a nested sitemap index; empty for every other sitemap
+	topLevelSitemapId string
 }
 
 func (s SitemapMetadata) IsBulkSitemap() bool {
-	return s.BulkContainerImage != ""
+	return s.BulkContainerImage != "" || s.BulkSource != ""
 }
 
 func isUrl(str string) bool {
@@ -66,24 +119,11 @@ func NewSitemapIndex(sitemapIndexURL string, client *http.Client) (SitemapIndex,
 
 	serializedSitemapIndex := SitemapIndex{}
 
-	var sitemapData io.Reader
-
-	if isUrl(sitemapIndexURL) {
-
-		res, err := client.Get(sitemapIndexURL)
-		if err != nil {
-			return serializedSitemapIndex, err
-		}
-		defer func() { _ = res.Body.Close() }()
-		sitemapData = res.Body
-	} else {
-		sitemapFile, err := os.Open(sitemapIndexURL)
-		if err != nil {
-			return serializedSitemapIndex, err
-		}
-		defer func() { _ = sitemapFile.Close() }()
-		sitemapData = sitemapFile
+	sitemapData, err := openSitemapLocation(client, sitemapIndexURL)
+	if err != nil {
+		return serializedSitemapIndex, err
 	}
+	defer func() { _ = sitemapData.Close() }()
 
 	asBytes, err := io.ReadAll(sitemapData)
 	if err != nil {
@@ -104,6 +144,8 @@ func NewSitemapIndex(sitemapIndexURL string, client *http.Client) (SitemapIndex,
 		}
 	}
 
+	serializedSitemapIndex.loc = sitemapIndexURL
+
 	return serializedSitemapIndex, err
 
 }
@@ -125,6 +167,56 @@ func (i SitemapIndex) GetMetadataForSitemapId(sitemapId string) (SitemapMetadata
 	return SitemapMetadata{}, fmt.Errorf("no sitemap found with id %s", sitemapId)
 }
 
+// Make the robots.txt cache shared by every sitemap in a harvest; the Crawl-delay
+// of each host is applied to a rate limiter that is also shared by every sitemap
+func (i SitemapIndex) newRobotsCache(client *http.Client) *RobotsCache {
+	return NewRobotsCache(client, defaultRobotsTTL, NewHostRateLimiter(i.hostCrawlDelays))
+}
+
+// Make the harvest config for a sitemap with all the
+// options that were set on the sitemap index
+func (i SitemapIndex) newSitemapHarvestConfig(client *http.Client, sitemap *Sitemap, shaclGRPCClient protoBuild.ShaclValidatorClient, robotsCache *RobotsCache) (SitemapHarvestConfig, error) {
+	// use the limiter and robots.txt cache shared by all sitemaps so that sitemaps
+	// on the same host don't each get their own request budget
+	robots := robotsCache
+	if i.ignoreRobots {
+		robots = nil
+	}
+	config, err := newSitemapHarvestConfigWithRobots(client, sitemap, shaclGRPCClient, i.exitOnShaclFailure, i.outdatedJsonldCleanupEnabled, robots, robotsCache.rateLimiter)
+	if err != nil {
+		return SitemapHarvestConfig{}, err
+	}
+	if sitemap.metadata.RenderJavascript {
+		if i.headlessChromeUrl == "" {
+			return SitemapHarvestConfig{}, fmt.Errorf("sitemap %s requires javascript rendering but no headless chrome url was set", sitemap.metadata.SitemapID)
+		}
+		config.renderer = headless.NewChromeRenderer(i.headlessChromeUrl, client)
+	}
+	config.checkpointInterval = i.checkpointInterval
+	config.resumeFromCheckpoint = i.resumeFromCheckpoint
+	config.skipUnchangedLastMod = i.incrementalHarvest
+	config.recordProvenance = i.recordProvenance
+	config.endOfSitemapRetries = i.endOfSitemapRetries
+	config.endOfSitemapRetryBackoff = i.endOfSitemapRetryBackoff
+	// the defaults from NewSitemapHarvestConfig are kept unless the sizes were set
+	if i.maxDocumentSizeSet {
+		config.maxDocumentBytes = i.maxDocumentBytes
+		config.maxBulkLineBytes = i.maxBulkLineBytes
+	}
+	config.bulkLimits = i.bulkLimits
+	config.allowLocalBulkSources = i.allowLocalBulkSources
+	if i.bulkLineErrorsSet {
+		config.bulkLineErrors = i.bulkLineErrors
+	}
+	if i.maxBulkCleanupFractionSet {
+		config.maxBulkCleanupFraction = i.maxBulkCleanupFraction
+	}
+	if err := i.applySitemapSettings(&config, sitemap); err != nil {
+		return SitemapHarvestConfig{}, err
+	}
+	return config, nil
+}
+
 func (i SitemapIndex) HarvestSitemaps(ctx context.Context, client *http.Client) (pkg.SitemapIndexCrawlStats, error) {
 
 	if i.concurrentSitemaps < 1 {
@@ -137,21 +229,42 @@ func (i SitemapIndex) HarvestSitemaps(ctx context.Context, client *http.Client)
 	var group errgroup.Group
 	group.SetLimit(i.concurrentSitemaps)
 
-	var wasFound atomic.Bool
-	wasFound.Store(i.specificSourceToHarvest == "")
+	robotsCache := i.newRobotsCache(client)
 
-	crawlStatChan := make(chan pkg.SitemapCrawlStats, len(i.Sitemaps))
+	i, err := i.resolveNestedIndexes(ctx, client, robotsCache, i.sources)
+	if err != nil {
+		return pkg.SitemapIndexCrawlStats{}, err
+	}
+	selected, err := i.SelectedSitemaps()
+	if err != nil {
+		return pkg.SitemapIndexCrawlStats{}, err
+	}
 
-	for _, sitemap := range i.Sitemaps {
+	crawlStatChan := make(chan pkg.SitemapCrawlStats, len(selected))
+
+	var identifiers *identifierIndex
+	if i.duplicateIdMode != "" && i.duplicateIdMode != DuplicateIdsOff {
+		identifiers = newIdentifierIndex()
+	}
+
+	for _, sitemap := range selected {
 		group.Go(func() error {
 
 			id := sitemap.SitemapID
 
-			if i.specificSourceToHarvest != "" && id != i.specificSourceToHarvest {
-				log.Debugf("Skipped sitemap with id %s", id)
-				return nil
-			} else {
-				wasFound.Store(true)
+			if i.incrementalHarvest {
+				unchanged, err := sitemapUnchangedSinceLastHarvest(i.storageDestination, sitemap)
+				if err != nil {
+					return err
+				}
+				if unchanged {
+					log.Infof("Skipping sitemap %s since its lastmod %s has not changed since the last harvest", id, sitemap.LastMod)
+					if err := identifiers.restoreSkipped(i.storageDestination, id, nil); err != nil {
+						log.Errorf("Failed to restore the @ids of skipped sitemap %s: %v", id, err)
+					}
+					crawlStatChan <- unchangedSitemapStats(sitemap)
+					return nil
+				}
 			}
 
 			log.Infof("Parsing sitemap %s", sitemap.Loc)
@@ -159,15 +272,17 @@ func (i SitemapIndex) HarvestSitemaps(ctx context.Context, client *http.Client)
 			if err != nil {
 				return err
 			}
+			i.sample(sitemap)
 			shaclGRPCClient, err := NewShaclGrpcClientFromAddr(i.shaclAddress)
 			if err != nil {
 				return err
 			}
 
-			config, err := NewSitemapHarvestConfig(client, sitemap, shaclGRPCClient, i.exitOnShaclFailure, i.outdatedJsonldCleanupEnabled)
+			config, err := i.newSitemapHarvestConfig(client, sitemap, shaclGRPCClient, robotsCache)
 			if err != nil {
 				return err
 			}
+			config.identifiers = identifiers
 
 			stats, _, harvestErr := sitemap.
 				Harvest(ctx, &config)
@@ -182,10 +297,6 @@ func (i SitemapIndex) HarvestSitemaps(ctx context.Context, client *http.Client)
 		return pkg.SitemapIndexCrawlStats{}, err
 	}
 
-	if !wasFound.Load() {
-		return pkg.SitemapIndexCrawlStats{}, fmt.Errorf("no sitemap found with id %s", i.specificSourceToHarvest)
-	}
-
 	// we close this here to make sure we can range without blocking
 	// We know we can close this since we have already waited on all go routines
 	close(crawlStatChan)
@@ -194,12 +305,51 @@ func (i SitemapIndex) HarvestSitemaps(ctx context.Context, client *http.Client)
 		allStats = append(allStats, stats)
 	}
 
+	if identifiers != nil {
+		if err := i.reportDuplicateIdentifiers(identifiers); err != nil {
+			return allStats, err
+		}
+	}
+
 	return allStats, nil
 }
 
+// Store the report of @ids published by more than one sitemap in the metadata
+// bucket; duplicates are only an error if the index is configured to treat them as such
+func (i SitemapIndex) reportDuplicateIdentifiers(identifiers *identifierIndex) error {
+	report := identifiers.report()
+	asJson, err := report.ToJsonIoReader()
+	if err != nil {
+		return err
+	}
+	if err := i.storageDestination.StoreMetadata(duplicateIdentifiersReportPath, asJson); err != nil {
+		return err
+	}
+	if len(report.Duplicates) == 0 {
+		log.Infof("No duplicate @ids found among %d indexed @ids", report.IdentifiersIndexed)
+		return nil
+	}
+	for _, duplicate := range report.Duplicates {
+		log.Warnf("@id %s was published by sitemaps %v at %v", duplicate.Id, duplicate.Sitemaps, duplicate.Urls)
+	}
+	msg := fmt.Sprintf("found %d @ids published by more than one sitemap; see %s", len(report.Duplicates), duplicateIdentifiersReportPath)
+	if i.duplicateIdMode == DuplicateIdsError {
+		return errors.New(msg)
+	}
+	log.Warn(msg)
+	return nil
+}
+
 // Harvest one particular sitemap
 func (i SitemapIndex) HarvestSitemap(ctx context.Context, client *http.Client, sitemapIdentifier string) (pkg.SitemapCrawlStats, error) {
 
+	// only the entry that may hold the sitemap is checked for a nested index
+	robotsCache := i.newRobotsCache(client)
+	i, err := i.resolveNestedIndexes(ctx, client, robotsCache, SourceSelection{Include: []string{sitemapIdentifier}})
+	if err != nil {
+		return pkg.SitemapCrawlStats{}, err
+	}
+
 	for _, part := range i.Sitemaps {
 
 		if part.SitemapID != sitemapIdentifier {
@@ -208,17 +358,29 @@ func (i SitemapIndex) HarvestSitemap(ctx context.Context, client *http.Client, s
 		ctx, span := opentelemetry.SubSpanFromCtxWithName(ctx, fmt.Sprintf("sitemap_harvest_%s", sitemapIdentifier))
 		defer span.End()
 
+		if i.incrementalHarvest {
+			unchanged, err := sitemapUnchangedSinceLastHarvest(i.storageDestination, part)
+			if err != nil {
+				return pkg.SitemapCrawlStats{}, err
+			}
+			if unchanged {
+				log.Infof("Skipping sitemap %s since its lastmod %s has not changed since the last harvest", sitemapIdentifier, part.LastMod)
+				return unchangedSitemapStats(part), nil
+			}
+		}
+
 		sitemap, err := NewSitemap(ctx, client, i.sitemapWorkers, i.storageDestination, part)
 		if err != nil {
 			return pkg.SitemapCrawlStats{}, err
 		}
+		i.sample(sitemap)
 
 		shaclGRPCClient, err := NewShaclGrpcClientFromAddr(i.shaclAddress)
 		if err != nil {
 			return pkg.SitemapCrawlStats{}, err
 		}
 
-		config, err := NewSitemapHarvestConfig(client, sitemap, shaclGRPCClient, i.exitOnShaclFailure, i.outdatedJsonldCleanupEnabled)
+		config, err := i.newSitemapHarvestConfig(client, sitemap, shaclGRPCClient, robotsCache)
 
 		if err != nil {
 			return pkg.SitemapCrawlStats{}, err
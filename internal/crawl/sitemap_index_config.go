@@ -4,6 +4,8 @@
 package crawl
 
 import (
+	"time"
+
 	"github.com/internetofwater/nabu/internal/crawl/storage"
 
 	log "github.com/sirupsen/logrus"
@@ -47,7 +49,17 @@ func (i SitemapIndex) WithSpecifiedSourceFilter(sourceToHarvest string) SitemapI
 	// If a sitemap with this id is found, it will be harvested
 	// otherwise it will be skipped. If the id is an empty string
 	// it will harvest all sitemaps
-	i.specificSourceToHarvest = sourceToHarvest
+	i.sources = SourceSelection{}
+	if sourceToHarvest != "" {
+		i.sources.Include = []string{sourceToHarvest}
+	}
+	return i
+}
+
+// Select the sitemaps to harvest by ids or glob patterns to include and exclude;
+// this replaces any source filter that was set before
+func (i SitemapIndex) WithSourceSelection(selection SourceSelection) SitemapIndex {
+	i.sources = selection
 	return i
 }
 
@@ -55,3 +67,136 @@ func (i SitemapIndex) WithHeadlessChromeUrl(url string) SitemapIndex {
 	i.headlessChromeUrl = url
 	return i
 }
+
+// Write a checkpoint to storage every checkpointInterval successfully harvested urls
+// so that a failed harvest can be resumed; 0 disables checkpoints. If resume is set,
+// urls that were harvested before the last checkpoint are skipped
+func (i SitemapIndex) WithCheckpointConfig(checkpointInterval int, resume bool) SitemapIndex {
+	if checkpointInterval < 0 {
+		log.Warnf("checkpoint interval is set to %d which is less than 0, so disabling checkpoints", checkpointInterval)
+		checkpointInterval = 0
+	}
+	i.checkpointInterval = checkpointInterval
+	i.resumeFromCheckpoint = resume
+	return i
+}
+
+// Skip urls, and entire sitemaps, whose lastmod has not
+// advanced since they were last successfully harvested
+func (i SitemapIndex) WithIncrementalHarvest(enabled bool) SitemapIndex {
+	i.incrementalHarvest = enabled
+	return i
+}
+
+// Set the delay between requests for specific hosts; i.e. "geoconnex.us" -> 1s
+// These take precedence over the Crawl-delay in the robots.txt of the host
+func (i SitemapIndex) WithHostCrawlDelays(hostCrawlDelays map[string]time.Duration) SitemapIndex {
+	i.hostCrawlDelays = hostCrawlDelays
+	return i
+}
+
+// Skip checking urls against robots.txt; the Crawl-delay of each host is then
+// ignored as well but the delays set with WithHostCrawlDelays still apply
+func (i SitemapIndex) WithIgnoreRobots(ignore bool) SitemapIndex {
+	i.ignoreRobots = ignore
+	return i
+}
+
+// Store a PROV-O record of when and where each harvested document was
+// fetched in prov/ so that it can be released as a provenance graph
+func (i SitemapIndex) WithProvenance(enabled bool) SitemapIndex {
+	i.recordProvenance = enabled
+	return i
+}
+
+// Once the rest of a sitemap is harvested, attempt urls that failed with a timeout or
+// a 5xx response again up to retries times, with fewer workers and a backoff that
+// doubles from the given one before each round; 0 retries disables this
+func (i SitemapIndex) WithEndOfSitemapRetries(retries int, backoff time.Duration) SitemapIndex {
+	if retries < 0 {
+		log.Warnf("end of sitemap retries is set to %d which is less than 0, so disabling retries", retries)
+		retries = 0
+	}
+	i.endOfSitemapRetries = retries
+	i.endOfSitemapRetryBackoff = backoff
+	return i
+}
+
+// Set the maximum size of a document fetched from a single url and the maximum size of a
+// single document output by a bulk container; larger documents are reported as crawl
+// failures without being read into memory. A size of 0 means there is no maximum; the
+// defaults of 32 MiB and 256 MiB are only used if this is never called
+func (i SitemapIndex) WithMaxDocumentSize(maxDocumentBytes int64, maxBulkLineBytes int64) SitemapIndex {
+	i.maxDocumentBytes = maxDocumentBytes
+	i.maxBulkLineBytes = maxBulkLineBytes
+	i.maxDocumentSizeSet = true
+	return i
+}
+
+// Index the top level @id of every harvested document and report the ones that were
+// published by more than one sitemap to the metadata bucket, since their triples would
+// be merged in the graph. Depending on the mode, duplicates are warnings or fail the harvest
+func (i SitemapIndex) WithDuplicateIdDetection(mode DuplicateIdMode) SitemapIndex {
+	i.duplicateIdMode = mode
+	return i
+}
+
+// Harvest only a random or stratified sample of size urls from each sitemap and at most maxUrls
+// of them, i.e. to smoke test a provider that is being onboarded; 0 disables either limit.
+// The crawl report of a sample is marked as such and a sample never cleans up outdated jsonld
+func (i SitemapIndex) WithSampling(size int, strategy SampleStrategy, maxUrls int) SitemapIndex {
+	if size < 0 || maxUrls < 0 {
+		log.Warnf("sample size %d or maximum urls %d is less than 0, so disabling sampling", size, maxUrls)
+		size, maxUrls = 0, 0
+	}
+	i.sampling = urlSampling{size: size, strategy: strategy, maxUrls: maxUrls}
+	return i
+}
+
+// Set the number of failed urls in a row before a dataset is assumed to be down and the
+// maximum number of shacl errors stored in each crawl report; 0 keeps the default. A
+// sitemap can override either of these with its own element in the sitemap index
+func (i SitemapIndex) WithErrorThresholds(failedSitesToAssumeDatasetDown int, maxShaclErrorsToStore int) SitemapIndex {
+	i.failedSitesToAssumeDatasetDown = failedSitesToAssumeDatasetDown
+	i.maxShaclErrorsToStore = maxShaclErrorsToStore
+	return i
+}
+
+// Limit the memory, cpus and processes of the containers that produce the documents of bulk
+// sitemaps and kill any container or executable that runs longer than the maximum runtime. The
+// end of the stderr of a source that fails is kept in the crawl report; 0 keeps the default size
+func (i SitemapIndex) WithBulkSourceLimits(limits BulkSourceLimits) SitemapIndex {
+	i.bulkLimits = limits
+	return i
+}
+
+// Allow bulk sitemaps to run local executables with exec and to read local files with
+// ndjson; these are refused by default since the sitemap index decides what is run or read
+func (i SitemapIndex) WithLocalBulkSources(allowed bool) SitemapIndex {
+	i.allowLocalBulkSources = allowed
+	return i
+}
+
+// Record lines in the output of a bulk source that aren't valid JSON or have no @id as crawl
+// errors and continue instead of failing the harvest. At most maxErrorsToStore of them are kept in
+// the crawl report and the harvest is still aborted if more than maxErrorRate of the lines fail.
+// A maxErrorsToStore of 0 keeps the default of 100 and a maxErrorRate of 0 means there is no
+// maximum; the default rate of 0.1 is only used if this is never called
+func (i SitemapIndex) WithBulkLineErrorTolerance(tolerate bool, maxErrorsToStore int, maxErrorRate float64) SitemapIndex {
+	i.bulkLineErrors = bulkLineErrorPolicy{
+		tolerate:         tolerate,
+		maxErrorsToStore: maxErrorsToStore,
+		maxErrorRate:     maxErrorRate,
+	}
+	i.bulkLineErrorsSet = true
+	return i
+}
+
+// Skip the cleanup of a bulk sitemap if it would remove more than maxFraction of the documents
+// stored for it, since a bulk source that outputs far fewer documents than before has most likely
+// lost data. A maxFraction of 0 means there is no maximum; the default of 0.5 is only used if this is never called
+func (i SitemapIndex) WithMaxBulkCleanupFraction(maxFraction float64) SitemapIndex {
+	i.maxBulkCleanupFraction = maxFraction
+	i.maxBulkCleanupFractionSet = true
+	return i
+}
@@ -4,12 +4,21 @@
 package crawl
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/internetofwater/nabu/internal/common"
 	"github.com/internetofwater/nabu/internal/crawl/storage"
+	"github.com/internetofwater/nabu/internal/crawl/url_info"
 	"github.com/internetofwater/nabu/internal/synchronizer/s3"
 
 	"github.com/stretchr/testify/assert"
@@ -134,3 +143,177 @@ func TestSitemapInsteadOfSitemapIndex(t *testing.T) {
 	_, err := NewSitemapIndex("testdata/sitemap.xml", http.DefaultClient)
 	require.Error(t, err)
 }
+
+// gzip a string so it can be served like a .xml.gz sitemap
+func gzipString(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err := writer.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+func TestNestedGzipSitemapIndex(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			_, _ = fmt.Fprintf(w, `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:geoconnex="https://geoconnex.us">
+				<sitemap>
+					<loc>%s/provider/index.xml.gz</loc>
+					<geoconnex:sitemap_id>provider</geoconnex:sitemap_id>
+					<geoconnex:dataset_description>A provider with a nested index</geoconnex:dataset_description>
+				</sitemap>
+			</sitemapindex>`, server.URL)
+		case "/provider/index.xml.gz":
+			w.Header().Set("Content-Type", "application/gzip")
+			_, _ = w.Write(gzipString(t, fmt.Sprintf(`<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+				<sitemap><loc>%s/provider/stations.xml.gz</loc></sitemap>
+				<sitemap><loc>%s/provider/wells.xml</loc></sitemap>
+			</sitemapindex>`, server.URL, server.URL)))
+		case "/provider/stations.xml.gz":
+			_, _ = w.Write(gzipString(t, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+				<url><loc>https://example.com/stations/1</loc></url>
+				<url><loc>https://example.com/stations/2</loc></url>
+			</urlset>`))
+		case "/provider/wells.xml":
+			_, _ = w.Write([]byte(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+				<url><loc>https://example.com/wells/1</loc></url>
+			</urlset>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	index, err := NewSitemapIndex(server.URL+"/sitemap.xml", server.Client())
+	require.NoError(t, err)
+	require.Len(t, index.Sitemaps, 1, "nested indexes should not be resolved when the index is parsed")
+
+	index, err = index.ResolveNestedIndexes(server.Client())
+	require.NoError(t, err)
+	require.Len(t, index.Sitemaps, 2)
+
+	stations, err := index.GetMetadataForSitemapId("provider:stations")
+	require.NoError(t, err)
+	require.Equal(t, "A provider with a nested index", stations.DatasetDescription, "metadata should be inherited from the parent")
+	_, err = index.GetMetadataForSitemapId("provider:wells")
+	require.NoError(t, err)
+
+	// the id of a nested sitemap is used like geoconnex ids such as ref:gages__0
+	storagePath, err := url_info.SummonedPath(stations.SitemapID, url_info.URL{Base64Loc: "c3RhdGlvbnMvMQ"})
+	require.NoError(t, err)
+	require.Equal(t, "summoned/provider:stations/c3RhdGlvbnMvMQ.jsonld", storagePath)
+	urn, err := common.MakeURN(storagePath)
+	require.NoError(t, err)
+	require.Equal(t, "urn:iow:summoned:provider:stations:c3RhdGlvbnMvMQ.jsonld", urn)
+
+	sitemap, err := NewSitemap(context.Background(), server.Client(), 1, &storage.LocalTempFSCrawlStorage{}, stations)
+	require.NoError(t, err)
+	require.Len(t, sitemap.URL, 2)
+	require.Equal(t, "https://example.com/stations/1", sitemap.URL[0].Loc)
+}
+
+func TestNestedSitemapIndexCycle(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			_, _ = fmt.Fprintf(w, `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:geoconnex="https://geoconnex.us">
+				<sitemap>
+					<loc>%s/nested.xml</loc>
+					<geoconnex:sitemap_id>nested</geoconnex:sitemap_id>
+				</sitemap>
+			</sitemapindex>`, server.URL)
+		case "/nested.xml":
+			_, _ = fmt.Fprintf(w, `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+				<sitemap><loc>%s/sitemap.xml</loc></sitemap>
+			</sitemapindex>`, server.URL)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	index, err := NewSitemapIndex(server.URL+"/sitemap.xml", server.Client())
+	require.NoError(t, err)
+	_, err = index.ResolveNestedIndexes(server.Client())
+	require.ErrorContains(t, err, "cycle")
+}
+
+func TestResolvingNestedIndexesOnlyFetchesSelectedEntries(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/robots.txt":
+			_, _ = w.Write([]byte("User-agent: *\nAllow: /\n"))
+		case "/sitemap.xml":
+			_, _ = fmt.Fprintf(w, `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:geoconnex="https://geoconnex.us">
+				<sitemap><loc>%s/a/index.xml</loc><geoconnex:sitemap_id>a</geoconnex:sitemap_id></sitemap>
+				<sitemap><loc>%s/b/index.xml</loc><geoconnex:sitemap_id>b</geoconnex:sitemap_id></sitemap>
+				<sitemap><loc>%s/c.xml</loc><geoconnex:sitemap_id>c</geoconnex:sitemap_id></sitemap>
+			</sitemapindex>`, server.URL, server.URL, server.URL)
+		case "/a/index.xml", "/b/index.xml":
+			_, _ = fmt.Fprintf(w, `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+				<sitemap><loc>%s%sstations.xml</loc></sitemap>
+			</sitemapindex>`, server.URL, r.URL.Path[:len(r.URL.Path)-len("index.xml")])
+		default:
+			_, _ = w.Write([]byte(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"></urlset>`))
+		}
+	}))
+	defer server.Close()
+
+	index, err := NewSitemapIndex(server.URL+"/sitemap.xml", server.Client())
+	require.NoError(t, err)
+
+	resolved, err := index.WithSourceSelection(SourceSelection{Include: []string{"a:*"}}).ResolveNestedIndexes(server.Client())
+	require.NoError(t, err)
+	ids := []string{}
+	for _, sitemap := range resolved.Sitemaps {
+		ids = append(ids, sitemap.SitemapID)
+	}
+	require.Equal(t, []string{"a:stations", "b", "c"}, ids)
+	require.Equal(t, 1, requests["/a/index.xml"])
+	require.Zero(t, requests["/b/index.xml"], "an entry that isn't selected should not be fetched")
+	require.Zero(t, requests["/c.xml"])
+	require.Equal(t, 1, requests["/robots.txt"], "the probes should use the robots.txt cache so its Crawl-delay applies")
+
+	t.Run("probes wait on the host rate limiter", func(t *testing.T) {
+		const delay = 200 * time.Millisecond
+		host := strings.TrimPrefix(server.URL, "http://")
+		start := time.Now()
+		_, err := index.WithHostCrawlDelays(map[string]time.Duration{host: delay}).ResolveNestedIndexes(server.Client())
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, time.Since(start), 2*delay, "each probe should wait a crawl delay after the previous one")
+	})
+}
+
+func TestParsingSitemapIndexDoesNotFetchSitemaps(t *testing.T) {
+	var requests atomic.Int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_, _ = fmt.Fprintf(w, `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:geoconnex="https://geoconnex.us">
+			<sitemap><loc>%s/a.xml</loc><geoconnex:sitemap_id>a</geoconnex:sitemap_id></sitemap>
+			<sitemap><loc>%s/b.xml</loc><geoconnex:sitemap_id>b</geoconnex:sitemap_id></sitemap>
+		</sitemapindex>`, server.URL, server.URL)
+	}))
+	defer server.Close()
+
+	index, err := NewSitemapIndex(server.URL+"/sitemap.xml", server.Client())
+	require.NoError(t, err)
+	require.Len(t, index.Sitemaps, 2)
+	require.Equal(t, int32(1), requests.Load(), "only the index itself should be fetched")
+}
+
+func TestSitemapNameFromLoc(t *testing.T) {
+	require.Equal(t, "stations__5", sitemapNameFromLoc("https://geoconnex.us/sitemap/iow/wqp/stations__5.xml"))
+	require.Equal(t, "wells", sitemapNameFromLoc("https://example.com/sitemaps/wells.xml.gz"))
+	require.Equal(t, "", sitemapNameFromLoc("https://example.com/"))
+}
@@ -0,0 +1,301 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// The maximum number of sitemap indexes that may be nested within each other
+const maxSitemapIndexDepth = 5
+
+// Separates the id of a nested sitemap index from the name of each sitemap within it, i.e. provider:stations.
+// Sitemap ids in geoconnex already use it, i.e. ref:gages__0, so it is safe in storage paths, urns, and graph names
+const NestedSitemapIdSeparator = ":"
+
+// The number of sitemaps to check concurrently when resolving nested sitemap indexes
+const concurrentSitemapProbes = 10
+
+// Closes both the gzip reader and the underlying body
+type gzipReadCloser struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+func (g gzipReadCloser) Close() error {
+	return errors.Join(g.Reader.Close(), g.body.Close())
+}
+
+// Return true if the location or content type says the data is gzip compressed
+func declaredAsGzip(loc string, contentType string) bool {
+	if parsed, err := url.Parse(loc); err == nil && strings.HasSuffix(strings.ToLower(parsed.Path), ".gz") {
+		return true
+	}
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	return mediaType == "application/gzip" || mediaType == "application/x-gzip"
+}
+
+// Wrap the body in a gzip reader if it is gzip compressed.
+// The gzip magic bytes are checked since an http transport may have already
+// decompressed a .xml.gz file, and some servers send gzip with an xml extension
+func decompressIfGzipped(body io.ReadCloser, loc string, contentType string) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(body)
+	magic, _ := buffered.Peek(2)
+	isGzip := len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b
+
+	if !isGzip {
+		if declaredAsGzip(loc, contentType) {
+			log.Debugf("%s was declared as gzip but is not compressed; assuming it was already decompressed", loc)
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{buffered, body}, nil
+	}
+
+	gzipReader, err := gzip.NewReader(buffered)
+	if err != nil {
+		_ = body.Close()
+		return nil, fmt.Errorf("failed to decompress %s: %w", loc, err)
+	}
+	return gzipReadCloser{Reader: gzipReader, body: body}, nil
+}
+
+// Open a sitemap or sitemap index from either a url or a local file,
+// transparently decompressing it if it is gzipped
+func openSitemapLocation(client *http.Client, loc string) (io.ReadCloser, error) {
+	if !isUrl(loc) {
+		file, err := os.Open(loc)
+		if err != nil {
+			return nil, err
+		}
+		return decompressIfGzipped(file, loc, "")
+	}
+
+	res, err := client.Get(loc)
+	if err != nil {
+		return nil, err
+	}
+	return decompressIfGzipped(res.Body, loc, res.Header.Get("Content-Type"))
+}
+
+// Fetch a sitemap and, if it is actually a sitemap index, return the sitemaps within it.
+// Only the root element is read for regular sitemaps so this is cheap even for large sitemaps
+func probeForNestedIndex(client *http.Client, loc string) ([]SitemapMetadata, bool, error) {
+	body, err := openSitemapLocation(client, loc)
+	if err != nil {
+		return nil, false, err
+	}
+	defer func() { _ = body.Close() }()
+
+	decoder := xml.NewDecoder(body)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to find the root element of %s: %w", loc, err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Local != "sitemapindex" {
+			return nil, false, nil
+		}
+		var nested struct {
+			Sitemaps []SitemapMetadata `xml:"sitemap"`
+		}
+		if err := decoder.DecodeElement(&nested, &start); err != nil {
+			return nil, true, fmt.Errorf("failed to decode nested sitemap index %s: %w", loc, err)
+		}
+		return nested.Sitemaps, true, nil
+	}
+}
+
+// Fetches sitemaps to check whether they are nested sitemap indexes. Each probe waits on the host rate
+// limiter shared by the harvest, and the robots.txt of the host is fetched first so its Crawl-delay applies
+type sitemapProber struct {
+	client *http.Client
+	// the robots.txt cache shared by every sitemap in the harvest; nil to send probes without rate limiting
+	robots *RobotsCache
+	// whether robots.txt is ignored and thus its Crawl-delay isn't applied
+	ignoreRobots bool
+}
+
+// Wait until the host of the sitemap may be sent a request and then probe it
+func (p sitemapProber) probe(ctx context.Context, loc string) ([]SitemapMetadata, bool, error) {
+	if p.robots != nil && isUrl(loc) {
+		if !p.ignoreRobots {
+			if _, err := p.robots.lookup(loc); err != nil {
+				return nil, false, err
+			}
+		}
+		if p.robots.rateLimiter != nil {
+			if _, err := p.robots.rateLimiter.Wait(ctx, loc); err != nil {
+				return nil, false, err
+			}
+		}
+	}
+	return probeForNestedIndex(p.client, loc)
+}
+
+// Get the name of a sitemap from its location; i.e. https://example.com/sitemaps/stations.xml.gz -> stations
+func sitemapNameFromLoc(loc string) string {
+	name := loc
+	if parsed, err := url.Parse(loc); err == nil && parsed.Path != "" {
+		name = parsed.Path
+	}
+	name = path.Base(name)
+	name = strings.TrimSuffix(name, ".gz")
+	name = strings.TrimSuffix(name, ".xml")
+	if name == "." || name == "/" {
+		return ""
+	}
+	return name
+}
+
+// Make the metadata for a sitemap within a nested sitemap index. The sitemap id is
+// qualified by the id of the parent and any geoconnex metadata the child doesn't
+// set is inherited from the parent
+func inheritSitemapMetadata(parent SitemapMetadata, child SitemapMetadata, position int, usedNames map[string]bool) SitemapMetadata {
+	name := child.SitemapID
+	if name == "" {
+		name = sitemapNameFromLoc(child.Loc)
+	}
+	if name == "" {
+		name = strconv.Itoa(position)
+	} else if usedNames[name] {
+		name = fmt.Sprintf("%s__%d", name, position)
+	}
+	usedNames[name] = true
+	child.SitemapID = parent.SitemapID + NestedSitemapIdSeparator + name
+	child.topLevelSitemapId = parent.topLevelSitemapId
+	if child.topLevelSitemapId == "" {
+		child.topLevelSitemapId = parent.SitemapID
+	}
+
+	if child.DatasetDescription == "" {
+		child.DatasetDescription = parent.DatasetDescription
+	}
+	if child.DocumentationLink == "" {
+		child.DocumentationLink = parent.DocumentationLink
+	}
+	if child.ContactEmail == "" {
+		child.ContactEmail = parent.ContactEmail
+	}
+	child.AddMainstems = child.AddMainstems || parent.AddMainstems
+	child.RenderJavascript = child.RenderJavascript || parent.RenderJavascript
+	return child
+}
+
+// Replace every sitemap in the list that is actually a sitemap index with the sitemaps within it.
+// ancestors are the locations of the indexes that led to these sitemaps and are used to detect cycles.
+// Sitemaps that shouldProbe returns false for are kept as they are without being fetched; if it is nil every one is probed
+func resolveNestedSitemapIndexes(ctx context.Context, prober sitemapProber, sitemaps []SitemapMetadata, ancestors []string, shouldProbe func(SitemapMetadata) bool) ([]SitemapMetadata, error) {
+	resolved := make([][]SitemapMetadata, len(sitemaps))
+
+	var group errgroup.Group
+	group.SetLimit(concurrentSitemapProbes)
+	for index, sitemap := range sitemaps {
+		group.Go(func() error {
+			resolved[index] = []SitemapMetadata{sitemap}
+
+			// bulk sitemaps are harvested with a container and never nested
+			if sitemap.IsBulkSitemap() {
+				return nil
+			}
+			if shouldProbe != nil && !shouldProbe(sitemap) {
+				return nil
+			}
+			if slices.Contains(ancestors, sitemap.Loc) {
+				return fmt.Errorf("sitemap index cycle detected: %s refers back to %s", ancestors[len(ancestors)-1], sitemap.Loc)
+			}
+
+			children, isIndex, err := prober.probe(ctx, sitemap.Loc)
+			if err != nil {
+				// if it can't be checked now, treat it as a regular sitemap
+				// so the error is reported when the sitemap is harvested
+				log.Warnf("could not check whether %s is a nested sitemap index: %v", sitemap.Loc, err)
+				return nil
+			}
+			if !isIndex {
+				return nil
+			}
+			if len(ancestors) >= maxSitemapIndexDepth {
+				return fmt.Errorf("sitemap index %s is nested more than %d levels deep", sitemap.Loc, maxSitemapIndexDepth)
+			}
+			if len(children) == 0 {
+				return fmt.Errorf("no sitemaps found in nested sitemap index at %s", sitemap.Loc)
+			}
+
+			log.Infof("Resolving nested sitemap index %s with %d sitemaps", sitemap.Loc, len(children))
+			usedNames := make(map[string]bool)
+			for position, child := range children {
+				children[position] = inheritSitemapMetadata(sitemap, child, position, usedNames)
+			}
+			nestedAncestors := append(slices.Clone(ancestors), sitemap.Loc)
+			resolved[index], err = resolveNestedSitemapIndexes(ctx, prober, children, nestedAncestors, nil)
+			return err
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+
+	return slices.Concat(resolved...), nil
+}
+
+// Replace every entry of the index that is itself a sitemap index with the sitemaps within it. Only the
+// entries that the source selection may include are fetched; the others are left as they are since they
+// are never harvested. This is done before harvesting or planning instead of when the index is parsed
+// so that commands that only need the ids in the index don't pay for it
+func (i SitemapIndex) ResolveNestedIndexes(client *http.Client) (SitemapIndex, error) {
+	return i.resolveNestedIndexes(context.Background(), client, i.newRobotsCache(client), i.sources)
+}
+
+// Resolve the nested indexes of the entries that the selection may include; the probes
+// share the rate limiter and robots.txt cache of the harvest
+func (i SitemapIndex) resolveNestedIndexes(ctx context.Context, client *http.Client, robotsCache *RobotsCache, selection SourceSelection) (SitemapIndex, error) {
+	if i.nestedIndexesResolved {
+		return i, nil
+	}
+	ancestors := []string{}
+	if i.loc != "" {
+		ancestors = append(ancestors, i.loc)
+	}
+	prober := sitemapProber{client: client, robots: robotsCache, ignoreRobots: i.ignoreRobots}
+	shouldProbe := func(sitemap SitemapMetadata) bool {
+		return selection.mayInclude(sitemap.SitemapID) && !selection.excludesEntry(sitemap.SitemapID)
+	}
+	resolved, err := resolveNestedSitemapIndexes(ctx, prober, i.Sitemaps, ancestors, shouldProbe)
+	if err != nil {
+		return i, err
+	}
+	seenIds := make(map[string]string, len(resolved))
+	for _, sitemap := range resolved {
+		if previousLoc, ok := seenIds[sitemap.SitemapID]; ok {
+			return i, fmt.Errorf("sitemap id %s is used by both %s and %s", sitemap.SitemapID, previousLoc, sitemap.Loc)
+		}
+		seenIds[sitemap.SitemapID] = sitemap.Loc
+	}
+	i.Sitemaps = resolved
+	// entries that weren't selected may still be nested indexes
+	i.nestedIndexesResolved = len(selection.Include) == 0
+	return i, nil
+}
@@ -0,0 +1,176 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/internetofwater/nabu/pkg"
+	log "github.com/sirupsen/logrus"
+)
+
+// How strictly the documents in a sitemap are validated against the shacl shapes
+type ShaclMode string
+
+const (
+	// documents are not validated
+	ShaclModeSkip ShaclMode = "skip"
+	// failures are recorded in the crawl report but the documents are still stored
+	ShaclModeWarn ShaclMode = "warn"
+	// the harvest stops on the first failure
+	ShaclModeStrict ShaclMode = "strict"
+)
+
+// Parse the shacl mode for a sitemap from its name in the sitemap index
+func ParseShaclMode(mode string) (ShaclMode, error) {
+	switch parsed := ShaclMode(mode); parsed {
+	case ShaclModeSkip, ShaclModeWarn, ShaclModeStrict:
+		return parsed, nil
+	}
+	return "", fmt.Errorf("unknown shacl mode %q; must be one of %s, %s, or %s", mode, ShaclModeSkip, ShaclModeWarn, ShaclModeStrict)
+}
+
+// Parse a crawl delay from the sitemap index; this is either a duration like 500ms
+// or a number of seconds like the Crawl-delay in robots.txt
+func parseCrawlDelay(delay string) (time.Duration, error) {
+	asDuration := delay
+	if seconds, err := strconv.ParseFloat(delay, 64); err == nil {
+		asDuration = fmt.Sprintf("%gs", seconds)
+	}
+	parsed, err := time.ParseDuration(asDuration)
+	if err != nil {
+		return 0, fmt.Errorf("invalid crawl delay %q; must be a duration like 500ms or a number of seconds", delay)
+	}
+	if parsed < 0 {
+		return 0, fmt.Errorf("crawl delay %q is less than 0", delay)
+	}
+	return parsed, nil
+}
+
+// Merge the settings for a sitemap into its harvest config. Settings are applied in this
+// order, with later ones taking precedence:
+//  1. the defaults from NewSitemapHarvestConfig
+//  2. the command line flags that were set on the sitemap index
+//  3. the geoconnex elements for the sitemap in the sitemap index
+//
+// A --host-crawl-delay still takes precedence over a crawl_delay for its host, and a
+// sample never cleans up regardless of the settings. The merged settings are kept in
+// the config so that they can be recorded in the crawl report
+func (i SitemapIndex) applySitemapSettings(config *SitemapHarvestConfig, sitemap *Sitemap) error {
+	metadata := sitemap.metadata
+	setBySitemapIndex := []string{}
+
+	if i.failedSitesToAssumeDatasetDown > 0 {
+		config.failedSitesToAssumeDatasetDown = i.failedSitesToAssumeDatasetDown
+	}
+	if i.maxShaclErrorsToStore > 0 {
+		config.maxShaclErrorsToStore = i.maxShaclErrorsToStore
+	}
+
+	if metadata.Workers != nil {
+		if *metadata.Workers < 1 {
+			return fmt.Errorf("sitemap %s has %d workers in the sitemap index which is less than 1", metadata.SitemapID, *metadata.Workers)
+		}
+		config.workers = *metadata.Workers
+		sitemap.workers = *metadata.Workers
+		setBySitemapIndex = append(setBySitemapIndex, "workers")
+	}
+
+	shaclMode := ShaclModeWarn
+	if config.exitOnShaclFailure {
+		shaclMode = ShaclModeStrict
+	}
+	if metadata.ShaclMode != "" {
+		mode, err := ParseShaclMode(metadata.ShaclMode)
+		if err != nil {
+			return fmt.Errorf("sitemap %s: %w", metadata.SitemapID, err)
+		}
+		shaclMode = mode
+		setBySitemapIndex = append(setBySitemapIndex, "shacl_mode")
+	}
+	switch {
+	case config.grpcClient == nil || *config.grpcClient == nil:
+		if shaclMode != ShaclModeSkip && metadata.ShaclMode != "" {
+			log.Warnf("Sitemap %s has shacl_mode %s in the sitemap index but no shacl endpoint was set so validation is skipped", metadata.SitemapID, shaclMode)
+		}
+		shaclMode = ShaclModeSkip
+	case shaclMode == ShaclModeSkip:
+		config.grpcClient = nil
+	}
+	config.exitOnShaclFailure = shaclMode == ShaclModeStrict
+
+	var crawlDelay time.Duration
+	if metadata.CrawlDelay != "" {
+		delay, err := parseCrawlDelay(metadata.CrawlDelay)
+		if err != nil {
+			return fmt.Errorf("sitemap %s: %w", metadata.SitemapID, err)
+		}
+		crawlDelay = delay
+		setBySitemapIndex = append(setBySitemapIndex, "crawl_delay")
+		// bulk sitemaps point to bulk sources rather than hosts to crawl
+		if !metadata.IsBulkSitemap() {
+			hosts := make(map[string]struct{})
+			for _, url := range sitemap.URL {
+				host, err := hostKey(url.Loc)
+				if err != nil {
+					return err
+				}
+				if _, seen := hosts[host]; seen {
+					continue
+				}
+				hosts[host] = struct{}{}
+				if err := config.rateLimiter.SetMinimumCrawlDelay(url.Loc, delay); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if metadata.RenderJavascript {
+		setBySitemapIndex = append(setBySitemapIndex, "render_js")
+	}
+
+	if metadata.Cleanup != nil {
+		config.cleanupOutdatedJsonld = *metadata.Cleanup
+		setBySitemapIndex = append(setBySitemapIndex, "cleanup")
+	}
+
+	if metadata.DatasetDownThreshold != nil {
+		if *metadata.DatasetDownThreshold < 1 {
+			return fmt.Errorf("sitemap %s has a dataset down threshold of %d in the sitemap index which is less than 1", metadata.SitemapID, *metadata.DatasetDownThreshold)
+		}
+		config.failedSitesToAssumeDatasetDown = *metadata.DatasetDownThreshold
+		setBySitemapIndex = append(setBySitemapIndex, "dataset_down_threshold")
+	}
+
+	if metadata.MaxShaclErrorsToStore != nil {
+		if *metadata.MaxShaclErrorsToStore < 0 {
+			return fmt.Errorf("sitemap %s stores at most %d shacl errors according to the sitemap index which is less than 0", metadata.SitemapID, *metadata.MaxShaclErrorsToStore)
+		}
+		config.maxShaclErrorsToStore = *metadata.MaxShaclErrorsToStore
+		setBySitemapIndex = append(setBySitemapIndex, "max_shacl_errors_to_store")
+	}
+
+	// a sample must never remove documents outside of it
+	if sitemap.sampled {
+		config.cleanupOutdatedJsonld = false
+	}
+
+	config.settings = pkg.SitemapHarvestSettings{
+		Workers:                        config.workers,
+		ShaclMode:                      string(shaclMode),
+		CrawlDelaySeconds:              crawlDelay.Seconds(),
+		RenderJavascript:               metadata.RenderJavascript,
+		CleanupOutdatedJsonld:          config.cleanupOutdatedJsonld,
+		FailedSitesToAssumeDatasetDown: config.failedSitesToAssumeDatasetDown,
+		MaxShaclErrorsToStore:          config.maxShaclErrorsToStore,
+		SetBySitemapIndex:              setBySitemapIndex,
+	}
+	if len(setBySitemapIndex) > 0 {
+		log.Infof("Sitemap %s uses settings from the sitemap index for %v", metadata.SitemapID, setBySitemapIndex)
+	}
+	return nil
+}
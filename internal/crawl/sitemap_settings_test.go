@@ -0,0 +1,161 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/internetofwater/nabu/internal/protoBuild"
+	"github.com/internetofwater/nabu/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCrawlDelay(t *testing.T) {
+	delay, err := parseCrawlDelay("500ms")
+	require.NoError(t, err)
+	require.Equal(t, 500*time.Millisecond, delay)
+
+	delay, err = parseCrawlDelay("1.5")
+	require.NoError(t, err)
+	require.Equal(t, 1500*time.Millisecond, delay, "a number should be seconds like robots.txt")
+
+	_, err = parseCrawlDelay("soon")
+	require.ErrorContains(t, err, `invalid crawl delay "soon"`)
+
+	_, err = parseCrawlDelay("-1s")
+	require.Error(t, err)
+}
+
+func TestSitemapSettingsFromIndex(t *testing.T) {
+	const index = `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:geoconnex="https://geoconnex.us">
+		<sitemap>
+			<loc>https://example.com/tuned.xml</loc>
+			<geoconnex:sitemap_id>tuned</geoconnex:sitemap_id>
+			<geoconnex:workers>2</geoconnex:workers>
+			<geoconnex:shacl_mode>strict</geoconnex:shacl_mode>
+			<geoconnex:crawl_delay>250ms</geoconnex:crawl_delay>
+			<geoconnex:cleanup>false</geoconnex:cleanup>
+			<geoconnex:dataset_down_threshold>100</geoconnex:dataset_down_threshold>
+			<geoconnex:max_shacl_errors_to_store>0</geoconnex:max_shacl_errors_to_store>
+		</sitemap>
+		<sitemap>
+			<loc>https://example.com/default.xml</loc>
+			<geoconnex:sitemap_id>default</geoconnex:sitemap_id>
+		</sitemap>
+	</sitemapindex>`
+	var parsed SitemapIndex
+	require.NoError(t, xml.Unmarshal([]byte(index), &parsed))
+	require.Len(t, parsed.Sitemaps, 2)
+
+	tuned := parsed.Sitemaps[0]
+	require.Equal(t, 2, *tuned.Workers)
+	require.Equal(t, "strict", tuned.ShaclMode)
+	require.Equal(t, "250ms", tuned.CrawlDelay)
+	require.False(t, *tuned.Cleanup)
+	require.Equal(t, 100, *tuned.DatasetDownThreshold)
+	require.Equal(t, 0, *tuned.MaxShaclErrorsToStore, "an element set to 0 should be distinguishable from a missing one")
+
+	untuned := parsed.Sitemaps[1]
+	require.Nil(t, untuned.Workers)
+	require.Nil(t, untuned.Cleanup)
+	require.Nil(t, untuned.DatasetDownThreshold)
+	require.Nil(t, untuned.MaxShaclErrorsToStore)
+}
+
+func TestApplySitemapSettings(t *testing.T) {
+	newConfig := func() *SitemapHarvestConfig {
+		var shaclClient protoBuild.ShaclValidatorClient = &mockShaclValidatorClient{}
+		return &SitemapHarvestConfig{
+			workers:                        10,
+			grpcClient:                     &shaclClient,
+			rateLimiter:                    NewHostRateLimiter(nil),
+			cleanupOutdatedJsonld:          true,
+			maxShaclErrorsToStore:          20,
+			failedSitesToAssumeDatasetDown: 20,
+		}
+	}
+	index := SitemapIndex{}.WithErrorThresholds(5, 3)
+
+	t.Run("command line flags without sitemap settings", func(t *testing.T) {
+		config := newConfig()
+		sitemap := &Sitemap{metadata: SitemapMetadata{SitemapID: "default"}, URL: urlsFromLocs("https://example.com/1")}
+		require.NoError(t, index.applySitemapSettings(config, sitemap))
+		require.Equal(t, pkg.SitemapHarvestSettings{
+			Workers:                        10,
+			ShaclMode:                      "warn",
+			CleanupOutdatedJsonld:          true,
+			FailedSitesToAssumeDatasetDown: 5,
+			MaxShaclErrorsToStore:          3,
+			SetBySitemapIndex:              []string{},
+		}, config.settings)
+	})
+
+	t.Run("sitemap settings take precedence over the command line", func(t *testing.T) {
+		config := newConfig()
+		workers, threshold, cleanup := 2, 100, false
+		sitemap := &Sitemap{
+			workers: 10,
+			metadata: SitemapMetadata{
+				SitemapID:            "tuned",
+				Workers:              &workers,
+				ShaclMode:            "skip",
+				CrawlDelay:           "2",
+				Cleanup:              &cleanup,
+				DatasetDownThreshold: &threshold,
+			},
+			URL: urlsFromLocs("https://example.com/1", "https://example.com/2"),
+		}
+		require.NoError(t, index.applySitemapSettings(config, sitemap))
+		require.Equal(t, 2, sitemap.workers)
+		require.Nil(t, config.grpcClient, "skipping shacl should drop the client")
+		require.False(t, config.exitOnShaclFailure)
+		require.Equal(t, pkg.SitemapHarvestSettings{
+			Workers:                        2,
+			ShaclMode:                      "skip",
+			CrawlDelaySeconds:              2,
+			CleanupOutdatedJsonld:          false,
+			FailedSitesToAssumeDatasetDown: 100,
+			MaxShaclErrorsToStore:          3,
+			SetBySitemapIndex:              []string{"workers", "shacl_mode", "crawl_delay", "cleanup", "dataset_down_threshold"},
+		}, config.settings)
+	})
+
+	t.Run("strict shacl from the sitemap index", func(t *testing.T) {
+		config := newConfig()
+		sitemap := &Sitemap{metadata: SitemapMetadata{SitemapID: "strict", ShaclMode: "strict"}, URL: urlsFromLocs("https://example.com/1")}
+		require.NoError(t, index.applySitemapSettings(config, sitemap))
+		require.True(t, config.exitOnShaclFailure)
+		require.Equal(t, "strict", config.settings.ShaclMode)
+	})
+
+	t.Run("shacl can't be validated without an endpoint", func(t *testing.T) {
+		config := newConfig()
+		var noClient protoBuild.ShaclValidatorClient
+		config.grpcClient = &noClient
+		sitemap := &Sitemap{metadata: SitemapMetadata{SitemapID: "strict", ShaclMode: "strict"}, URL: urlsFromLocs("https://example.com/1")}
+		require.NoError(t, index.applySitemapSettings(config, sitemap))
+		require.False(t, config.exitOnShaclFailure)
+		require.Equal(t, "skip", config.settings.ShaclMode)
+	})
+
+	t.Run("a sample never cleans up even if the sitemap index says to", func(t *testing.T) {
+		config := newConfig()
+		cleanup := true
+		sitemap := &Sitemap{sampled: true, metadata: SitemapMetadata{SitemapID: "sampled", Cleanup: &cleanup}, URL: urlsFromLocs("https://example.com/1")}
+		require.NoError(t, index.applySitemapSettings(config, sitemap))
+		require.False(t, config.cleanupOutdatedJsonld)
+		require.False(t, config.settings.CleanupOutdatedJsonld)
+	})
+
+	t.Run("invalid settings", func(t *testing.T) {
+		workers := 0
+		sitemap := &Sitemap{metadata: SitemapMetadata{SitemapID: "broken", Workers: &workers}, URL: urlsFromLocs("https://example.com/1")}
+		require.ErrorContains(t, index.applySitemapSettings(newConfig(), sitemap), "less than 1")
+
+		sitemap = &Sitemap{metadata: SitemapMetadata{SitemapID: "broken", ShaclMode: "lenient"}, URL: urlsFromLocs("https://example.com/1")}
+		require.ErrorContains(t, index.applySitemapSettings(newConfig(), sitemap), "unknown shacl mode")
+	})
+}
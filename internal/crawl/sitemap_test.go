@@ -9,6 +9,7 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"net/http"
 	"os"
 	"strings"
 	"testing"
@@ -17,6 +18,7 @@ import (
 	common "github.com/internetofwater/nabu/internal/common"
 	"github.com/internetofwater/nabu/internal/crawl/storage"
 	"github.com/internetofwater/nabu/internal/crawl/url_info"
+	"github.com/internetofwater/nabu/pkg"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/stretchr/testify/require"
@@ -481,3 +483,183 @@ func TestShaclConnectionIssueDoesntCauseFailure(t *testing.T) {
 	require.Equal(t, stats.SitesInSitemap, 3)
 	require.Equal(t, stats.WarningStats.TotalShaclFailures, 3, "All three features should have had SHACL validation failures since the SHACL client couldn't connect, but this shouldn't cause the harvest to fail")
 }
+
+func TestResumeHarvestFromCheckpoint(t *testing.T) {
+	const firstUrl = "https://geoconnex.us/iow/wqp/BPMWQX-1084-WR-CC01C"
+	const secondUrl = "https://geoconnex.us/iow/wqp/BPMWQX-1085-WR-CC01C2"
+	const thirdUrl = "https://geoconnex.us/iow/wqp/BPMWQX-1086-WR-CC02A"
+
+	mocks := map[string]common.MockResponse{
+		"https://geoconnex.us/sitemap/iow/wqp/stations__5.xml": {
+			StatusCode: 200,
+			File:       "testdata/sitemap.xml",
+		},
+		firstUrl: {
+			StatusCode:  200,
+			File:        "testdata/reference_feature.jsonld",
+			ContentType: "application/ld+json",
+		},
+		secondUrl: {
+			StatusCode:  200,
+			File:        "testdata/reference_feature_2.jsonld",
+			ContentType: "application/ld+json",
+		},
+		// an empty response is a fatal error which
+		// simulates a harvest that dies partway through
+		thirdUrl: {
+			StatusCode:  200,
+			Body:        "{}",
+			ContentType: "application/ld+json",
+		},
+		"https://geoconnex.us/robots.txt": {
+			StatusCode:  200,
+			File:        "testdata/geoconnex_robots.txt",
+			ContentType: "application/text/plain",
+		},
+	}
+	mockedClient := common.NewMockedClient(true, mocks)
+
+	storage, err := storage.NewLocalTempFSCrawlStorage()
+	require.NoError(t, err)
+	metadata := SitemapMetadata{SitemapID: "test", Loc: "https://geoconnex.us/sitemap/iow/wqp/stations__5.xml"}
+
+	sitemap, err := NewSitemap(context.Background(), mockedClient, 1, storage, metadata)
+	require.NoError(t, err)
+	config, err := NewSitemapHarvestConfig(mockedClient, sitemap, nil, false, false)
+	require.NoError(t, err)
+	config.checkpointInterval = 1
+
+	firstStats, _, err := sitemap.Harvest(context.Background(), &config)
+	require.Error(t, err)
+	require.Equal(t, 2, firstStats.SuccessfulSites)
+
+	checkpoint, found, err := loadCheckpoint(storage, "test")
+	require.NoError(t, err)
+	require.True(t, found, "a checkpoint should be left behind when the harvest fails")
+	require.Len(t, checkpoint.CompletedUrls, 2)
+	require.Contains(t, checkpoint.CompletedUrls, firstUrl)
+	require.Contains(t, checkpoint.CompletedUrls, secondUrl)
+
+	// if the first two urls were crawled again they would be
+	// counted as failures, so this makes sure they were skipped
+	mocks[firstUrl] = common.MockResponse{StatusCode: 500, Body: "error"}
+	mocks[secondUrl] = common.MockResponse{StatusCode: 500, Body: "error"}
+	mocks[thirdUrl] = common.MockResponse{
+		StatusCode:  200,
+		File:        "testdata/reference_feature_3.jsonld",
+		ContentType: "application/ld+json",
+	}
+	resumedClient := common.NewMockedClient(true, mocks)
+
+	sitemap, err = NewSitemap(context.Background(), resumedClient, 1, storage, metadata)
+	require.NoError(t, err)
+	config, err = NewSitemapHarvestConfig(resumedClient, sitemap, nil, false, false)
+	require.NoError(t, err)
+	config.checkpointInterval = 1
+	config.resumeFromCheckpoint = true
+
+	resumedStats, _, err := sitemap.Harvest(context.Background(), &config)
+	require.NoError(t, err)
+	require.Equal(t, 3, resumedStats.SuccessfulSites, "stats should include the sites from the original run")
+	require.Equal(t, 2, resumedStats.SitesResumedFromCheckpoint)
+	require.Empty(t, resumedStats.CrawlFailures)
+	require.GreaterOrEqual(t, resumedStats.SecondsToComplete, firstStats.SecondsToComplete)
+
+	_, found, err = loadCheckpoint(storage, "test")
+	require.NoError(t, err)
+	require.False(t, found, "the checkpoint should be removed after a successful harvest")
+}
+
+func TestIncrementalHarvestWithLastMod(t *testing.T) {
+	const firstUrl = "https://geoconnex.us/iow/wqp/BPMWQX-1084-WR-CC01C"
+	const secondUrl = "https://geoconnex.us/iow/wqp/BPMWQX-1085-WR-CC01C2"
+	const thirdUrl = "https://geoconnex.us/iow/wqp/BPMWQX-1086-WR-CC02A"
+	const sitemapUrl = "https://geoconnex.us/sitemap/iow/wqp/stations__5.xml"
+
+	mocks := map[string]common.MockResponse{
+		sitemapUrl: {
+			StatusCode: 200,
+			File:       "testdata/sitemap.xml",
+		},
+		firstUrl: {
+			StatusCode:  200,
+			File:        "testdata/reference_feature.jsonld",
+			ContentType: "application/ld+json",
+		},
+		secondUrl: {
+			StatusCode:  200,
+			File:        "testdata/reference_feature_2.jsonld",
+			ContentType: "application/ld+json",
+		},
+		thirdUrl: {
+			StatusCode:  200,
+			File:        "testdata/reference_feature_3.jsonld",
+			ContentType: "application/ld+json",
+		},
+		"https://geoconnex.us/robots.txt": {
+			StatusCode:  200,
+			File:        "testdata/geoconnex_robots.txt",
+			ContentType: "application/text/plain",
+		},
+	}
+
+	storage, err := storage.NewLocalTempFSCrawlStorage()
+	require.NoError(t, err)
+	metadata := SitemapMetadata{SitemapID: "test", Loc: sitemapUrl, LastMod: "2026-02-24T16:44:04Z"}
+
+	harvest := func(client *http.Client) pkg.SitemapCrawlStats {
+		sitemap, err := NewSitemap(context.Background(), client, 1, storage, metadata)
+		require.NoError(t, err)
+		config, err := NewSitemapHarvestConfig(client, sitemap, nil, false, true)
+		require.NoError(t, err)
+		config.skipUnchangedLastMod = true
+		stats, _, err := sitemap.Harvest(context.Background(), &config)
+		require.NoError(t, err)
+		return stats
+	}
+
+	stats := harvest(common.NewMockedClient(true, mocks))
+	require.Equal(t, 3, stats.SuccessfulSites)
+	require.Equal(t, 0, stats.SitesWithUnchangedLastMod, "nothing should be skipped on the first harvest")
+
+	unchanged, err := sitemapUnchangedSinceLastHarvest(storage, metadata)
+	require.NoError(t, err)
+	require.True(t, unchanged)
+	newerMetadata := metadata
+	newerMetadata.LastMod = "2026-03-01"
+	unchanged, err = sitemapUnchangedSinceLastHarvest(storage, newerMetadata)
+	require.NoError(t, err)
+	require.False(t, unchanged)
+
+	// only the third url has a newer lastmod so it is the only one that should be fetched;
+	// the rest would fail if they were fetched
+	mocks[sitemapUrl] = common.MockResponse{
+		StatusCode: 200,
+		Body: `<?xml version='1.0' encoding='utf-8'?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<url><loc>` + firstUrl + `</loc><lastmod>2025-02-05T16:39:34Z</lastmod></url>
+<url><loc>` + secondUrl + `</loc><lastmod>2025-01-01T00:00:00Z</lastmod></url>
+<url><loc>` + thirdUrl + `</loc><lastmod>2025-03-01T00:00:00Z</lastmod></url>
+</urlset>`,
+	}
+	mocks[firstUrl] = common.MockResponse{StatusCode: 500, Body: "error"}
+	mocks[secondUrl] = common.MockResponse{StatusCode: 500, Body: "error"}
+
+	stats = harvest(common.NewMockedClient(true, mocks))
+	require.Empty(t, stats.CrawlFailures)
+	require.Equal(t, 3, stats.SuccessfulSites, "skipped sites are still successful")
+	require.Equal(t, 2, stats.SitesWithUnchangedLastMod)
+
+	manifest, err := loadLastModManifest(storage, "test")
+	require.NoError(t, err)
+	require.Equal(t, metadata.LastMod, manifest.SitemapLastMod)
+	require.Len(t, manifest.UrlLastMods, 3)
+	parsed, err := url_info.ParseLastMod(manifest.UrlLastMods[thirdUrl])
+	require.NoError(t, err)
+	require.Equal(t, 3, int(parsed.Month()), "the manifest should have the newer lastmod")
+
+	// all three jsonld files should still exist since skipped urls are not cleaned up
+	files, err := storage.ListDir("summoned/test")
+	require.NoError(t, err)
+	require.Len(t, files, 3)
+}
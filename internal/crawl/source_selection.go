@@ -0,0 +1,169 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Selects sitemaps in a sitemap index by their sitemap_id. Each pattern is either an exact id
+// or a glob as in path.Match, so usgs/* selects every id directly under usgs/ but not usgs itself.
+// A pattern that matches an entry of the index that is a nested sitemap index matches every
+// sitemap within it, so provider selects provider:stations and provider:wells
+type SourceSelection struct {
+	// the ids or patterns of the sitemaps to select; if this is empty every sitemap is selected
+	Include []string
+	// the ids or patterns of sitemaps that are never selected, even if they were included
+	Exclude []string
+}
+
+// Make a selection of sitemaps from the ids or glob patterns to include and exclude;
+// empty patterns are ignored so that an unset flag selects every sitemap
+func NewSourceSelection(include []string, exclude []string) (SourceSelection, error) {
+	includePatterns, err := validSitemapIdPatterns(include)
+	if err != nil {
+		return SourceSelection{}, err
+	}
+	excludePatterns, err := validSitemapIdPatterns(exclude)
+	if err != nil {
+		return SourceSelection{}, err
+	}
+	return SourceSelection{Include: includePatterns, Exclude: excludePatterns}, nil
+}
+
+// Return the non empty patterns or an error if any of them isn't a valid glob
+func validSitemapIdPatterns(patterns []string) ([]string, error) {
+	valid := []string{}
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid sitemap id pattern %q: %w", pattern, err)
+		}
+		valid = append(valid, pattern)
+	}
+	return valid, nil
+}
+
+// Returns true if no patterns are set and thus every sitemap is selected
+func (s SourceSelection) IsEmpty() bool {
+	return len(s.Include) == 0 && len(s.Exclude) == 0
+}
+
+// Returns true if the sitemap id matches the pattern; patterns are validated
+// when the selection is made so an invalid one just doesn't match
+func matchesSitemapId(pattern string, sitemapId string) bool {
+	matched, err := path.Match(pattern, sitemapId)
+	return err == nil && matched
+}
+
+// Returns true if the entry with the given id in the top level of the index, or a sitemap nested within it,
+// may be included. A pattern for nested sitemaps, i.e. provider:*, is checked against its part before the
+// first NestedSitemapIdSeparator since the ids of nested sitemaps aren't known until the entry is fetched
+func (s SourceSelection) mayInclude(topLevelId string) bool {
+	if len(s.Include) == 0 {
+		return true
+	}
+	for _, pattern := range s.Include {
+		parentPattern, _, _ := strings.Cut(pattern, NestedSitemapIdSeparator)
+		if matchesSitemapId(pattern, topLevelId) || matchesSitemapId(parentPattern, topLevelId) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if an exclude pattern matches the entry with the given id in the top level of the
+// index, which excludes every sitemap nested within it
+func (s SourceSelection) excludesEntry(topLevelId string) bool {
+	for _, pattern := range s.Exclude {
+		if matchesSitemapId(pattern, topLevelId) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the pattern matches the id of the sitemap or, for a sitemap within
+// a nested index, the id of the entry in the top level of the index it was found in
+func matchesSitemap(pattern string, sitemap SitemapMetadata) bool {
+	if matchesSitemapId(pattern, sitemap.SitemapID) {
+		return true
+	}
+	return sitemap.topLevelSitemapId != "" && matchesSitemapId(pattern, sitemap.topLevelSitemapId)
+}
+
+// Returns true if the sitemap with the given id is included and not excluded
+func (s SourceSelection) Selects(sitemapId string) bool {
+	return s.selectsSitemap(SitemapMetadata{SitemapID: sitemapId})
+}
+
+// Returns true if the sitemap is included and not excluded
+func (s SourceSelection) selectsSitemap(sitemap SitemapMetadata) bool {
+	for _, pattern := range s.Exclude {
+		if matchesSitemap(pattern, sitemap) {
+			return false
+		}
+	}
+	if len(s.Include) == 0 {
+		return true
+	}
+	for _, pattern := range s.Include {
+		if matchesSitemap(pattern, sitemap) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the sitemaps in the index that are selected in the order they appear in the index. Every
+// pattern must match at least one sitemap in the index so that a typo in a scheduled job fails
+// loudly instead of silently harvesting less; the error lists every pattern that matched nothing
+func (i SitemapIndex) SelectedSitemaps() ([]SitemapMetadata, error) {
+	matched := make(map[string]bool)
+	selected := []SitemapMetadata{}
+	for _, sitemap := range i.Sitemaps {
+		for _, patterns := range [][]string{i.sources.Include, i.sources.Exclude} {
+			for _, pattern := range patterns {
+				if matchesSitemap(pattern, sitemap) {
+					matched[pattern] = true
+				}
+			}
+		}
+		if i.sources.selectsSitemap(sitemap) {
+			selected = append(selected, sitemap)
+		}
+	}
+
+	var unmatchedIncludes, unmatchedExcludes []string
+	for _, pattern := range i.sources.Include {
+		if !matched[pattern] {
+			unmatchedIncludes = append(unmatchedIncludes, pattern)
+		}
+	}
+	for _, pattern := range i.sources.Exclude {
+		if !matched[pattern] {
+			unmatchedExcludes = append(unmatchedExcludes, pattern)
+		}
+	}
+	var problems []string
+	if len(unmatchedIncludes) > 0 {
+		problems = append(problems, fmt.Sprintf("no sitemap found with id %s", strings.Join(unmatchedIncludes, ", ")))
+	}
+	if len(unmatchedExcludes) > 0 {
+		problems = append(problems, fmt.Sprintf("no sitemap found with id %s to exclude", strings.Join(unmatchedExcludes, ", ")))
+	}
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "; "))
+	}
+	// an index with no sitemaps has nothing to select, which is only an error if it's due to the exclusions
+	if len(selected) == 0 && len(i.sources.Exclude) > 0 {
+		return nil, fmt.Errorf("every sitemap in the index was excluded by %s", strings.Join(i.sources.Exclude, ", "))
+	}
+	return selected, nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package crawl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func sitemapIndexWithIds(ids ...string) SitemapIndex {
+	index := SitemapIndex{}
+	for _, id := range ids {
+		index.Sitemaps = append(index.Sitemaps, SitemapMetadata{SitemapID: id, Loc: "https://example.com/" + id + ".xml"})
+	}
+	return index
+}
+
+func selectedIds(t *testing.T, index SitemapIndex, include []string, exclude []string) ([]string, error) {
+	selection, err := NewSourceSelection(include, exclude)
+	require.NoError(t, err)
+	selected, err := index.WithSourceSelection(selection).SelectedSitemaps()
+	ids := []string{}
+	for _, sitemap := range selected {
+		ids = append(ids, sitemap.SitemapID)
+	}
+	return ids, err
+}
+
+func TestSelectedSitemaps(t *testing.T) {
+	index := sitemapIndexWithIds("usgs/gages", "usgs/huc12", "usgs", "nmwdi/wells", "iow:wqp:stations__5")
+
+	t.Run("no patterns selects every sitemap", func(t *testing.T) {
+		ids, err := selectedIds(t, index, []string{""}, nil)
+		require.NoError(t, err)
+		require.Len(t, ids, 5)
+	})
+
+	t.Run("glob with an exclusion", func(t *testing.T) {
+		ids, err := selectedIds(t, index, []string{"usgs/*"}, []string{"usgs/huc12"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"usgs/gages"}, ids)
+	})
+
+	t.Run("explicit list keeps the order of the index", func(t *testing.T) {
+		ids, err := selectedIds(t, index, []string{"nmwdi/wells", "iow:wqp:*", "usgs"}, nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{"usgs", "nmwdi/wells", "iow:wqp:stations__5"}, ids)
+	})
+
+	t.Run("only exclusions", func(t *testing.T) {
+		ids, err := selectedIds(t, index, nil, []string{"usgs*"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"usgs/gages", "usgs/huc12", "nmwdi/wells", "iow:wqp:stations__5"}, ids, "* should not match across a /")
+	})
+
+	t.Run("patterns that match nothing are listed", func(t *testing.T) {
+		_, err := selectedIds(t, index, []string{"usgs/*", "usgs/typo", "epa/*"}, []string{"nmwdi/typo"})
+		require.EqualError(t, err, "no sitemap found with id usgs/typo, epa/*; no sitemap found with id nmwdi/typo to exclude")
+	})
+
+	t.Run("everything excluded", func(t *testing.T) {
+		_, err := selectedIds(t, index, []string{"usgs/*"}, []string{"usgs/*"})
+		require.ErrorContains(t, err, "every sitemap in the index was excluded")
+	})
+
+	t.Run("empty index without patterns", func(t *testing.T) {
+		ids, err := selectedIds(t, sitemapIndexWithIds(), nil, nil)
+		require.NoError(t, err)
+		require.Empty(t, ids)
+	})
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		_, err := NewSourceSelection([]string{"usgs/["}, nil)
+		require.ErrorContains(t, err, "invalid sitemap id pattern")
+	})
+}
+
+func TestSelectedSitemapsWithNestedIndex(t *testing.T) {
+	var nestedIndexRequests atomic.Int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			_, _ = w.Write([]byte("User-agent: *\nAllow: /\n"))
+		case "/sitemap.xml":
+			_, _ = fmt.Fprintf(w, `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:geoconnex="https://geoconnex.us">
+				<sitemap><loc>%s/provider/index.xml</loc><geoconnex:sitemap_id>provider</geoconnex:sitemap_id></sitemap>
+				<sitemap><loc>%s/other.xml</loc><geoconnex:sitemap_id>other</geoconnex:sitemap_id></sitemap>
+			</sitemapindex>`, server.URL, server.URL)
+		case "/provider/index.xml":
+			nestedIndexRequests.Add(1)
+			_, _ = fmt.Fprintf(w, `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+				<sitemap><loc>%s/provider/stations.xml</loc></sitemap>
+				<sitemap><loc>%s/provider/wells.xml</loc></sitemap>
+			</sitemapindex>`, server.URL, server.URL)
+		default:
+			_, _ = w.Write([]byte(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"></urlset>`))
+		}
+	}))
+	defer server.Close()
+
+	index, err := NewSitemapIndex(server.URL+"/sitemap.xml", server.Client())
+	require.NoError(t, err)
+
+	selectedNestedIds := func(t *testing.T, include []string, exclude []string) ([]string, error) {
+		selection, err := NewSourceSelection(include, exclude)
+		require.NoError(t, err)
+		resolved, err := index.WithSourceSelection(selection).ResolveNestedIndexes(server.Client())
+		require.NoError(t, err)
+		return selectedIds(t, resolved, include, exclude)
+	}
+
+	t.Run("exact id of the nested index", func(t *testing.T) {
+		ids, err := selectedNestedIds(t, []string{"provider"}, nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{"provider:stations", "provider:wells"}, ids)
+	})
+
+	t.Run("glob matching the nested index", func(t *testing.T) {
+		ids, err := selectedNestedIds(t, []string{"prov*"}, nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{"provider:stations", "provider:wells"}, ids)
+	})
+
+	t.Run("sitemap within the nested index", func(t *testing.T) {
+		ids, err := selectedNestedIds(t, []string{"provider:wells"}, nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{"provider:wells"}, ids)
+	})
+
+	t.Run("excluding the nested index", func(t *testing.T) {
+		before := nestedIndexRequests.Load()
+		ids, err := selectedNestedIds(t, nil, []string{"provider"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"other"}, ids)
+		require.Equal(t, before, nestedIndexRequests.Load(), "an excluded nested index should not be fetched")
+	})
+
+	t.Run("excluding a sitemap within the nested index", func(t *testing.T) {
+		ids, err := selectedNestedIds(t, []string{"provider"}, []string{"provider:stations"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"provider:wells"}, ids)
+	})
+}
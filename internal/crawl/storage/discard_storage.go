@@ -46,6 +46,14 @@ func (DiscardCrawlStorage) GetHash(string) (Md5Hash, bool, error) {
 	return "", false, nil
 }
 
+func (DiscardCrawlStorage) ListDirHashes(string) (map[ObjectPath]Md5Hash, error) {
+	return map[ObjectPath]Md5Hash{}, nil
+}
+
+func (DiscardCrawlStorage) GetSha256(string) (Sha256Hash, bool, error) {
+	return "", false, nil
+}
+
 func (DiscardCrawlStorage) StoreBulk(items chan BulkStorageItem) error {
 	return nil
 }
@@ -5,8 +5,11 @@ package storage
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -35,6 +38,31 @@ func (s Set) Add(key ObjectPath) {
 // A hash of a file generated from the md5 algorithm
 type Md5Hash = string
 
+// A hex encoded hash of a file generated from the sha256 algorithm
+type Sha256Hash = string
+
+// Compute the sha256 of the data in a seekable reader and then seek
+// back to where it started so the data can still be stored;
+// the boolean is false if the reader isn't seekable and thus wasn't hashed
+func Sha256OfSeekableReader(data io.Reader) (Sha256Hash, bool, error) {
+	seeker, ok := data.(io.ReadSeeker)
+	if !ok {
+		return "", false, nil
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", false, err
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, seeker); err != nil {
+		return "", false, err
+	}
+	if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+		return "", false, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), true, nil
+}
+
 // An item with associated metadata to be stored in bulk
 type BulkStorageItem struct {
 	Path       ObjectPath
@@ -66,13 +94,27 @@ type CrawlStorage interface {
 	IsEmptyDir(ObjectPath) (bool, error)
 	// Get the hash of the file
 	GetHash(ObjectPath) (hash Md5Hash, file_exists bool, err error)
+	// Get the hash of every file in the directory with a single listing, keyed by the
+	// path relative to the root of the storage; files without a hash of their raw content are left out
+	ListDirHashes(ObjectPath) (map[ObjectPath]Md5Hash, error)
+	// Get the sha256 of a file that was stored with StoreWithHash; the hash
+	// is empty if the file exists but no sha256 was recorded for it
+	GetSha256(ObjectPath) (hash Sha256Hash, file_exists bool, err error)
 	// Store data in bulk for more efficient storage. The channel will be closed by the caller when all items have been sent.
 	// There is no ctx passed to this since anything passed to the channel is deemed to be valid JSON-LD and thus should be uploaded
 	StoreBulk(items chan BulkStorageItem) error
 }
 
-// Given a storage path, iterate through it and remove any files that aren't in sitesToKeep
-func CleanupFiles(pathInStorage string, sitesToKeep Set, storage CrawlStorage) ([]string, error) {
+// A file in storage that is not in the sites to keep
+type outdatedFile struct {
+	// the path as returned by ListDir
+	absPath string
+	// the path relative to the root of the storage
+	relativePath string
+}
+
+// Given a storage path, list the files in it that aren't in sitesToKeep
+func findOutdatedFiles(pathInStorage string, sitesToKeep Set, storage CrawlStorage) ([]outdatedFile, error) {
 	if pathInStorage == "" {
 		return nil, fmt.Errorf("path is empty")
 	}
@@ -92,6 +134,43 @@ func CleanupFiles(pathInStorage string, sitesToKeep Set, storage CrawlStorage) (
 		return nil, err
 	}
 
+	outdated := []outdatedFile{}
+	for absPath := range files {
+		index := strings.Index(absPath, pathInStorage)
+		if index == -1 {
+			return nil, fmt.Errorf("unexpected path format: %s", absPath)
+		}
+		relativePath := absPath[index:]
+
+		if sitesToKeep.Contains(relativePath) {
+			continue
+		}
+		outdated = append(outdated, outdatedFile{absPath: absPath, relativePath: relativePath})
+	}
+	return outdated, nil
+}
+
+// Given a storage path, return the files that CleanupFiles would remove without removing them
+func FilesToCleanup(pathInStorage string, sitesToKeep Set, storage CrawlStorage) ([]string, error) {
+	outdated, err := findOutdatedFiles(pathInStorage, sitesToKeep, storage)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(outdated))
+	for _, file := range outdated {
+		paths = append(paths, file.absPath)
+	}
+	slices.Sort(paths)
+	return paths, nil
+}
+
+// Given a storage path, iterate through it and remove any files that aren't in sitesToKeep
+func CleanupFiles(pathInStorage string, sitesToKeep Set, storage CrawlStorage) ([]string, error) {
+	outdated, err := findOutdatedFiles(pathInStorage, sitesToKeep, storage)
+	if err != nil {
+		return nil, err
+	}
+
 	var (
 		pathsDeleted []string
 		mu           sync.Mutex // protect shared slice
@@ -104,17 +183,8 @@ func CleanupFiles(pathInStorage string, sitesToKeep Set, storage CrawlStorage) (
 	exitingEarly := atomic.Bool{}
 	exitingEarly.Store(false)
 
-	for absPath := range files {
-
-		index := strings.Index(absPath, pathInStorage)
-		if index == -1 {
-			return nil, fmt.Errorf("unexpected path format: %s", absPath)
-		}
-		relativePath := absPath[index:]
-
-		if sitesToKeep.Contains(relativePath) {
-			continue
-		}
+	for _, file := range outdated {
+		absPath, relativePath := file.absPath, file.relativePath
 
 		eg.Go(func() error {
 			// Check if context is already canceled due to another error
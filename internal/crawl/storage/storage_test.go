@@ -87,6 +87,14 @@ func TestCleanupOutdatedJsonld(t *testing.T) {
 	// "make sure files that are not seen are removed"
 	err = storage.StoreWithoutServersideHash("summoned/sitemap1/THIS_SHOULD_BE_REMOVED.txt", bytes.NewReader([]byte("dummy_data")))
 	require.NoError(t, err)
+	// listing the files to cleanup shouldn't remove them
+	toCleanup, err := FilesToCleanup("summoned/sitemap1", filesinStorage, storage)
+	require.NoError(t, err)
+	require.Len(t, toCleanup, 1)
+	require.Contains(t, toCleanup[0], "summoned/sitemap1/THIS_SHOULD_BE_REMOVED.txt")
+	res, err = storage.Exists("summoned/sitemap1/THIS_SHOULD_BE_REMOVED.txt")
+	require.NoError(t, err)
+	require.True(t, res)
 	_, err = CleanupFiles("summoned/sitemap1", filesinStorage, storage)
 	require.NoError(t, err)
 	res, err = storage.Exists("summoned/sitemap1/THIS_SHOULD_BE_REMOVED.txt")
@@ -102,3 +110,29 @@ func TestCleanupOutdatedJsonld(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, res)
 }
+
+func TestSha256(t *testing.T) {
+	storage, err := NewLocalTempFSCrawlStorage()
+	require.NoError(t, err)
+
+	reader := bytes.NewReader([]byte("dummy_data"))
+	hash, hashed, err := Sha256OfSeekableReader(reader)
+	require.NoError(t, err)
+	require.True(t, hashed)
+	require.Equal(t, "bc477a008261e812b7134387e1024d2e4d3f7872664930772c54c0e20efdaa3a", hash)
+
+	err = storage.StoreWithHash("testfile.txt", reader, reader.Len())
+	require.NoError(t, err)
+	storedHash, exists, err := storage.GetSha256("testfile.txt")
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Equal(t, hash, storedHash, "hashing should not consume the reader")
+
+	_, exists, err = storage.GetSha256("nonexistent.txt")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	_, hashed, err = Sha256OfSeekableReader(io.MultiReader(bytes.NewReader([]byte("dummy_data"))))
+	require.NoError(t, err)
+	require.False(t, hashed)
+}
@@ -119,6 +119,28 @@ func (l *LocalTempFSCrawlStorage) GetHash(object string) (Md5Hash, bool, error)
 	return "", true, nil
 }
 
+// Like GetHash, no hashes are recorded for local files
+func (l *LocalTempFSCrawlStorage) ListDirHashes(prefix string) (map[ObjectPath]Md5Hash, error) {
+	return map[ObjectPath]Md5Hash{}, nil
+}
+
+// The sha256 is computed from the file on disk since
+// there is no separate place to record it locally
+func (l *LocalTempFSCrawlStorage) GetSha256(object string) (Sha256Hash, bool, error) {
+	file, err := os.Open(filepath.Join(l.baseDir, object))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	defer func() { _ = file.Close() }()
+	hash, _, err := Sha256OfSeekableReader(file)
+	if err != nil {
+		return "", true, err
+	}
+	return hash, true, nil
+}
+
 func (l *LocalTempFSCrawlStorage) StoreBulk(items chan BulkStorageItem) error {
 	for item := range items {
 		if err := l.StoreWithHash(item.Path, item.Data, item.ByteLength); err != nil {
@@ -5,7 +5,9 @@ package url_info
 
 import (
 	"encoding/base64"
+	"fmt"
 	"strings"
+	"time"
 
 	sitemap "github.com/oxffaa/gopher-parse-sitemap"
 )
@@ -44,6 +46,14 @@ func NewUrlFromSitemapEntry(sitemap sitemap.Entry) *URL {
 	}
 }
 
+// Return the path in storage of the jsonld harvested from the url for the given sitemap
+func SummonedPath(sitemapId string, url URL) (string, error) {
+	if url.Base64Loc == "" {
+		return "", fmt.Errorf("no base64 loc for url %s", url.Loc)
+	}
+	return fmt.Sprintf("summoned/%s/%s.jsonld", sitemapId, url.Base64Loc), nil
+}
+
 // Return a URL without the rest of the sitemap fields
 func NewUrlFromString(url string) URL {
 	return URL{
@@ -51,3 +61,43 @@ func NewUrlFromString(url string) URL {
 		Base64Loc: base64.StdEncoding.EncodeToString([]byte(url)),
 	}
 }
+
+// The layouts a lastmod may be in; the first is how a parsed
+// sitemap entry is serialized and the rest are the W3C datetime
+// formats allowed by the sitemap protocol https://www.w3.org/TR/NOTE-datetime
+var lastModLayouts = []string{
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// Parse a lastmod value from either a sitemap or a sitemap index
+func ParseLastMod(lastMod string) (time.Time, error) {
+	lastMod = strings.TrimSpace(lastMod)
+	for _, layout := range lastModLayouts {
+		if parsed, err := time.Parse(layout, lastMod); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("could not parse lastmod '%s'", lastMod)
+}
+
+// Return true if the lastmod has not advanced past the previously seen lastmod;
+// if either is missing or can't be parsed, the url is assumed to have changed
+func LastModUnchanged(previous string, current string) bool {
+	if previous == "" || current == "" {
+		return false
+	}
+	previousTime, err := ParseLastMod(previous)
+	if err != nil {
+		return false
+	}
+	currentTime, err := ParseLastMod(current)
+	if err != nil {
+		return false
+	}
+	return !currentTime.After(previousTime)
+}
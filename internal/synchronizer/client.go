@@ -7,6 +7,7 @@ import (
 	"github.com/internetofwater/nabu/internal/common"
 	"github.com/internetofwater/nabu/internal/config"
 	"github.com/internetofwater/nabu/internal/synchronizer/s3"
+	"github.com/internetofwater/nabu/internal/synchronizer/triplestore"
 
 	"github.com/piprate/json-gold/ld"
 )
@@ -15,6 +16,9 @@ import (
 type SynchronizerClient struct {
 	// the client used for communicating with s3
 	S3Client *s3.MinioClientWrapper
+	// the client used for communicating with the triplestore;
+	// nil if no sparql endpoint was configured
+	GraphClient *triplestore.GraphStoreClient
 	// default bucket in the s3 that is used for metadata
 	metadataBucketName string
 	// default bucket in the s3 that is used for synchronization
@@ -56,8 +60,17 @@ func NewSynchronizerClientFromConfig(conf config.NabuConfig) (*SynchronizerClien
 		return nil, err
 	}
 
+	var graphClient *triplestore.GraphStoreClient
+	if conf.Sparql.Endpoint != "" {
+		graphClient, err = triplestore.NewGraphStoreClient(conf.Sparql, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	client := &SynchronizerClient{
 		S3Client:        s3Client,
+		GraphClient:     graphClient,
 		syncBucketName:  conf.Minio.Bucket,
 		jsonldProcessor: processor,
 		jsonldOptions:   options,
@@ -223,6 +223,21 @@ func (synchronizer *SynchronizerClient) GenerateNqRelease(ctx context.Context, s
 		}
 	}
 
+	return synchronizer.releasePrefix(ctx, prefix, compressGraphWithGzip, mainstemFile)
+}
+
+// Generate the provenance graph for a sitemap from the provenance that was recorded
+// in prov/ for each document when it was harvested; mainstems are never added to it
+func (synchronizer *SynchronizerClient) GenerateProvRelease(ctx context.Context, sitemap_metadata crawl.SitemapMetadata, compressGraphWithGzip bool) error {
+	prefix := "prov/" + sitemap_metadata.SitemapID
+	ctx, span := opentelemetry.SubSpanFromCtxWithName(ctx, fmt.Sprintf("nq_release_graph_%s", prefix))
+	defer span.End()
+	return synchronizer.releasePrefix(ctx, prefix, compressGraphWithGzip, "")
+}
+
+// Stream every object under the prefix into a single release graph
+// in graphs/latest alongside the bytesum of the graph
+func (synchronizer *SynchronizerClient) releasePrefix(ctx context.Context, prefix string, compressGraphWithGzip bool, mainstemFile string) error {
 	if prefix == "" {
 		return fmt.Errorf("prefix is empty; you must specify a prefix to generate a release graph from")
 	}
@@ -235,6 +250,19 @@ func (synchronizer *SynchronizerClient) GenerateNqRelease(ctx context.Context, s
 		releaseNqName += ".gz"
 	}
 
+	return synchronizer.uploadReleaseGraph(ctx, releaseNqName, compressGraphWithGzip, func(nqChan chan<- string) error {
+		// Don't close nqChan here - streamNqFromPrefix will close it
+		streamErr := synchronizer.streamNqFromPrefix(ctx, prefix, nqChan, mainstemFile)
+		if streamErr != nil {
+			log.Errorf("error streaming nq from prefix %s: %v", prefix, streamErr)
+		}
+		return streamErr
+	})
+}
+
+// Upload the nq produced by streamNq to graphs/latest/<releaseNqName> alongside
+// the bytesum of the graph; streamNq must close the channel when it is done
+func (synchronizer *SynchronizerClient) uploadReleaseGraph(ctx context.Context, releaseNqName string, compressGraphWithGzip bool, streamNq func(nqChan chan<- string) error) error {
 	const maximumNqFilesToProcessAtOnce = 30
 
 	nqChan := make(chan string, maximumNqFilesToProcessAtOnce)
@@ -242,12 +270,7 @@ func (synchronizer *SynchronizerClient) GenerateNqRelease(ctx context.Context, s
 
 	// Start processing NQ data concurrently
 	go func() {
-		// Don't close nqChan here - streamNqFromPrefix will close it
-		streamErr := synchronizer.streamNqFromPrefix(ctx, prefix, nqChan, mainstemFile)
-		if streamErr != nil {
-			log.Errorf("error streaming nq from prefix %s: %v", prefix, streamErr)
-		}
-		errChan <- streamErr
+		errChan <- streamNq(nqChan)
 	}()
 
 	pipeReader, pipeWriter := io.Pipe()
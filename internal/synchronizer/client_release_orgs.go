@@ -0,0 +1,176 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package synchronizer
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/internetofwater/nabu/internal/common"
+	"github.com/internetofwater/nabu/internal/crawl"
+	"github.com/internetofwater/nabu/internal/opentelemetry"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	rdfType          = "http://www.w3.org/1999/02/22-rdf-syntax-ns#type"
+	schemaNamespace  = "https://schema.org/"
+	dcatNamespace    = "http://www.w3.org/ns/dcat#"
+	dctermsNamespace = "http://purl.org/dc/terms/"
+	foafNamespace    = "http://xmlns.com/foaf/0.1/"
+	vcardNamespace   = "http://www.w3.org/2006/vcard/ns#"
+)
+
+// The name of the prefix the organizations graph is released from;
+// nothing is stored under it since the graph is built from the sitemap index
+const organizationsPrefix = "orgs/"
+
+// Return true if the value can be written as an iri in N-Quads without escaping
+func isSerializableIri(value string) bool {
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Scheme == "" {
+		return false
+	}
+	return !strings.ContainsAny(value, " <>\"{}|^`\\\n\r\t")
+}
+
+// Return the root url and host of the site that publishes the sitemap. The
+// documentation link is preferred since the sitemap itself may be hosted by a third party
+func publisherSite(metadata crawl.SitemapMetadata) (site string, host string, found bool) {
+	for _, link := range []string{metadata.DocumentationLink, metadata.Loc} {
+		parsed, err := url.Parse(link)
+		if err == nil && parsed.Scheme != "" && parsed.Host != "" {
+			return parsed.Scheme + "://" + parsed.Host, parsed.Host, true
+		}
+	}
+	return "", "", false
+}
+
+// Build a schema.org / DCAT graph describing each source in the sitemap index as a dataset,
+// with the organization that publishes it and who to contact about it. Organizations are
+// identified by the host of the dataset documentation so sources from the same provider share one
+func organizationsGraph(sitemaps []crawl.SitemapMetadata) (string, error) {
+	releaseNqName, err := makeReleaseNqName(organizationsPrefix)
+	if err != nil {
+		return "", err
+	}
+	graph, err := releaseGraphIRI(releaseNqName)
+	if err != nil {
+		return "", err
+	}
+
+	var quads strings.Builder
+	add := func(subject, predicate, object string) {
+		fmt.Fprintf(&quads, "<%s> <%s> %s <%s> .\n", subject, predicate, object, graph)
+	}
+	iri := func(value string) string { return "<" + value + ">" }
+
+	// the root url and host of each organization keyed by its iri
+	type organizationSite struct{ site, host string }
+	organizations := make(map[string]organizationSite)
+
+	for _, sitemap := range sitemaps {
+		if sitemap.SitemapID == "" {
+			return "", fmt.Errorf("sitemap %s has no sitemap id", sitemap.Loc)
+		}
+		dataset, err := common.MakeURN("datasets/" + sitemap.SitemapID)
+		if err != nil {
+			return "", err
+		}
+		id := common.NTriplesLiteral(sitemap.SitemapID)
+
+		add(dataset, rdfType, iri(schemaNamespace+"Dataset"))
+		add(dataset, rdfType, iri(dcatNamespace+"Dataset"))
+		add(dataset, schemaNamespace+"identifier", id)
+		add(dataset, dctermsNamespace+"identifier", id)
+		add(dataset, schemaNamespace+"name", id)
+		add(dataset, dctermsNamespace+"title", id)
+
+		if sitemap.DatasetDescription != "" {
+			description := common.NTriplesLiteral(sitemap.DatasetDescription)
+			add(dataset, schemaNamespace+"description", description)
+			add(dataset, dctermsNamespace+"description", description)
+		}
+
+		if isSerializableIri(sitemap.DocumentationLink) {
+			add(dataset, schemaNamespace+"url", iri(sitemap.DocumentationLink))
+			add(dataset, dcatNamespace+"landingPage", iri(sitemap.DocumentationLink))
+		} else if sitemap.DocumentationLink != "" {
+			log.Warnf("skipping documentation link %s for %s since it is not a valid iri", sitemap.DocumentationLink, sitemap.SitemapID)
+		}
+
+		if isSerializableIri(sitemap.Loc) {
+			add(dataset, dctermsNamespace+"source", iri(sitemap.Loc))
+		}
+
+		if site, host, ok := publisherSite(sitemap); ok {
+			organization, err := common.MakeURN("orgs/" + host)
+			if err != nil {
+				return "", err
+			}
+			organizations[organization] = organizationSite{site: site, host: host}
+			add(dataset, schemaNamespace+"publisher", iri(organization))
+			add(dataset, dctermsNamespace+"publisher", iri(organization))
+		}
+
+		if mailto := "mailto:" + sitemap.ContactEmail; sitemap.ContactEmail != "" && !isSerializableIri(mailto) {
+			log.Warnf("skipping contact email %s for %s since it is not a valid email address", sitemap.ContactEmail, sitemap.SitemapID)
+		} else if sitemap.ContactEmail != "" {
+			add(dataset, schemaNamespace+"contactPoint", iri(mailto))
+			add(dataset, dcatNamespace+"contactPoint", iri(mailto))
+			add(mailto, rdfType, iri(schemaNamespace+"ContactPoint"))
+			add(mailto, rdfType, iri(vcardNamespace+"Kind"))
+			add(mailto, schemaNamespace+"email", common.NTriplesLiteral(sitemap.ContactEmail))
+			add(mailto, vcardNamespace+"hasEmail", iri(mailto))
+		}
+	}
+
+	// sort the organizations so the graph and thus its bytesum is deterministic
+	organizationIds := make([]string, 0, len(organizations))
+	for organization := range organizations {
+		organizationIds = append(organizationIds, organization)
+	}
+	slices.Sort(organizationIds)
+	for _, organization := range organizationIds {
+		site := organizations[organization]
+		add(organization, rdfType, iri(schemaNamespace+"Organization"))
+		add(organization, rdfType, iri(foafNamespace+"Organization"))
+		add(organization, schemaNamespace+"name", common.NTriplesLiteral(site.host))
+		add(organization, schemaNamespace+"url", iri(site.site))
+	}
+
+	return quads.String(), nil
+}
+
+// Generate the organizations graph describing every source in the sitemap index
+// and upload it to graphs/latest/organizations.nq alongside its bytesum
+func (synchronizer *SynchronizerClient) GenerateOrgsRelease(ctx context.Context, index crawl.SitemapIndex, compressGraphWithGzip bool) error {
+	ctx, span := opentelemetry.SubSpanFromCtxWithName(ctx, "nq_release_graph_organizations")
+	defer span.End()
+
+	graph, err := organizationsGraph(index.Sitemaps)
+	if err != nil {
+		return err
+	}
+	if graph == "" {
+		return fmt.Errorf("the sitemap index contains no sitemaps to describe in the organizations graph")
+	}
+
+	releaseNqName, err := makeReleaseNqName(organizationsPrefix)
+	if err != nil {
+		return err
+	}
+	if compressGraphWithGzip {
+		releaseNqName += ".gz"
+	}
+
+	return synchronizer.uploadReleaseGraph(ctx, releaseNqName, compressGraphWithGzip, func(nqChan chan<- string) error {
+		defer close(nqChan)
+		nqChan <- graph
+		return nil
+	})
+}
@@ -0,0 +1,77 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package synchronizer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/internetofwater/nabu/internal/crawl"
+	"github.com/piprate/json-gold/ld"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrganizationsGraph(t *testing.T) {
+	sitemaps := []crawl.SitemapMetadata{
+		{
+			Loc:                "https://pids.geoconnex.dev/sitemap/cdss/co_gages__0.xml",
+			SitemapID:          "cdss/co_gages__0",
+			DatasetDescription: "Colorado \"stream\" gages",
+			DocumentationLink:  "https://dwr.colorado.gov/services/data-information",
+			ContactEmail:       "dwr@state.co.us",
+		},
+		{
+			Loc:               "https://pids.geoconnex.dev/sitemap/cdss/co_wells__0.xml",
+			SitemapID:         "cdss/co_wells__0",
+			DocumentationLink: "https://dwr.colorado.gov/services/wells",
+		},
+		{
+			Loc:          "https://example.com/sitemap.xml",
+			SitemapID:    "example",
+			ContactEmail: "not an email",
+		},
+	}
+
+	graph, err := organizationsGraph(sitemaps)
+	require.NoError(t, err)
+
+	dataset, err := ld.ParseNQuads(graph)
+	require.NoError(t, err, "the graph should be valid N-Quads")
+	require.Contains(t, dataset.Graphs, "urn:iow:graphs:organizations")
+	require.Empty(t, dataset.Graphs["@default"], "every statement should be in the organizations graph")
+
+	t.Run("datasets are described", func(t *testing.T) {
+		require.Contains(t, graph, `<urn:iow:datasets:cdss:co_gages__0> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <https://schema.org/Dataset> <urn:iow:graphs:organizations> .`)
+		require.Contains(t, graph, `<urn:iow:datasets:cdss:co_gages__0> <http://www.w3.org/ns/dcat#landingPage> <https://dwr.colorado.gov/services/data-information> <urn:iow:graphs:organizations> .`)
+		require.Contains(t, graph, `<urn:iow:datasets:cdss:co_gages__0> <https://schema.org/description> "Colorado \"stream\" gages" <urn:iow:graphs:organizations> .`)
+		require.Contains(t, graph, `<urn:iow:datasets:cdss:co_gages__0> <http://purl.org/dc/terms/source> <https://pids.geoconnex.dev/sitemap/cdss/co_gages__0.xml> <urn:iow:graphs:organizations> .`)
+	})
+
+	t.Run("sources from the same site share a publisher", func(t *testing.T) {
+		require.Contains(t, graph, `<urn:iow:datasets:cdss:co_gages__0> <https://schema.org/publisher> <urn:iow:orgs:dwr.colorado.gov> <urn:iow:graphs:organizations> .`)
+		require.Contains(t, graph, `<urn:iow:datasets:cdss:co_wells__0> <https://schema.org/publisher> <urn:iow:orgs:dwr.colorado.gov> <urn:iow:graphs:organizations> .`)
+		require.Equal(t, 1, strings.Count(graph, `<urn:iow:orgs:dwr.colorado.gov> <https://schema.org/url> <https://dwr.colorado.gov>`))
+	})
+
+	t.Run("publisher falls back to the sitemap host", func(t *testing.T) {
+		require.Contains(t, graph, `<urn:iow:datasets:example> <https://schema.org/publisher> <urn:iow:orgs:example.com> <urn:iow:graphs:organizations> .`)
+	})
+
+	t.Run("contact points are described", func(t *testing.T) {
+		require.Contains(t, graph, `<urn:iow:datasets:cdss:co_gages__0> <http://www.w3.org/ns/dcat#contactPoint> <mailto:dwr@state.co.us> <urn:iow:graphs:organizations> .`)
+		require.Contains(t, graph, `<mailto:dwr@state.co.us> <https://schema.org/email> "dwr@state.co.us" <urn:iow:graphs:organizations> .`)
+		require.NotContains(t, graph, "not an email", "invalid emails should be skipped")
+	})
+
+	t.Run("graph is deterministic", func(t *testing.T) {
+		again, err := organizationsGraph(sitemaps)
+		require.NoError(t, err)
+		require.Equal(t, graph, again)
+	})
+
+	t.Run("sitemaps without an id are an error", func(t *testing.T) {
+		_, err := organizationsGraph([]crawl.SitemapMetadata{{Loc: "https://example.com/sitemap.xml"}})
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,225 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package synchronizer
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/internetofwater/nabu/internal/common"
+	"github.com/internetofwater/nabu/internal/crawl"
+	"github.com/internetofwater/nabu/internal/crawl/storage"
+	"github.com/internetofwater/nabu/internal/opentelemetry"
+	"github.com/minio/minio-go/v7"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// the prefix in s3 that contains all release graphs and their bytesums
+const latestGraphsPrefix = "graphs/latest/"
+
+// Return the names of all release graphs in graphs/latest that belong to a sitemap
+// in the sitemap index; graphs for sitemaps that were removed from the index are not included
+func expectedReleaseGraphNames(index crawl.SitemapIndex) (storage.Set, error) {
+	expected := make(storage.Set)
+
+	organizations, err := makeReleaseNqName("orgs/")
+	if err != nil {
+		return nil, err
+	}
+	expected.Add(organizations)
+
+	for _, sitemap := range index.Sitemaps {
+		for _, prefix := range []string{"summoned/", "prov/"} {
+			name, err := makeReleaseNqName(prefix + sitemap.SitemapID)
+			if err != nil {
+				return nil, err
+			}
+			expected.Add(name)
+		}
+	}
+	// each graph may also have been released with compression
+	for name := range expected {
+		expected.Add(name + ".gz")
+	}
+	return expected, nil
+}
+
+// Return true if the release graph belongs to a sitemap within an entry of the index that is a
+// nested sitemap index. Those sitemaps are named after the id of the entry so they are matched
+// by name instead of fetching every sitemap in the index to resolve the nested indexes
+func belongsToNestedSitemap(index crawl.SitemapIndex, releaseGraphName string) bool {
+	for _, sitemap := range index.Sitemaps {
+		if strings.HasPrefix(releaseGraphName, sitemap.SitemapID+crawl.NestedSitemapIdSeparator) {
+			return true
+		}
+	}
+	return false
+}
+
+// Read the bytesum associated with a release graph in s3; an empty
+// string is returned if the graph has no bytesum
+func (synchronizer *SynchronizerClient) getReleaseByteSum(ctx context.Context, releaseGraphKey string) (string, error) {
+	obj, err := synchronizer.S3Client.Client.GetObject(ctx, synchronizer.syncBucketName, releaseGraphKey+".bytesum", minio.GetObjectOptions{})
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = obj.Close() }()
+	sum, err := io.ReadAll(obj)
+	if err != nil {
+		// This is a string from the s3 spec, not an arbitrary magic val
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(sum)), nil
+}
+
+// Stream a release graph from s3 into its named graph in the triplestore
+func (synchronizer *SynchronizerClient) loadReleaseGraph(ctx context.Context, releaseGraphKey string, graphIRI common.URN) error {
+	obj, err := synchronizer.S3Client.Client.GetObject(ctx, synchronizer.syncBucketName, releaseGraphKey, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = obj.Close() }()
+
+	var nquads io.Reader = obj
+	if strings.HasSuffix(releaseGraphKey, ".gz") {
+		unzipper, err := gzip.NewReader(obj)
+		if err != nil {
+			return fmt.Errorf("failed to decompress %s: %w", releaseGraphKey, err)
+		}
+		defer func() { _ = unzipper.Close() }()
+		nquads = unzipper
+	}
+
+	// the graph store protocol only accepts triples when targeting a named graph
+	// so we convert the quads while streaming them to the triplestore
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		pipeWriter.CloseWithError(writeNquadsAsNtriples(nquads, pipeWriter))
+	}()
+
+	err = synchronizer.GraphClient.PutGraph(ctx, graphIRI, pipeReader)
+	// make sure the conversion goroutine exits if the upload failed before consuming everything
+	_ = pipeReader.CloseWithError(err)
+	return err
+}
+
+// Sync the triplestore with the release graphs in graphs/latest. Each release graph
+// is loaded into its own named graph using the SPARQL 1.1 Graph Store HTTP Protocol.
+// Graphs whose bytesum has not changed since the last sync are skipped and graphs
+// whose sitemap is no longer in the sitemap index are dropped
+func (synchronizer *SynchronizerClient) SyncGraphs(ctx context.Context, index crawl.SitemapIndex) error {
+	if synchronizer.GraphClient == nil {
+		return fmt.Errorf("no sparql endpoint was specified so the triplestore cannot be synced")
+	}
+	ctx, span := opentelemetry.SubSpanFromCtxWithName(ctx, "sync_graphs")
+	defer span.End()
+
+	expectedGraphNames, err := expectedReleaseGraphNames(index)
+	if err != nil {
+		return err
+	}
+
+	objects, err := synchronizer.S3Client.ObjectList(ctx, latestGraphsPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list release graphs in %s: %w", latestGraphsPrefix, err)
+	}
+
+	// map of the graph iri in the triplestore to the s3 key of the release graph that should be loaded into it
+	graphToKey := make(map[common.URN]string)
+	for _, obj := range objects {
+		name := path.Base(obj.Key)
+		if !strings.HasSuffix(name, ".nq") && !strings.HasSuffix(name, ".nq.gz") {
+			continue
+		}
+		if !expectedGraphNames.Contains(name) && !belongsToNestedSitemap(index, name) {
+			log.Warnf("Skipping %s since it does not belong to any sitemap in the sitemap index", obj.Key)
+			continue
+		}
+		graphIRI, err := releaseGraphIRI(name)
+		if err != nil {
+			return err
+		}
+		if previousKey, ok := graphToKey[graphIRI]; ok {
+			log.Errorf("Found both %s and %s in %s; you should generally not store both a compressed and uncompressed release. Using the compressed version", previousKey, obj.Key, latestGraphsPrefix)
+			if strings.HasSuffix(previousKey, ".gz") {
+				continue
+			}
+		}
+		graphToKey[graphIRI] = obj.Key
+	}
+
+	state := make(map[common.URN]string)
+	stateReader, exists, err := synchronizer.GraphClient.GetGraph(ctx, syncStateGraph)
+	if err != nil {
+		return fmt.Errorf("failed to get the sync state from the triplestore: %w", err)
+	}
+	if exists {
+		state, err = parseSyncState(stateReader)
+		_ = stateReader.Close()
+		if err != nil {
+			return err
+		}
+	} else {
+		log.Infof("No sync state found in the triplestore at %s; all release graphs will be loaded", syncStateGraph)
+	}
+
+	// we write the state back even if a graph fails to load
+	// so that the graphs which were synced are not loaded again
+	defer func() {
+		if err := synchronizer.GraphClient.PutGraph(ctx, syncStateGraph, strings.NewReader(serializeSyncState(state))); err != nil {
+			log.Errorf("failed to update the sync state in the triplestore: %v", err)
+		}
+	}()
+
+	loaded, skipped, dropped := 0, 0, 0
+
+	for graphIRI, key := range graphToKey {
+		byteSum, err := synchronizer.getReleaseByteSum(ctx, key)
+		if err != nil {
+			return fmt.Errorf("failed to get the bytesum for %s: %w", key, err)
+		}
+		if byteSum == "" {
+			log.Warnf("%s has no associated bytesum so it will always be loaded", key)
+		} else if previousSum, ok := state[graphIRI]; ok && previousSum == byteSum {
+			log.Debugf("Skipping %s since its bytesum has not changed since the last sync", key)
+			skipped++
+			continue
+		}
+
+		log.Infof("Loading %s into graph %s", key, graphIRI)
+		_, subspan := opentelemetry.SubSpanFromCtxWithName(ctx, fmt.Sprintf("sync_graph_%s", graphIRI))
+		err = synchronizer.loadReleaseGraph(ctx, key, graphIRI)
+		subspan.End()
+		if err != nil {
+			// drop the state so a partially loaded graph is reloaded next time
+			delete(state, graphIRI)
+			return fmt.Errorf("failed to load %s into the triplestore: %w", key, err)
+		}
+		state[graphIRI] = byteSum
+		loaded++
+	}
+
+	for graphIRI := range state {
+		if _, ok := graphToKey[graphIRI]; ok {
+			continue
+		}
+		log.Infof("Dropping graph %s since its sitemap is no longer in the sitemap index", graphIRI)
+		if err := synchronizer.GraphClient.DeleteGraph(ctx, graphIRI); err != nil {
+			return fmt.Errorf("failed to drop graph %s: %w", graphIRI, err)
+		}
+		delete(state, graphIRI)
+		dropped++
+	}
+
+	log.Infof("Finished syncing the triplestore: loaded %d graphs, skipped %d unchanged graphs, dropped %d outdated graphs", loaded, skipped, dropped)
+	return nil
+}
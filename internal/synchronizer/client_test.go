@@ -15,8 +15,10 @@ import (
 	"time"
 
 	"github.com/internetofwater/nabu/internal/common"
+	"github.com/internetofwater/nabu/internal/config"
 	"github.com/internetofwater/nabu/internal/crawl"
 	"github.com/internetofwater/nabu/internal/synchronizer/s3"
+	"github.com/internetofwater/nabu/internal/synchronizer/triplestore"
 
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
@@ -232,6 +234,114 @@ func (suite *SynchronizerClientSuite) TestNqRelease() {
 	})
 }
 
+func (suite *SynchronizerClientSuite) TestOrgsRelease() {
+	t := suite.T()
+
+	index := crawl.SitemapIndex{Sitemaps: []crawl.SitemapMetadata{
+		{
+			Loc:                "https://pids.geoconnex.dev/sitemap/cdss/co_gages__0.xml",
+			SitemapID:          "cdss/co_gages__0",
+			DatasetDescription: "Colorado stream gages",
+			DocumentationLink:  "https://dwr.colorado.gov/services/data-information",
+			ContactEmail:       "dwr@state.co.us",
+		},
+	}}
+
+	err := suite.client.GenerateOrgsRelease(context.Background(), index, false)
+	require.NoError(t, err)
+
+	const orgsPath = "graphs/latest/organizations.nq"
+	objs, err := suite.client.S3Client.NumberOfMatchingObjects([]string{orgsPath})
+	require.NoError(t, err)
+	require.Equal(t, 2, objs, "the graph and its bytesum should be uploaded")
+
+	data, err := suite.client.S3Client.GetObjectAsBytes(orgsPath)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `<urn:iow:datasets:cdss:co_gages__0> <https://schema.org/description> "Colorado stream gages" <urn:iow:graphs:organizations> .`)
+
+	bytesum, err := suite.client.S3Client.GetObjectAsBytes(orgsPath + ".bytesum")
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("%d", common.ByteSum(data)), string(bytesum))
+}
+
+func (suite *SynchronizerClientSuite) TestSyncGraphs() {
+	t := suite.T()
+
+	store, server := triplestore.NewMockGraphStoreServer()
+	defer server.Close()
+	graphClient, err := triplestore.NewGraphStoreClient(config.SparqlConfig{Endpoint: server.URL}, nil)
+	require.NoError(t, err)
+
+	syncClient := suite.client
+	syncClient.GraphClient = graphClient
+
+	const sourceA = "sync_test_a"
+	const sourceB = "sync_test_b"
+	const quadA = "<https://example.com/a> <https://schema.org/name> \"a\" <urn:iow:summoned:sync_test_a:a.jsonld> .\n"
+	const quadB = "<https://example.com/b> <https://schema.org/name> \"b\" <urn:iow:summoned:sync_test_b:b.jsonld> .\n"
+
+	uploadRelease := func(source, quads string) {
+		releasePath := "graphs/latest/" + source + "_release.nq"
+		err := syncClient.S3Client.StoreWithoutServersideHash(releasePath, strings.NewReader(quads))
+		require.NoError(t, err)
+		err = syncClient.S3Client.StoreWithoutServersideHash(releasePath+".bytesum", strings.NewReader(fmt.Sprintf("%d", common.ByteSum([]byte(quads)))))
+		require.NoError(t, err)
+	}
+	uploadRelease(sourceA, quadA)
+	uploadRelease(sourceB, quadB)
+	defer func() {
+		for _, source := range []string{sourceA, sourceB} {
+			_ = syncClient.S3Client.Remove("graphs/latest/" + source + "_release.nq")
+			_ = syncClient.S3Client.Remove("graphs/latest/" + source + "_release.nq.bytesum")
+		}
+	}()
+
+	index := crawl.SitemapIndex{Sitemaps: []crawl.SitemapMetadata{{SitemapID: sourceA}, {SitemapID: sourceB}}}
+
+	t.Run("graphs are loaded as triples into named graphs", func(t *testing.T) {
+		err := syncClient.SyncGraphs(context.Background(), index)
+		require.NoError(t, err)
+
+		graphA, ok := store.Graph("urn:iow:graphs:" + sourceA + "_release")
+		require.True(t, ok)
+		require.Equal(t, "<https://example.com/a> <https://schema.org/name> \"a\" .\n", graphA)
+		_, ok = store.Graph("urn:iow:graphs:" + sourceB + "_release")
+		require.True(t, ok)
+		_, ok = store.Graph(syncStateGraph)
+		require.True(t, ok, "sync state should be stored in the triplestore")
+	})
+
+	t.Run("unchanged graphs are skipped", func(t *testing.T) {
+		putsBefore := store.Puts()
+		err := syncClient.SyncGraphs(context.Background(), index)
+		require.NoError(t, err)
+		// only the sync state itself should be written
+		require.Equal(t, putsBefore+1, store.Puts())
+	})
+
+	t.Run("changed graphs are reloaded", func(t *testing.T) {
+		const newQuadA = "<https://example.com/a> <https://schema.org/name> \"new a\" <urn:iow:summoned:sync_test_a:a.jsonld> .\n"
+		uploadRelease(sourceA, newQuadA)
+		putsBefore := store.Puts()
+		err := syncClient.SyncGraphs(context.Background(), index)
+		require.NoError(t, err)
+		require.Equal(t, putsBefore+2, store.Puts())
+		graphA, ok := store.Graph("urn:iow:graphs:" + sourceA + "_release")
+		require.True(t, ok)
+		require.Contains(t, graphA, "new a")
+	})
+
+	t.Run("graphs for sitemaps removed from the index are dropped", func(t *testing.T) {
+		onlyA := crawl.SitemapIndex{Sitemaps: []crawl.SitemapMetadata{{SitemapID: sourceA}}}
+		err := syncClient.SyncGraphs(context.Background(), onlyA)
+		require.NoError(t, err)
+		_, ok := store.Graph("urn:iow:graphs:" + sourceB + "_release")
+		require.False(t, ok)
+		_, ok = store.Graph("urn:iow:graphs:" + sourceA + "_release")
+		require.True(t, ok)
+	})
+}
+
 func TestSynchronizerClientSuite(t *testing.T) {
 	suite.Run(t, new(SynchronizerClientSuite))
 }
@@ -4,11 +4,13 @@
 package synchronizer
 
 import (
+	"bufio"
 	"compress/gzip"
 	"fmt"
 	"io"
 	"path"
 	"slices"
+	"sort"
 	"strings"
 	"time"
 
@@ -109,3 +111,121 @@ func writeToPipeAndGetByteSum(compress bool, nqChan <-chan string, pipeWriter *i
 
 	return hashDestination.ToString(), nil
 }
+
+// given the name of a release graph in graphs/latest, return the
+// iri of the named graph it is loaded into in the triplestore;
+// the compressed and uncompressed version of a release share the same graph
+func releaseGraphIRI(releaseNqName string) (common.URN, error) {
+	name := strings.TrimSuffix(releaseNqName, ".gz")
+	if !strings.HasSuffix(name, ".nq") {
+		return "", fmt.Errorf("%s is not an nq release graph", releaseNqName)
+	}
+	// i.e. counties0_release.nq.gz would become urn:iow:graphs:counties0_release
+	return common.MakeURN("graphs/" + getTextBeforeDot(name))
+}
+
+// Convert a single N-Quad to an N-Triple by dropping its graph label.
+// Release graphs are always serialized as quads so every statement is expected
+// to end with a graph label; an iri cannot contain spaces or '<' so the last '<' on the
+// line is always the start of the graph label
+func nquadToNtriple(quad string) (string, error) {
+	statement := strings.TrimSpace(quad)
+	if !strings.HasSuffix(statement, ".") {
+		return "", fmt.Errorf("n-quad '%s' is not terminated with a '.'", quad)
+	}
+	statement = strings.TrimSpace(strings.TrimSuffix(statement, "."))
+
+	var graphStart int
+	if strings.HasSuffix(statement, ">") {
+		graphStart = strings.LastIndex(statement, "<")
+	} else {
+		// blank node graph label
+		graphStart = strings.LastIndex(statement, " ") + 1
+	}
+	triple := strings.TrimSpace(statement[:max(graphStart, 0)])
+	if graphStart <= 0 || triple == "" {
+		return "", fmt.Errorf("n-quad '%s' does not contain a graph label", quad)
+	}
+	return triple + " .\n", nil
+}
+
+// Stream all quads from the reader to the writer as triples
+func writeNquadsAsNtriples(nquads io.Reader, ntriples io.Writer) error {
+	// we use a reader and not a scanner since a single
+	// quad can contain an arbitrarily large literal like a WKT polygon
+	reader := bufio.NewReader(nquads)
+	writer := bufio.NewWriter(ntriples)
+	for {
+		line, readErr := reader.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+		if strings.TrimSpace(line) != "" {
+			triple, err := nquadToNtriple(line)
+			if err != nil {
+				return err
+			}
+			if _, err := writer.WriteString(triple); err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	return writer.Flush()
+}
+
+// the named graph in the triplestore that records the bytesum
+// of every release graph at the time it was last synced
+const syncStateGraph = "urn:iow:graphs:sync_state"
+
+// the predicate relating a synced graph to its bytesum in the sync state graph
+const bytesumPredicate = "https://geoconnex.us/nabu/bytesum"
+
+// Parse the n-triples of the sync state graph into
+// a map of graph iri to the bytesum it was synced with
+func parseSyncState(ntriples io.Reader) (map[common.URN]string, error) {
+	state := make(map[common.URN]string)
+	scanner := bufio.NewScanner(ntriples)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		const subjectPredicateObject = 3
+		if len(fields) < subjectPredicateObject {
+			return nil, fmt.Errorf("invalid triple in sync state graph: %s", line)
+		}
+		if fields[1] != "<"+bytesumPredicate+">" {
+			continue
+		}
+		subject := strings.TrimSuffix(strings.TrimPrefix(fields[0], "<"), ">")
+		// triplestores may add an explicit xsd:string datatype
+		// to the literal so we only take what is inside the quotes
+		object := fields[2]
+		start := strings.Index(object, "\"")
+		end := strings.LastIndex(object, "\"")
+		if start == -1 || end <= start {
+			return nil, fmt.Errorf("invalid bytesum literal in sync state graph: %s", line)
+		}
+		state[subject] = object[start+1 : end]
+	}
+	return state, scanner.Err()
+}
+
+// Serialize the sync state as n-triples; sorted so the output is deterministic
+func serializeSyncState(state map[common.URN]string) string {
+	graphs := make([]string, 0, len(state))
+	for graph := range state {
+		graphs = append(graphs, graph)
+	}
+	sort.Strings(graphs)
+
+	var builder strings.Builder
+	for _, graph := range graphs {
+		fmt.Fprintf(&builder, "<%s> <%s> \"%s\" .\n", graph, bytesumPredicate, state[graph])
+	}
+	return builder.String()
+}
@@ -7,8 +7,10 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"io"
+	"strings"
 	"testing"
 
+	"github.com/internetofwater/nabu/internal/crawl"
 	"github.com/stretchr/testify/require"
 )
 
@@ -44,6 +46,19 @@ func TestMakeReleaseName(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestNestedSitemapReleaseNames(t *testing.T) {
+	// sitemaps within a nested index are released like any other id with a colon
+	res, err := makeReleaseNqName("summoned/provider:stations")
+	require.NoError(t, err)
+	require.Equal(t, "provider:stations_release.nq", res)
+
+	index := crawl.SitemapIndex{Sitemaps: []crawl.SitemapMetadata{{SitemapID: "provider"}}}
+	require.True(t, belongsToNestedSitemap(index, res))
+	require.True(t, belongsToNestedSitemap(index, "provider:stations_prov.nq.gz"))
+	require.False(t, belongsToNestedSitemap(index, "provider_release.nq"), "the entry itself is matched by its exact name")
+	require.False(t, belongsToNestedSitemap(index, "provider2:stations_release.nq"))
+}
+
 func compressWithDeterministicWriter(data []byte) ([]byte, error) {
 	var buf bytes.Buffer
 	writer, err := deterministicGzipWriter(&buf)
@@ -123,3 +138,53 @@ func TestWriteToPipeAndGetHash_Deterministic(t *testing.T) {
 	// Sanity check: different compression settings should produce different hashes
 	require.NotEqual(t, hash1, hash3, "Compressed and uncompressed hashes should differ")
 }
+
+func TestReleaseGraphIRI(t *testing.T) {
+	iri, err := releaseGraphIRI("counties0_release.nq")
+	require.NoError(t, err)
+	require.Equal(t, "urn:iow:graphs:counties0_release", iri)
+
+	compressedIri, err := releaseGraphIRI("counties0_release.nq.gz")
+	require.NoError(t, err)
+	require.Equal(t, iri, compressedIri, "the compressed and uncompressed release should be loaded into the same graph")
+
+	_, err = releaseGraphIRI("counties0_release.nq.bytesum")
+	require.Error(t, err)
+}
+
+func TestNquadToNtriple(t *testing.T) {
+	triple, err := nquadToNtriple(`<https://example.com/a> <https://schema.org/name> "a b" <urn:iow:summoned:test:a.jsonld> .`)
+	require.NoError(t, err)
+	require.Equal(t, "<https://example.com/a> <https://schema.org/name> \"a b\" .\n", triple)
+
+	triple, err = nquadToNtriple(`<https://example.com/a> <https://schema.org/value> "1"^^<http://www.w3.org/2001/XMLSchema#integer> <urn:iow:summoned:test:a.jsonld> .` + "\n")
+	require.NoError(t, err)
+	require.Equal(t, "<https://example.com/a> <https://schema.org/value> \"1\"^^<http://www.w3.org/2001/XMLSchema#integer> .\n", triple)
+
+	triple, err = nquadToNtriple(`_:b0 <https://schema.org/name> "a" _:g0 .`)
+	require.NoError(t, err)
+	require.Equal(t, "_:b0 <https://schema.org/name> \"a\" .\n", triple)
+
+	_, err = nquadToNtriple(`<https://example.com/a> <https://schema.org/name> "a"`)
+	require.Error(t, err)
+	_, err = nquadToNtriple(`<urn:iow:summoned:test:a.jsonld> .`)
+	require.Error(t, err)
+}
+
+func TestSyncStateRoundTrip(t *testing.T) {
+	state := map[string]string{
+		"urn:iow:graphs:b_release": "200",
+		"urn:iow:graphs:a_release": "100",
+	}
+	serialized := serializeSyncState(state)
+	require.Equal(t, "<urn:iow:graphs:a_release> <https://geoconnex.us/nabu/bytesum> \"100\" .\n<urn:iow:graphs:b_release> <https://geoconnex.us/nabu/bytesum> \"200\" .\n", serialized)
+
+	parsed, err := parseSyncState(strings.NewReader(serialized))
+	require.NoError(t, err)
+	require.Equal(t, state, parsed)
+
+	// triplestores may return the literal with an explicit datatype
+	parsed, err = parseSyncState(strings.NewReader("<urn:iow:graphs:a_release> <https://geoconnex.us/nabu/bytesum> \"100\"^^<http://www.w3.org/2001/XMLSchema#string> .\n"))
+	require.NoError(t, err)
+	require.Equal(t, "100", parsed["urn:iow:graphs:a_release"])
+}
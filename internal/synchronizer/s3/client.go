@@ -191,6 +191,36 @@ func (m MinioClientWrapper) GetHash(objectName S3Prefix) (storage.Md5Hash, bool,
 	return result.ETag, true, nil
 }
 
+// Get the ETag of every object under the prefix from a single listing instead of
+// one StatObject request per object; multipart uploads are left out since their
+// ETag is not the hash of the raw content
+func (m MinioClientWrapper) ListDirHashes(path S3Prefix) (map[storage.ObjectPath]storage.Md5Hash, error) {
+	objs, err := m.ObjectList(context.Background(), path)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make(map[storage.ObjectPath]storage.Md5Hash, len(objs))
+	for _, obj := range objs {
+		if obj.ETag == "" || strings.Contains(obj.ETag, "-") {
+			continue
+		}
+		hashes[obj.Key] = obj.ETag
+	}
+	return hashes, nil
+}
+
+// Get the sha256 of the file that was recorded in its metadata when it was stored
+func (m MinioClientWrapper) GetSha256(objectName S3Prefix) (storage.Sha256Hash, bool, error) {
+	result, err := m.Client.StatObject(context.Background(), m.DefaultBucket, objectName, minio.GetObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return result.UserMetadata[sha256MetadataKey], true, nil
+}
+
 // Return the number of objects that match a given prefix within the
 // specified bucket
 func (m *MinioClientWrapper) NumberOfMatchingObjects(prefixes []S3Prefix) (int, error) {
@@ -249,9 +279,23 @@ func (m *MinioClientWrapper) UploadFile(uploadPath S3Prefix, localFileName strin
 	return err
 }
 
+// The user metadata key used to record the sha256 of an object;
+// this is independent of the ETag so it is still correct for multipart uploads
+const sha256MetadataKey = "Sha256"
+
 // StoreWithServersideHash bytes into the minio store
 func (m MinioClientWrapper) StoreWithHash(path S3Prefix, data io.Reader, sizeInBytes int) error {
-	_, err := m.Client.PutObject(context.Background(), m.DefaultBucket, path, data, int64(sizeInBytes), minio.PutObjectOptions{})
+	opts := minio.PutObjectOptions{}
+	sha, hashed, err := storage.Sha256OfSeekableReader(data)
+	if err != nil {
+		return err
+	}
+	if hashed {
+		opts.UserMetadata = map[string]string{sha256MetadataKey: sha}
+	} else {
+		log.Debugf("Data for %s is not seekable so no sha256 will be recorded for it", path)
+	}
+	_, err = m.Client.PutObject(context.Background(), m.DefaultBucket, path, data, int64(sizeInBytes), opts)
 	return err
 }
 
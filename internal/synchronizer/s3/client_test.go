@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/md5"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"os"
@@ -554,6 +555,44 @@ func (suite *S3ClientSuite) TestGetMD5HashServerside() {
 
 }
 
+func (suite *S3ClientSuite) TestListDirHashes() {
+	const prefix = "list_hashes_test/"
+	data := []byte("test data")
+	md5String := fmt.Sprintf("%x", md5.Sum(data))
+	err := suite.minioContainer.ClientWrapper.StoreWithHash(prefix+"test", bytes.NewReader(data), len(data))
+	suite.Require().NoError(err)
+	const undefinedSize = -1
+	err = suite.minioContainer.ClientWrapper.StoreWithHash(prefix+"testNoHash", bytes.NewReader(data), undefinedSize)
+	suite.Require().NoError(err)
+
+	hashes, err := suite.minioContainer.ClientWrapper.ListDirHashes(prefix)
+	suite.Require().NoError(err)
+	suite.Require().Equal(map[string]string{prefix + "test": md5String}, hashes, "the multipart upload has no hash of its content")
+}
+
+func (suite *S3ClientSuite) TestGetSha256() {
+	const prefix = "sha256_test/"
+	data := []byte("test data")
+	sha256String := fmt.Sprintf("%x", sha256.Sum256(data))
+
+	// the sha256 is independent of the etag so it is recorded even when minio can't make an md5
+	const undefinedSize = -1
+	err := suite.minioContainer.ClientWrapper.StoreWithHash(prefix+"test", bytes.NewReader(data), undefinedSize)
+	suite.Require().NoError(err)
+	hash, exists, err := suite.minioContainer.ClientWrapper.GetSha256(prefix + "test")
+	suite.Require().NoError(err)
+	suite.Require().True(exists)
+	suite.Require().Equal(sha256String, hash)
+
+	stored, err := suite.minioContainer.ClientWrapper.GetObjectAsBytes(prefix + "test")
+	suite.Require().NoError(err)
+	suite.Require().Equal(data, stored, "hashing the data should not consume it before it is uploaded")
+
+	_, exists, err = suite.minioContainer.ClientWrapper.GetSha256(prefix + "nonexistent")
+	suite.Require().NoError(err)
+	suite.Require().False(exists)
+}
+
 func (suite *S3ClientSuite) TestStoreBulk() {
 	const numItems = 1000
 	items := make(chan storage.BulkStorageItem, numItems)
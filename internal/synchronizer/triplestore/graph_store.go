@@ -0,0 +1,172 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package triplestore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/internetofwater/nabu/internal/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// The mimetype used for all graph payloads sent to and read from the triplestore
+const NTriplesMimeType = "application/n-triples"
+
+// A client for a triplestore that implements the SPARQL 1.1 Graph Store HTTP Protocol
+// https://www.w3.org/TR/sparql11-http-rdf-update/
+// Every operation uses indirect graph identification; i.e. ?graph=<iri>
+// so that it is portable across database implementations
+type GraphStoreClient struct {
+	// the graph store protocol endpoint of the triplestore
+	endpoint *url.URL
+	// credentials for basic auth; left blank if the triplestore is unauthenticated
+	username string
+	password string
+	// the client used for all requests against the triplestore
+	httpClient *http.Client
+}
+
+// Create a new client for the graph store protocol endpoint in the config
+func NewGraphStoreClient(sparqlConfig config.SparqlConfig, httpClient *http.Client) (*GraphStoreClient, error) {
+	if sparqlConfig.Endpoint == "" {
+		return nil, fmt.Errorf("no graph store endpoint specified")
+	}
+	endpoint, err := url.Parse(sparqlConfig.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid graph store endpoint %s: %w", sparqlConfig.Endpoint, err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("graph store endpoint %s must be an http or https url", sparqlConfig.Endpoint)
+	}
+	if httpClient == nil {
+		// graph uploads can be very large so we don't set a timeout
+		// on the client and instead rely on the context of each request
+		httpClient = &http.Client{}
+	}
+	return &GraphStoreClient{
+		endpoint:   endpoint,
+		username:   sparqlConfig.Username,
+		password:   sparqlConfig.Password,
+		httpClient: httpClient,
+	}, nil
+}
+
+// Build a request against the endpoint for the given named graph
+func (c *GraphStoreClient) newGraphRequest(ctx context.Context, method string, graphIRI string, body io.Reader) (*http.Request, error) {
+	if graphIRI == "" {
+		return nil, fmt.Errorf("graph iri cannot be empty")
+	}
+	graphUrl := *c.endpoint
+	query := graphUrl.Query()
+	query.Set("graph", graphIRI)
+	graphUrl.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, graphUrl.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if c.username != "" || c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	return req, nil
+}
+
+// Return a reader to the n-triples in the named graph; the boolean
+// is false if the graph does not exist in the triplestore
+// The caller is responsible for closing the reader
+func (c *GraphStoreClient) GetGraph(ctx context.Context, graphIRI string) (io.ReadCloser, bool, error) {
+	req, err := c.newGraphRequest(ctx, http.MethodGet, graphIRI, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Accept", NTriplesMimeType)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		_ = resp.Body.Close()
+		return nil, false, nil
+	}
+	if resp.StatusCode >= 300 {
+		defer func() { _ = resp.Body.Close() }()
+		return nil, false, newGraphStoreError(resp, http.MethodGet, graphIRI)
+	}
+	return resp.Body, true, nil
+}
+
+// Replace all the triples in the named graph with the n-triples
+// in the reader, creating the graph if it does not exist
+func (c *GraphStoreClient) PutGraph(ctx context.Context, graphIRI string, nTriples io.Reader) error {
+	req, err := c.newGraphRequest(ctx, http.MethodPut, graphIRI, nTriples)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", NTriplesMimeType)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode >= 300 {
+		return newGraphStoreError(resp, http.MethodPut, graphIRI)
+	}
+	log.Debugf("Replaced graph %s in the triplestore", graphIRI)
+	return nil
+}
+
+// Drop the named graph from the triplestore; dropping
+// a graph that doesn't exist is not an error
+func (c *GraphStoreClient) DeleteGraph(ctx context.Context, graphIRI string) error {
+	req, err := c.newGraphRequest(ctx, http.MethodDelete, graphIRI, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode == http.StatusNotFound {
+		log.Debugf("Graph %s did not exist in the triplestore so there was nothing to drop", graphIRI)
+		return nil
+	}
+	if resp.StatusCode >= 300 {
+		return newGraphStoreError(resp, http.MethodDelete, graphIRI)
+	}
+	log.Debugf("Dropped graph %s from the triplestore", graphIRI)
+	return nil
+}
+
+// An error returned when the triplestore responds with a non successful status
+type GraphStoreError struct {
+	Method     string
+	Graph      string
+	StatusCode int
+	Message    string
+}
+
+func (e GraphStoreError) Error() string {
+	return fmt.Sprintf("graph store %s request for graph %s failed with status %d: %s", e.Method, e.Graph, e.StatusCode, e.Message)
+}
+
+func newGraphStoreError(resp *http.Response, method string, graphIRI string) GraphStoreError {
+	// only read the start of the body since some triplestores
+	// return their entire stack trace in the response
+	const maxMessageBytes = 1024
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxMessageBytes))
+	return GraphStoreError{
+		Method:     method,
+		Graph:      graphIRI,
+		StatusCode: resp.StatusCode,
+		Message:    string(body),
+	}
+}
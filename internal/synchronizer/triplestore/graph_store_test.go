@@ -0,0 +1,79 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package triplestore
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/internetofwater/nabu/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGraphStoreRoundTrip(t *testing.T) {
+	store, server := NewMockGraphStoreServer()
+	defer server.Close()
+
+	client, err := NewGraphStoreClient(config.SparqlConfig{Endpoint: server.URL}, nil)
+	require.NoError(t, err)
+
+	const graph = "urn:iow:graphs:test_release"
+	const triples = "<https://example.com/a> <https://schema.org/name> \"a\" .\n"
+
+	_, exists, err := client.GetGraph(context.Background(), graph)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	err = client.PutGraph(context.Background(), graph, strings.NewReader(triples))
+	require.NoError(t, err)
+	require.Equal(t, 1, store.Puts())
+
+	reader, exists, err := client.GetGraph(context.Background(), graph)
+	require.NoError(t, err)
+	require.True(t, exists)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, triples, string(data))
+
+	err = client.DeleteGraph(context.Background(), graph)
+	require.NoError(t, err)
+	require.Equal(t, 0, store.NumberOfGraphs())
+
+	err = client.DeleteGraph(context.Background(), graph)
+	require.NoError(t, err, "dropping a graph that doesn't exist should not be an error")
+}
+
+func TestGraphStoreErrorAndAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "admin" || pass != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		require.Equal(t, "urn:iow:graphs:test", r.URL.Query().Get("graph"))
+		require.Equal(t, "iow", r.URL.Query().Get("repository"), "existing query params on the endpoint should be kept")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	unauthenticated, err := NewGraphStoreClient(config.SparqlConfig{Endpoint: server.URL + "?repository=iow"}, nil)
+	require.NoError(t, err)
+	err = unauthenticated.PutGraph(context.Background(), "urn:iow:graphs:test", strings.NewReader(""))
+	var graphStoreErr GraphStoreError
+	require.ErrorAs(t, err, &graphStoreErr)
+	require.Equal(t, http.StatusUnauthorized, graphStoreErr.StatusCode)
+
+	authenticated, err := NewGraphStoreClient(config.SparqlConfig{Endpoint: server.URL + "?repository=iow", Username: "admin", Password: "secret"}, nil)
+	require.NoError(t, err)
+	err = authenticated.PutGraph(context.Background(), "urn:iow:graphs:test", strings.NewReader(""))
+	require.NoError(t, err)
+
+	_, err = NewGraphStoreClient(config.SparqlConfig{Endpoint: "localhost:7200"}, nil)
+	require.Error(t, err)
+}
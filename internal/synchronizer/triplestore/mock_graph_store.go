@@ -0,0 +1,99 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package triplestore
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// An in-memory stand-in for a triplestore that implements the subset of the
+// SPARQL 1.1 Graph Store HTTP Protocol used by nabu; mainly for testing
+type MockGraphStore struct {
+	mu sync.Mutex
+	// map of graph iri to the raw n-triples stored in it
+	graphs map[string]string
+	// the number of successful PUT requests that have been received
+	puts int
+}
+
+// Start a local http server that serves a new, empty MockGraphStore
+// The caller is responsible for closing the server
+func NewMockGraphStoreServer() (*MockGraphStore, *httptest.Server) {
+	store := &MockGraphStore{graphs: make(map[string]string)}
+	return store, httptest.NewServer(store)
+}
+
+func (m *MockGraphStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	graph := r.URL.Query().Get("graph")
+	if graph == "" {
+		http.Error(w, "only indirect graph identification with ?graph= is supported", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		data, ok := m.graphs[graph]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", NTriplesMimeType)
+		_, _ = io.WriteString(w, data)
+	case http.MethodPut:
+		if r.Header.Get("Content-Type") != NTriplesMimeType {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, existed := m.graphs[graph]
+		m.graphs[graph] = string(data)
+		m.puts++
+		if existed {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			w.WriteHeader(http.StatusCreated)
+		}
+	case http.MethodDelete:
+		if _, ok := m.graphs[graph]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		delete(m.graphs, graph)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Return the n-triples stored in the graph and whether it exists
+func (m *MockGraphStore) Graph(graphIRI string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.graphs[graphIRI]
+	return data, ok
+}
+
+// Return the number of graphs currently in the store
+func (m *MockGraphStore) NumberOfGraphs() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.graphs)
+}
+
+// Return the number of PUT requests the store has received
+func (m *MockGraphStore) Puts() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.puts
+}
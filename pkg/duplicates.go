@@ -0,0 +1,38 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// A top level @id that was published by more than one sitemap; the triples
+// from each document are merged in the graph since they describe the same node
+type DuplicateIdentifier struct {
+	// The top level @id of the documents
+	Id string
+	// The ids of the sitemaps that published a document with the @id
+	Sitemaps []string
+	// The urls of the documents with the @id; for
+	// bulk sitemaps this is the container that output it
+	Urls []string
+}
+
+// The top level @ids that were published by more than one sitemap in a harvest
+type DuplicateIdentifierReport struct {
+	// The number of distinct top level @ids in the documents harvested in this run;
+	// documents that were skipped since they were unchanged are not included
+	IdentifiersIndexed int
+	// Every @id that was published by more than one sitemap
+	Duplicates []DuplicateIdentifier
+}
+
+// Serialize the report to json and return the result as an io.Reader
+func (r DuplicateIdentifierReport) ToJsonIoReader() (io.Reader, error) {
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode(r)
+	return buf, err
+}
@@ -0,0 +1,87 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// What harvesting a particular sitemap would do, as determined by
+// a dry run that does not store or remove anything
+type SitemapHarvestPlan struct {
+	// The name of the sitemap in the sitemap index
+	SitemapName string
+	// The link to the sitemap itself
+	SitemapSourceLink string
+	// The number of total sites in the sitemap
+	SitesInSitemap int
+	// True if the entire sitemap would be skipped since its lastmod in the sitemap
+	// index has not advanced since the last successful harvest
+	SitemapUnchanged bool
+	// True if the sitemap is harvested by running a bulk container; the documents it
+	// produces aren't known until the container is run so no urls are planned
+	BulkSitemap bool
+	// The urls that would be fetched
+	UrlsToFetch []string
+	// The urls that would be skipped since they have not changed since the last
+	// harvest according to their hash, their lastmod, or a checkpoint
+	UrlsUnchanged []string
+	// The urls that would be skipped since robots.txt disallows crawling them
+	UrlsDisallowedByRobots []string
+	// The urls whose hash could not be checked; these would be crawl failures
+	HashCheckFailures []UrlCrawlError
+	// The objects in storage that would be removed as outdated jsonld;
+	// this is only set if cleanup of outdated jsonld is enabled
+	FilesToCleanup []string
+}
+
+// Summarize the plan for the sitemap in a single human readable line
+func (p SitemapHarvestPlan) Summary() string {
+	switch {
+	case p.SitemapUnchanged:
+		return fmt.Sprintf("%s: would be skipped since its lastmod has not changed since the last harvest", p.SitemapName)
+	case p.BulkSitemap:
+		return fmt.Sprintf("%s: is a bulk sitemap so all of its documents would be regenerated by its container", p.SitemapName)
+	}
+	return fmt.Sprintf("%s: %d urls; %d to fetch, %d unchanged, %d disallowed by robots.txt, %d failed hash checks, %d outdated files to remove",
+		p.SitemapName,
+		p.SitesInSitemap,
+		len(p.UrlsToFetch),
+		len(p.UrlsUnchanged),
+		len(p.UrlsDisallowedByRobots),
+		len(p.HashCheckFailures),
+		len(p.FilesToCleanup),
+	)
+}
+
+// The plan for every sitemap in a sitemap index
+type HarvestPlan []SitemapHarvestPlan
+
+// Serialize the harvest plan to json
+func (p HarvestPlan) ToJson() (string, error) {
+	if data, err := json.MarshalIndent(p, "", "  "); err != nil {
+		return "", err
+	} else {
+		return string(data), nil
+	}
+}
+
+// Summarize the plan for every sitemap with one line per sitemap followed by the totals
+func (p HarvestPlan) Summary() string {
+	var summary strings.Builder
+	toFetch, unchanged, disallowed, toCleanup := 0, 0, 0, 0
+	for _, sitemap := range p {
+		summary.WriteString(sitemap.Summary())
+		summary.WriteString("\n")
+		toFetch += len(sitemap.UrlsToFetch)
+		unchanged += len(sitemap.UrlsUnchanged)
+		disallowed += len(sitemap.UrlsDisallowedByRobots)
+		toCleanup += len(sitemap.FilesToCleanup)
+	}
+	fmt.Fprintf(&summary, "Total across %d sitemaps: %d urls to fetch, %d unchanged, %d disallowed by robots.txt, %d outdated files to remove",
+		len(p), toFetch, unchanged, disallowed, toCleanup)
+	return summary.String()
+}
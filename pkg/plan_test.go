@@ -0,0 +1,36 @@
+// Copyright 2026 Lincoln Institute of Land Policy
+// SPDX-License-Identifier: Apache-2.0
+
+package pkg
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHarvestPlanSummary(t *testing.T) {
+	plan := HarvestPlan{
+		{SitemapName: "unchanged", SitemapUnchanged: true},
+		{SitemapName: "bulk", BulkSitemap: true},
+		{
+			SitemapName:    "pids",
+			SitesInSitemap: 3,
+			UrlsToFetch:    []string{"https://example.com/1", "https://example.com/2"},
+			UrlsUnchanged:  []string{"https://example.com/3"},
+			FilesToCleanup: []string{"summoned/pids/old.jsonld"},
+		},
+	}
+	summary := plan.Summary()
+	require.Contains(t, summary, "unchanged: would be skipped")
+	require.Contains(t, summary, "bulk: is a bulk sitemap")
+	require.Contains(t, summary, "pids: 3 urls; 2 to fetch, 1 unchanged, 0 disallowed by robots.txt, 0 failed hash checks, 1 outdated files to remove")
+	require.Contains(t, summary, "Total across 3 sitemaps: 2 urls to fetch, 1 unchanged, 0 disallowed by robots.txt, 1 outdated files to remove")
+
+	asJson, err := plan.ToJson()
+	require.NoError(t, err)
+	var decoded HarvestPlan
+	require.NoError(t, json.Unmarshal([]byte(asJson), &decoded))
+	require.Equal(t, plan, decoded)
+}
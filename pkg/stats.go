@@ -22,6 +22,17 @@ const (
 	ShaclValid ShaclStatus = "valid"
 )
 
+// The category of a crawl error for errors that a client
+// may want to handle differently from other failures
+type UrlCrawlErrorCategory string
+
+const (
+	// The document was larger than the maximum document size so it was not harvested
+	DocumentTooLarge UrlCrawlErrorCategory = "document_too_large"
+	// A line in the output of a bulk source was not valid JSON or had no @id
+	InvalidBulkLine UrlCrawlErrorCategory = "invalid_bulk_line"
+)
+
 // An error for a particular URL in a sitemap
 type UrlCrawlError struct {
 	// The URL that failed
@@ -30,12 +41,40 @@ type UrlCrawlError struct {
 	Status int
 	// a natural language error message describing the error
 	Message string
+	// The number of times the URL was attempted before giving up; urls that fail
+	// with a transient error are attempted again once the rest of the sitemap is harvested
+	Attempts int
+	// The category of the error; empty if the error is uncategorized
+	Category UrlCrawlErrorCategory
+	// The line in the output of a bulk source that failed; 0 for urls that aren't in a bulk sitemap
+	Line int `json:",omitempty"`
+	// The start of the line in the output of a bulk source that failed
+	Snippet string `json:",omitempty"`
 }
 
 func (e UrlCrawlError) IsNil() bool {
 	return e.Url == "" && e.Status == 0 && e.Message == ""
 }
 
+// Why the container or executable that produces the documents of a bulk sitemap failed
+type BulkSourceFailure struct {
+	// The url in the bulk sitemap whose source failed, i.e. the docker image
+	Url string
+	// The exit status of the container or process; -1 if the status is unknown
+	ExitCode int
+	// True if the source was killed since it ran longer than the maximum runtime
+	TimedOut bool
+	// The end of what the source wrote to stderr
+	StderrTail string
+}
+
+func (e BulkSourceFailure) Error() string {
+	if e.TimedOut {
+		return fmt.Sprintf("bulk source %s was killed after running longer than the maximum runtime", e.Url)
+	}
+	return fmt.Sprintf("bulk source %s failed with exit status %d", e.Url, e.ExitCode)
+}
+
 // A warning for a particular URL in a sitemap
 type ShaclInfo struct {
 	// THe url against which shacl validation was run
@@ -96,6 +135,62 @@ type SitemapCrawlStats struct {
 	// An API specified in a given sitemap is assumed to be down if the first ~20 sites
 	// all failed without a single successful harvest
 	DatasetDown bool
+	// The number of sites that were not crawled again since they were already harvested
+	// before the checkpoint of a previous run; these are included in SuccessfulSites
+	SitesResumedFromCheckpoint int
+	// The number of sites that were not crawled since their lastmod in the sitemap
+	// has not advanced since the last harvest; these are included in SuccessfulSites
+	SitesWithUnchangedLastMod int
+	// The number of sites that responded 304 Not Modified to a conditional request
+	// using the ETag or Last-Modified from the last harvest; these are included in SuccessfulSites
+	SitesNotModified int
+	// The cumulative number of seconds that all workers spent waiting on the
+	// per host rate limiter; this may be greater than SecondsToComplete
+	SecondsWaitingOnRateLimit float64
+	// The number of sites that were skipped since robots.txt disallows
+	// crawling them; these are not included in SuccessfulSites or CrawlFailures
+	SitesDisallowedByRobots int
+	// True if the entire sitemap was skipped since its lastmod in the sitemap
+	// index has not advanced since the last successful harvest
+	SitemapUnchanged bool
+	// True if only a sample of the urls in the sitemap were harvested to smoke test it;
+	// SitesInSitemap is then the number of urls in the sample and no outdated data was removed
+	Sample bool
+	// The number of urls in the whole sitemap if only a sample of them were harvested
+	UrlsBeforeSample int
+	// The number of documents in a bulk sitemap that were not uploaded again since the
+	// md5 of the copy in storage matched; these are included in SuccessfulSites
+	UnchangedBulkDocuments int
+	// The number of lines in the output of a bulk sitemap that could not be harvested;
+	// only the first of these are kept in CrawlFailures
+	FailedBulkLines int
+	// Why the source of a bulk sitemap failed if it exited with a non zero status
+	// or was killed; this includes the end of its stderr. Nil if it didn't fail
+	BulkSourceFailure *BulkSourceFailure
+	// The settings the sitemap was harvested with once the command
+	// line flags were merged with the settings in the sitemap index
+	Settings SitemapHarvestSettings
+}
+
+// The settings that a sitemap was harvested with
+type SitemapHarvestSettings struct {
+	// The number of urls harvested in parallel
+	Workers int
+	// How strictly documents were validated; one of skip, warn, or strict
+	ShaclMode string
+	// The minimum delay between requests to each host in the sitemap that
+	// was set by the sitemap index; 0 if the sitemap index didn't set one
+	CrawlDelaySeconds float64
+	// Whether each page was rendered in headless chrome
+	RenderJavascript bool
+	// Whether jsonld that is no longer in the sitemap was removed
+	CleanupOutdatedJsonld bool
+	// The number of failed urls in a row before the dataset is assumed to be down
+	FailedSitesToAssumeDatasetDown int
+	// The maximum number of shacl errors stored in the crawl report
+	MaxShaclErrorsToStore int
+	// The names of the settings that came from the sitemap index rather than the command line
+	SetBySitemapIndex []string
 }
 
 // Serialize the sitemap crawl stats to json